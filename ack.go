@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+	"github.com/graphite-ng/carbon-relay-ng/nsqd"
+)
+
+// acknowledged delivery between relays.
+// a destination with ack=true speaks a framed protocol to another carbon-relay-ng listening on its ack_listen_addr.
+// every frame is a type byte, a 4 byte big endian body length, and the body:
+// * hello (sender -> receiver), first frame on every conn. body: the sender id
+// * batch (sender -> receiver). body: 8 byte big endian sequence number, followed by newline separated metrics
+// * ack (receiver -> sender). body: 8 byte big endian sequence number. acknowledges all batches up to and including it.
+// the sender keeps all batches in memory until they're acked, and resends them, in order, after reconnecting.
+// with spooling enabled, it also keeps them on disk, so that they can be spooled after a crash.
+// the receiver remembers the highest sequence number it has processed for each sender,
+// so that resent batches it already processed are acked but not processed again.
+
+const (
+	frameHello byte = iota + 1
+	frameBatch
+	frameAck
+)
+
+var ack_batch_size = 1000              // in metrics. batches are sent when full or at flush time, whichever comes first
+var ack_max_unacked = 100              // in batches. when this many are waiting for an ack, we stop taking in new data
+var ack_max_frame = 64 << 20           // in bytes. to protect against garbage input
+var ack_sender_expire = 24 * time.Hour // how long the receiver remembers senders it doesn't hear from
+
+// process start time, so that sequence numbers of a new process don't get confused with ones of the previous
+var ackSession = time.Now().UnixNano()
+
+func writeFrame(w io.Writer, typ byte, body []byte) error {
+	var hdr [5]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(body)))
+	_, err := w.Write(hdr[:])
+	if err == nil {
+		_, err = w.Write(body)
+	}
+	return err
+}
+
+func readFrame(r *bufio.Reader) (typ byte, body []byte, err error) {
+	var hdr [5]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if int64(size) > int64(ack_max_frame) {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the max of %d", size, ack_max_frame)
+	}
+	body = make([]byte, size)
+	_, err = io.ReadFull(r, body)
+	return hdr[0], body, err
+}
+
+func seqBytes(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+type ackBatch struct {
+	seq   uint64
+	lines [][]byte
+}
+
+// ackWindow holds the batches of a destination that are sent (or about to be) but not acked yet.
+// it outlives the conns, so that we can resend after reconnecting.
+type ackWindow struct {
+	sync.Mutex
+	sender    string
+	nextSeq   uint64
+	batches   []ackBatch
+	numAcked  int64 // amount of metrics acked in total
+	numLines  int
+	numUnAckd metrics.Gauge
+	// if set, holds the same batches as the window, in the same order, see openJournal
+	journal *nsqd.DiskQueue
+}
+
+func newAckWindow(sender, cleanAddr string) *ackWindow {
+	return &ackWindow{
+		sender:    sender,
+		nextSeq:   1,
+		numUnAckd: Gauge("dest=" + cleanAddr + ".unit=Metric.what=numUnacked"),
+	}
+}
+
+// add assigns a sequence number to the batch and adds it to the window
+func (w *ackWindow) add(lines [][]byte) ackBatch {
+	w.Lock()
+	defer w.Unlock()
+	b := ackBatch{w.nextSeq, lines}
+	w.nextSeq++
+	w.batches = append(w.batches, b)
+	w.journalPut(lines)
+	w.numLines += len(lines)
+	w.numUnAckd.Update(int64(w.numLines))
+	return b
+}
+
+// ack removes all batches up to and including seq
+func (w *ackWindow) ack(seq uint64) {
+	w.Lock()
+	defer w.Unlock()
+	i := 0
+	for ; i < len(w.batches) && w.batches[i].seq <= seq; i++ {
+		w.numAcked += int64(len(w.batches[i].lines))
+		w.numLines -= len(w.batches[i].lines)
+		if w.journal != nil && w.journal.Depth() > 0 {
+			<-w.journal.ReadChan()
+		}
+	}
+	w.batches = w.batches[i:]
+	w.numUnAckd.Update(int64(w.numLines))
+}
+
+func (w *ackWindow) pending() []ackBatch {
+	w.Lock()
+	defer w.Unlock()
+	return append([]ackBatch(nil), w.batches...)
+}
+
+func (w *ackWindow) full() bool {
+	w.Lock()
+	defer w.Unlock()
+	return len(w.batches) >= ack_max_unacked
+}
+
+func (w *ackWindow) acked() int64 {
+	w.Lock()
+	defer w.Unlock()
+	return w.numAcked
+}
+
+// takeAll empties the window and returns all metrics that were in it
+func (w *ackWindow) takeAll() (lines [][]byte) {
+	w.Lock()
+	defer w.Unlock()
+	for _, b := range w.batches {
+		lines = append(lines, b.lines...)
+	}
+	w.batches = nil
+	w.numLines = 0
+	w.numUnAckd.Update(0)
+	if w.journal != nil {
+		if err := w.journal.Empty(); err != nil {
+			log.Error("ack window of %s: can't empty journal: %s", w.sender, err)
+		}
+	}
+	return lines
+}
+
+// openJournal makes the window keep its batches on disk as well, in a diskqueue with the given name in dir.
+// every change is synced right away, so after a crash the journal holds the batches that weren't acked.
+// it returns the metrics of those, left by a previous process.
+func (w *ackWindow) openJournal(name, dir string) (lines [][]byte) {
+	w.Lock()
+	defer w.Unlock()
+	journal := nsqd.NewDiskQueue(name, dir, 200*1024*1024, 1, time.Second).(*nsqd.DiskQueue)
+	for n := journal.Depth(); n > 0; n-- {
+		lines = append(lines, bytes.Split(<-journal.ReadChan(), newLine)...)
+	}
+	w.journal = journal
+	for _, b := range w.batches {
+		w.journalPut(b.lines)
+	}
+	return lines
+}
+
+// closeJournal stops keeping the batches on disk. whatever is in the journal stays there
+func (w *ackWindow) closeJournal() {
+	w.Lock()
+	defer w.Unlock()
+	if w.journal != nil {
+		w.journal.Close()
+		w.journal = nil
+	}
+}
+
+// journalPut adds a batch to the journal, if any. the caller must hold the lock
+func (w *ackWindow) journalPut(lines [][]byte) {
+	if w.journal == nil {
+		return
+	}
+	if err := w.journal.Put(bytes.Join(lines, newLine)); err != nil {
+		log.Error("ack window of %s: can't write to journal: %s", w.sender, err)
+	}
+}
+
+// ackSenders tracks, on the receiving end, the highest sequence number processed for each sender
+var ackSenders = struct {
+	sync.Mutex
+	seq  map[string]uint64
+	seen map[string]time.Time
+}{seq: make(map[string]uint64), seen: make(map[string]time.Time)}
+
+// ackProcess returns whether the batch is new and should be processed, and marks it as processed.
+func ackProcess(sender string, seq uint64) bool {
+	ackSenders.Lock()
+	defer ackSenders.Unlock()
+	now := time.Now()
+	for s, t := range ackSenders.seen {
+		if now.Sub(t) > ack_sender_expire {
+			delete(ackSenders.seq, s)
+			delete(ackSenders.seen, s)
+		}
+	}
+	ackSenders.seen[sender] = now
+	if seq <= ackSenders.seq[sender] {
+		return false
+	}
+	ackSenders.seq[sender] = seq
+	return true
+}
+
+var numAckDuplicate metrics.Counter
+
+func ackListen(addr string, config Config, acl *acl) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l = aclListener{l, acl}
+	numAckDuplicate = Counter("unit=Metric.action=drop.reason=duplicate_batch")
+	log.Notice("listening on %v/tcp for acked delivery", l.Addr())
+	limits := newConnLimits("ack", config.Max_conns, config.Max_conns_per_ip)
+	go func() {
+		setListening("ack", true)
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				log.Error(err.Error())
+				setListening("ack", false)
+				return
+			}
+			if !limits.acquire(c) {
+				log.Info("rejecting ack conn from %s: too many connections", c.RemoteAddr())
+				c.Close()
+				continue
+			}
+			trackConn(c)
+			go func() {
+				handleAck(c, config)
+				untrackConn(c)
+				limits.release(c)
+			}()
+		}
+	}()
+	return l, nil
+}
+
+// handleAck reads batches from a sending relay, processes the ones we haven't seen before, and acks all of them.
+func handleAck(c net.Conn, config Config) {
+	defer c.Close()
+	r := bufio.NewReaderSize(c, 4096)
+	typ, body, err := readFrame(r)
+	if err != nil || typ != frameHello {
+		log.Error("ack conn from %s: expected hello frame. error: %v", c.RemoteAddr(), err)
+		return
+	}
+	sender := string(body)
+	peer := peerName(c.RemoteAddr())
+	for {
+		typ, body, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Error("ack conn from %s: %s", c.RemoteAddr(), err)
+			}
+			return
+		}
+		if typ != frameBatch || len(body) < 8 {
+			log.Error("ack conn from %s: expected batch frame", c.RemoteAddr())
+			return
+		}
+		seq := binary.BigEndian.Uint64(body)
+		lines := bytes.Split(body[8:], newLine)
+		if ackProcess(sender, seq) {
+			for _, buf := range lines {
+				if len(buf) != 0 {
+					handleLine(buf, peer, config)
+				}
+			}
+		} else {
+			numAckDuplicate.Inc(int64(len(lines)))
+		}
+		if err := writeFrame(c, frameAck, seqBytes(seq)); err != nil {
+			log.Error("ack conn from %s: %s", c.RemoteAddr(), err)
+			return
+		}
+	}
+}
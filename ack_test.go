@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	m20 "github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/metrics20/go-metrics20"
+	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
+)
+
+func initIngest() Config {
+	numIn = Counter("unit=Metric.direction=in")
+	numInvalid = Counter("unit=Err.type=invalid")
+	numTooLong = Counter("unit=Err.type=line_too_long")
+	numRepaired = Counter("unit=Metric.direction=repaired")
+	badMetrics = badmetrics.New(time.Minute, 1000)
+	var c Config
+	c.Legacy_metric_validation.Level = m20.Strict
+	return c
+}
+
+func TestAckWindow(t *testing.T) {
+	w := newAckWindow("test", "ackwindow")
+	w.add([][]byte{[]byte("a 1 1"), []byte("b 1 1")})
+	w.add([][]byte{[]byte("c 1 1")})
+	b := w.add([][]byte{[]byte("d 1 1")})
+	assert.Equal(t, uint64(3), b.seq)
+	w.ack(2)
+	assert.Equal(t, int64(3), w.acked())
+	pending := w.pending()
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, uint64(3), pending[0].seq)
+	assert.Equal(t, [][]byte{[]byte("d 1 1")}, w.takeAll())
+	assert.Equal(t, 0, len(w.pending()))
+}
+
+func TestAckWindowJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-ack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := newAckWindow("test", "ackjournal")
+	w.add([][]byte{[]byte("a 1 1"), []byte("b 1 1")}) // before opening the journal
+	assert.Equal(t, 0, len(w.openJournal("unacked_test", dir)))
+	w.add([][]byte{[]byte("c 1 1")})
+	w.add([][]byte{[]byte("d 1 1")})
+	w.ack(2)
+	// the process dies without taking the window out
+	w.closeJournal()
+
+	w = newAckWindow("test", "ackjournal")
+	assert.Equal(t, [][]byte{[]byte("d 1 1")}, w.openJournal("unacked_test", dir))
+	// recovering consumes them
+	w.closeJournal()
+	w = newAckWindow("test", "ackjournal")
+	assert.Equal(t, 0, len(w.openJournal("unacked_test", dir)))
+	w.add([][]byte{[]byte("e 1 1")})
+	// on a clean shutdown, what's taken out doesn't come back
+	assert.Equal(t, [][]byte{[]byte("e 1 1")}, w.takeAll())
+	w.closeJournal()
+	w = newAckWindow("test", "ackjournal")
+	assert.Equal(t, 0, len(w.openJournal("unacked_test", dir)))
+	w.closeJournal()
+}
+
+func TestAckDedupe(t *testing.T) {
+	config := initIngest()
+	table = NewTableOrFatal(t, "", "")
+	acl, _ := newACL("ack", aclConfig{})
+	l, err := ackListen("127.0.0.1:0", config, acl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	inBefore := numIn.Count()
+	dupBefore := numAckDuplicate.Count()
+
+	batch := append(seqBytes(1), []byte("a.b 1 1000000000\na.c 2 1000000000")...)
+	writeFrame(c, frameHello, []byte("dedupe-test"))
+	for i := 0; i < 2; i++ {
+		writeFrame(c, frameBatch, batch)
+		typ, body, err := readFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, frameAck, typ)
+		assert.Equal(t, uint64(1), binary.BigEndian.Uint64(body))
+	}
+	assert.Equal(t, int64(2), numIn.Count()-inBefore)
+	assert.Equal(t, int64(2), numAckDuplicate.Count()-dupBefore)
+}
+
+func TestAckDelivery(t *testing.T) {
+	config := initIngest()
+	tE := NewTestEndpoint(t, ":2005")
+	defer tE.Close()
+	na := tE.conditionNumAccepts(1)
+	ns := tE.conditionNumSeen(packets3A.amount)
+	tE.Start()
+	table = NewTableOrFatal(t, "", "addRoute sendAllMatch test1  127.0.0.1:2005 flush=10")
+	na.Allow(50 * time.Millisecond)
+	acl, _ := newACL("ack", aclConfig{})
+	l, err := ackListen("127.0.0.1:0", config, acl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	dest, err := NewDestination(Matcher{}, l.Addr().String(), "", false, false, true, "drop", 10*time.Millisecond, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, err := NewRouteSendAllMatch("acktest", Matcher{}, []*Destination{dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.Run()
+	time.Sleep(50 * time.Millisecond) // let the dest connect
+	for buf := range packets3A.All() {
+		route.Dispatch(buf)
+	}
+	ns.Allow(time.Second)
+	tE.SeenThisOrFatal(packets3A.All())
+	for i := 0; i < 100 && len(dest.window.pending()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(packets3A.amount), dest.window.acked())
+	route.Shutdown()
+	table.ShutdownOrFatal(t)
+	time.Sleep(100 * time.Millisecond)
+}
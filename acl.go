@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/BurntSushi/toml"
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+)
+
+// aclConfig is the config of the access control list of a listener.
+// entries are CIDRs (10.0.0.0/8) or plain IP addresses.
+// deny takes precedence over allow. an empty allow list allows everyone who's not denied.
+type aclConfig struct {
+	Allow []string
+	Deny  []string
+}
+
+// aclsConfig has an acl for each of our listeners
+type aclsConfig struct {
+	Tcp   aclConfig // ingest
+	Udp   aclConfig // ingest
+	Ack   aclConfig // ingest with acknowledgements
+	Admin aclConfig // telnet admin
+	Http  aclConfig // http admin
+}
+
+type aclRules struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func parseNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address '%s'", e)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			e = fmt.Sprintf("%s/%d", e, bits)
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func newACLRules(conf aclConfig) (aclRules, error) {
+	allow, err := parseNets(conf.Allow)
+	if err != nil {
+		return aclRules{}, err
+	}
+	deny, err := parseNets(conf.Deny)
+	if err != nil {
+		return aclRules{}, err
+	}
+	return aclRules{allow, deny}, nil
+}
+
+func (r aclRules) allowed(ip net.IP) bool {
+	for _, n := range r.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, n := range r.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// acl decides which peers may use a listener. the rules can be replaced at runtime.
+type acl struct {
+	listener    string
+	rules       atomic.Value // aclRules
+	numRejected metrics.Counter
+}
+
+func newACL(listener string, conf aclConfig) (*acl, error) {
+	a := &acl{
+		listener:    listener,
+		numRejected: Counter("listener=" + listener + ".unit=Conn.action=reject.reason=acl"),
+	}
+	return a, a.update(conf)
+}
+
+func (a *acl) update(conf aclConfig) error {
+	rules, err := newACLRules(conf)
+	if err != nil {
+		return fmt.Errorf("acl for %s listener: %s", a.listener, err)
+	}
+	a.rules.Store(rules)
+	return nil
+}
+
+// addrIP returns the ip address of addr, or nil if it doesn't have one
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// peerName describes the peer by its ip address, without the port, which changes with every connection
+func peerName(addr net.Addr) string {
+	if ip := addrIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr.String()
+}
+
+// allow returns whether the peer may use the listener. rejections are counted and logged.
+func (a *acl) allow(addr net.Addr) bool {
+	if a.check(addr) {
+		return true
+	}
+	log.Warning("%s listener: rejecting %s as per acl", a.listener, addr)
+	return false
+}
+
+// allowPacket is allow for datagrams. a peer sends lots of those, so rejections are only logged at debug level,
+// numRejected shows how many there are.
+func (a *acl) allowPacket(addr net.Addr) bool {
+	if a.check(addr) {
+		return true
+	}
+	log.Debug("%s listener: rejecting packet from %s as per acl", a.listener, addr)
+	return false
+}
+
+// check returns whether the peer may use the listener, and counts rejections
+func (a *acl) check(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if ip != nil && a.rules.Load().(aclRules).allowed(ip) {
+		return true
+	}
+	a.numRejected.Inc(1)
+	return false
+}
+
+// aclListener closes the conns its acl doesn't allow, right after accepting them
+type aclListener struct {
+	net.Listener
+	acl *acl
+}
+
+func (l aclListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.acl.allow(c.RemoteAddr()) {
+			return c, nil
+		}
+		c.Close()
+	}
+}
+
+// listenerACLs holds the acls of all our listeners
+type listenerACLs struct {
+	tcp   *acl
+	udp   *acl
+	ack   *acl
+	admin *acl
+	http  *acl
+}
+
+func newListenerACLs(conf aclsConfig) (*listenerACLs, error) {
+	var l listenerACLs
+	var err error
+	for _, a := range []struct {
+		dst  **acl
+		name string
+		conf aclConfig
+	}{
+		{&l.tcp, "tcp", conf.Tcp},
+		{&l.udp, "udp", conf.Udp},
+		{&l.ack, "ack", conf.Ack},
+		{&l.admin, "admin", conf.Admin},
+		{&l.http, "http", conf.Http},
+	} {
+		*a.dst, err = newACL(a.name, a.conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &l, nil
+}
+
+// update replaces the rules of all acls, or, if any of them is invalid, none.
+func (l *listenerACLs) update(conf aclsConfig) error {
+	// validate everything first
+	for _, c := range []aclConfig{conf.Tcp, conf.Udp, conf.Ack, conf.Admin, conf.Http} {
+		if _, err := newACLRules(c); err != nil {
+			return err
+		}
+	}
+	l.tcp.update(conf.Tcp)
+	l.udp.update(conf.Udp)
+	l.ack.update(conf.Ack)
+	l.admin.update(conf.Admin)
+	l.http.update(conf.Http)
+	return nil
+}
+
+// reloadACLs reads the acls from the config file again and applies them
+func reloadACLs(l *listenerACLs, file string) error {
+	var c Config
+	if _, err := toml.DecodeFile(file, &c); err != nil {
+		return err
+	}
+	err := l.update(c.Acl)
+	if err != nil {
+		return err
+	}
+	log.Notice("reloaded acls from %s", file)
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+func TestACLRules(t *testing.T) {
+	rules, err := newACLRules(aclConfig{
+		Allow: []string{"10.0.0.0/8", "192.168.1.1"},
+		Deny:  []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.0.0.1":    true,
+		"10.1.2.3":    false, // denied, even though 10.0.0.0/8 is allowed
+		"192.168.1.1": true,
+		"192.168.1.2": false, // not in the allow list
+	}
+	for ip, exp := range cases {
+		assert.Equal(t, exp, rules.allowed(net.ParseIP(ip)), ip)
+	}
+
+	rules, err = newACLRules(aclConfig{Deny: []string{"::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, rules.allowed(net.ParseIP("::1")))
+	assert.Equal(t, true, rules.allowed(net.ParseIP("127.0.0.1")))
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := newACLRules(aclConfig{Allow: []string{bad}}); err == nil {
+			t.Fatalf("expected an error for '%s'", bad)
+		}
+	}
+}
+
+// acceptAll hands all conns accepted by l to the returned channel
+func acceptAll(l net.Listener) chan net.Conn {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	return accepted
+}
+
+// expectAccepted dials l and checks whether the conn was accepted, or closed right away
+func expectAccepted(t *testing.T, l net.Listener, accepted chan net.Conn, exp bool) {
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case s := <-accepted:
+		s.Close()
+		if !exp {
+			t.Fatal("expected the conn to be rejected")
+		}
+	case <-time.After(100 * time.Millisecond):
+		if exp {
+			t.Fatal("expected the conn to be accepted")
+		}
+		// the rejected conn was closed on us
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := c.Read(make([]byte, 1))
+		if err == nil {
+			t.Fatal("expected the rejected conn to be closed")
+		}
+	}
+}
+
+func TestACLListener(t *testing.T) {
+	acl, err := newACL("test", aclConfig{Deny: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	al := aclListener{l, acl}
+	defer al.Close()
+	accepted := acceptAll(al)
+	before := acl.numRejected.Count()
+	expectAccepted(t, al, accepted, false)
+	assert.Equal(t, int64(1), acl.numRejected.Count()-before)
+
+	// an invalid update keeps the current rules
+	if acl.update(aclConfig{Allow: []string{"bogus"}}) == nil {
+		t.Fatal("expected an error for an invalid acl")
+	}
+	if err := acl.update(aclConfig{Allow: []string{"127.0.0.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	expectAccepted(t, al, accepted, true)
+}
+
+func TestReloadACLs(t *testing.T) {
+	acls, err := newListenerACLs(aclsConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "carbon-relay-ng-acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[acl.tcp]\nallow = [\"10.0.0.0/8\"]\n[acl.http]\ndeny = [\"127.0.0.1\"]\n")
+	f.Close()
+
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+	assert.Equal(t, true, acls.tcp.allow(local))
+	if err := reloadACLs(acls, f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, acls.tcp.allow(local))
+	assert.Equal(t, false, acls.http.allow(local))
+	assert.Equal(t, true, acls.udp.allowPacket(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}))
+}
+
+func TestACLPacket(t *testing.T) {
+	acl, err := newACL("test", aclConfig{Deny: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := acl.numRejected.Count()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, false, acl.allowPacket(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}))
+	}
+	assert.Equal(t, true, acl.allowPacket(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}))
+	assert.Equal(t, int64(3), acl.numRejected.Count()-before)
+}
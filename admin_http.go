@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	assetfs "github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/elazarl/go-bindata-assetfs"
 	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/gorilla/mux"
 	"github.com/graphite-ng/carbon-relay-ng/aggregator"
+	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -53,6 +56,46 @@ func (fn handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(bytes)
 }
 
+// tracked records the changes the handler makes to the table in the history
+func tracked(fn handler) handler {
+	return func(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+		var response interface{}
+		var herr *handlerError
+		history.track(table, "http", requestUser(r).Name, requestCommand(r), func() error {
+			response, herr = fn(w, r)
+			if herr != nil {
+				return errors.New(herr.Message)
+			}
+			return nil
+		})
+		return response, herr
+	}
+}
+
+func listHistory(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return nil, &handlerError{err, "Could not parse limit", http.StatusBadRequest}
+		}
+	}
+	return history.list(limit), nil
+}
+
+func undoHistory(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, &handlerError{err, "Could not parse id", http.StatusBadRequest}
+	}
+	err = history.undo(table, "http", requestUser(r).Name, id)
+	if err != nil {
+		return nil, &handlerError{err, "Could not undo", http.StatusConflict}
+	}
+	return map[string]string{"Message": "undone"}, nil
+}
+
 func listTable(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
 	t := table.Snapshot()
 	return t, nil
@@ -65,7 +108,19 @@ func badMetricsHandler(w http.ResponseWriter, r *http.Request) (interface{}, *ha
 		return nil, &handlerError{err, "Could not parse timespec", http.StatusBadRequest}
 	}
 
-	records := badMetrics.Get(duration)
+	q := r.URL.Query()
+	filter := badmetrics.Filter{
+		Oldest:  time.Now().Add(-duration),
+		ErrType: q.Get("type"),
+		Prefix:  q.Get("prefix"),
+	}
+	if minCount := q.Get("min_count"); minCount != "" {
+		filter.MinCount, err = strconv.ParseInt(minCount, 10, 64)
+		if err != nil {
+			return nil, &handlerError{err, "Could not parse min_count", http.StatusBadRequest}
+		}
+	}
+	records := badMetrics.GetFiltered(filter)
 	return records, nil
 }
 
@@ -79,6 +134,33 @@ func removeBlacklist(w http.ResponseWriter, r *http.Request) (interface{}, *hand
 	return make(map[string]string), nil
 }
 
+func removeWhitelist(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	index := mux.Vars(r)["index"]
+	idx, _ := strconv.Atoi(index)
+	err := table.DelWhitelist(idx)
+	if err != nil {
+		return nil, &handlerError{err, "Could not find entry " + index, http.StatusNotFound}
+	}
+	return make(map[string]string), nil
+}
+
+// addWhitelist adds a whitelist entry. body: {"prefix": "foo."}, or with "substring", "regex", "glob",
+// their negations "notprefix", "notsubstring" and "notregex", and alternatives in "or": [{"prefix": "bar."}]
+func addWhitelist(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	var matcher Matcher
+	if err := json.NewDecoder(r.Body).Decode(&matcher); err != nil {
+		return nil, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
+	}
+	if matcher.Empty() {
+		return nil, &handlerError{errors.New("empty matcher"), "Need a prefix, substring, regex, glob, negation or alternatives", http.StatusBadRequest}
+	}
+	if err := matcher.compile(); err != nil {
+		return nil, &handlerError{err, "Couldn't create matcher", http.StatusBadRequest}
+	}
+	table.AddWhitelist(&matcher)
+	return map[string]string{"Message": "whitelist entry added"}, nil
+}
+
 func removeAggregator(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
 	index := mux.Vars(r)["index"]
 	idx, _ := strconv.Atoi(index)
@@ -100,6 +182,29 @@ func removeDestination(w http.ResponseWriter, r *http.Request) (interface{}, *ha
 	return make(map[string]string), nil
 }
 
+// updateDestination changes options of a destination, like modDest does.
+// the body is a json object with the options as keys, and the new values as strings, i.e. {"maxrate": "1000"}
+func updateDestination(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	key := mux.Vars(r)["key"]
+	index := mux.Vars(r)["index"]
+	idx, err := strconv.Atoi(index)
+	if err != nil {
+		return nil, &handlerError{err, "Invalid index " + index, http.StatusBadRequest}
+	}
+	var opts map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		return nil, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
+	}
+	if table.GetRoute(key) == nil {
+		return nil, &handlerError{fmt.Errorf("no such route"), "Could not find route " + key, http.StatusNotFound}
+	}
+	err = table.UpdateDestination(key, idx, opts)
+	if err != nil {
+		return nil, &handlerError{err, "Could not update destination", http.StatusBadRequest}
+	}
+	return map[string]string{"Message": "destination updated"}, nil
+}
+
 func listRoutes(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
 	t := table.Snapshot()
 	return t.Routes, nil
@@ -124,14 +229,25 @@ func removeRoute(w http.ResponseWriter, r *http.Request) (interface{}, *handlerE
 }
 func parseRouteRequest(r *http.Request) (Route, *handlerError) {
 	var request struct {
-		Address   string
-		Key       string
-		Pickle    bool
-		Spool     bool
-		Type      string
-		Substring string
-		Prefix    string
-		Regex     string
+		Address      string
+		Key          string
+		Pickle       bool
+		Spool        bool
+		Ack          bool
+		OnFull       string
+		BufSize      int
+		WriteBuf     int
+		MaxRate      int64
+		Weight       int64 // only used by weighted routes. defaults to 1
+		Type         string
+		Substring    string
+		Prefix       string
+		Regex        string
+		Glob         string
+		NotPrefix    string
+		NotSubstring string
+		NotRegex     string
+		Or           []*Matcher
 	}
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		return nil, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
@@ -140,16 +256,54 @@ func parseRouteRequest(r *http.Request) (Route, *handlerError) {
 	// readDestinations
 	periodFlush := time.Duration(1000) * time.Millisecond
 	periodReconn := time.Duration(10000) * time.Millisecond
-	dest, err := NewDestination("", "", "", request.Address, table.spoolDir, request.Spool, request.Pickle, periodFlush, periodReconn)
+	if request.OnFull == "" {
+		request.OnFull = "drop"
+	}
+	if request.BufSize == 0 {
+		request.BufSize = conn_in_buffer
+	}
+	if request.WriteBuf == 0 {
+		request.WriteBuf = bufio_buffer_size
+	}
+	if request.Weight == 0 {
+		request.Weight = 1
+	}
+	matcher := Matcher{
+		Prefix:    request.Prefix,
+		Sub:       request.Substring,
+		Regex:     request.Regex,
+		Glob:      request.Glob,
+		NotPrefix: request.NotPrefix,
+		NotSub:    request.NotSubstring,
+		NotRegex:  request.NotRegex,
+		Or:        request.Or,
+	}
+	if err := matcher.compile(); err != nil {
+		return nil, &handlerError{err, "Couldn't create matcher", http.StatusBadRequest}
+	}
+	dest, err := NewDestination(Matcher{}, request.Address, table.spoolDir, request.Spool, request.Pickle, request.Ack, request.OnFull, periodFlush, periodReconn)
 	if err != nil {
 		return nil, &handlerError{err, "unable to create destination", http.StatusBadRequest}
 	}
+	if err := dest.SetBufSizes(request.BufSize, request.WriteBuf); err != nil {
+		return nil, &handlerError{err, "unable to create destination", http.StatusBadRequest}
+	}
+	if err := dest.SetMaxRate(request.MaxRate); err != nil {
+		return nil, &handlerError{err, "unable to create destination", http.StatusBadRequest}
+	}
+	if err := dest.SetWeight(request.Weight); err != nil {
+		return nil, &handlerError{err, "unable to create destination", http.StatusBadRequest}
+	}
 	var route Route
 	switch request.Type {
 	case "sendAllMatch":
-		route, err = NewRouteSendAllMatch(request.Key, request.Prefix, request.Substring, request.Regex, []*Destination{dest})
+		route, err = NewRouteSendAllMatch(request.Key, matcher, []*Destination{dest})
 	case "sendFirstMatch":
-		route, err = NewRouteSendFirstMatch(request.Key, request.Prefix, request.Substring, request.Regex, []*Destination{dest})
+		route, err = NewRouteSendFirstMatch(request.Key, matcher, []*Destination{dest})
+	case "roundRobin":
+		route, err = NewRouteRoundRobin(request.Key, matcher, []*Destination{dest})
+	case "weighted":
+		route, err = NewRouteWeighted(request.Key, matcher, []*Destination{dest})
 	default:
 		return nil, &handlerError{nil, "unknown route type: " + request.Type, http.StatusBadRequest}
 	}
@@ -162,11 +316,18 @@ func parseAggregateRequest(r *http.Request) (*aggregator.Aggregator, *handlerErr
 		Interval uint
 		Wait     uint
 		Regex    string
+		Glob     string // used instead of Regex, if set
 	}
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		return nil, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
 	}
-	aggregate, err := aggregator.New(request.Fun, request.Regex, request.OutFmt, request.Interval, request.Wait, table.In)
+	var aggregate *aggregator.Aggregator
+	var err error
+	if request.Glob != "" {
+		aggregate, err = aggregator.NewGlob(request.Fun, request.Glob, request.OutFmt, request.Interval, request.Wait, table.In)
+	} else {
+		aggregate, err = aggregator.New(request.Fun, request.Regex, request.OutFmt, request.Interval, request.Wait, table.In)
+	}
 	if err != nil {
 		return nil, &handlerError{err, "Couldn't create aggregator", http.StatusBadRequest}
 	}
@@ -208,7 +369,37 @@ func addRoute(w http.ResponseWriter, r *http.Request) (interface{}, *handlerErro
 	return map[string]string{"Message": "route added"}, nil
 }
 
-func HttpListener(addr string, t *Table) {
+// applyBatch applies a list of commands (see the telnet help) all at once, or, if any of them fails, not at all.
+// body: {"Commands": ["addBlack prefix foo", "modDest carbon-default 0 addr=1.2.3.4:2003"]}
+func applyBatch(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	var request struct {
+		Commands []string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
+	}
+	if len(request.Commands) == 0 {
+		return nil, &handlerError{errors.New("no commands"), "Empty batch", http.StatusBadRequest}
+	}
+	batch := table.Begin()
+	for i, cmd := range request.Commands {
+		err := batch.Apply(cmd)
+		if err != nil {
+			batch.Abort()
+			return nil, &handlerError{err, fmt.Sprintf("Command #%d failed, nothing applied", i+1), http.StatusBadRequest}
+		}
+	}
+	err := batch.Commit()
+	if err == errTableChanged {
+		return nil, &handlerError{err, "Couldn't apply batch", http.StatusConflict}
+	}
+	if err != nil {
+		return nil, &handlerError{err, "Couldn't apply batch", http.StatusInternalServerError}
+	}
+	return map[string]string{"Message": fmt.Sprintf("%d commands applied", len(request.Commands))}, nil
+}
+
+func HttpListener(addr string, t *Table, acl *acl) {
 	table = t
 
 	// setup routes
@@ -217,25 +408,44 @@ func HttpListener(addr string, t *Table) {
 	router.Handle("/badMetrics/{timespec}.json", handler(badMetricsHandler)).Methods("GET")
 	// table
 	router.Handle("/table", handler(listTable)).Methods("GET")
+	router.Handle("/table/batch", tracked(applyBatch)).Methods("POST")
+	// health checks
+	router.HandleFunc("/health", healthHandler).Methods("GET")
+	router.HandleFunc("/ready", readyHandler).Methods("GET")
+	// our own instrumentation, in prometheus format
+	router.HandleFunc("/metrics", promMetricsHandler).Methods("GET")
 	// blacklist
-	router.Handle("/blacklists/{index}", handler(removeBlacklist)).Methods("DELETE")
+	router.Handle("/blacklists/{index}", tracked(removeBlacklist)).Methods("DELETE")
+	// whitelist
+	router.Handle("/whitelists", tracked(addWhitelist)).Methods("POST")
+	router.Handle("/whitelists/{index}", tracked(removeWhitelist)).Methods("DELETE")
 	// aggregator
-	router.Handle("/aggregators/{index}", handler(removeAggregator)).Methods("DELETE")
-	router.Handle("/aggregators", handler(addAggregate)).Methods("POST")
+	router.Handle("/aggregators/{index}", tracked(removeAggregator)).Methods("DELETE")
+	router.Handle("/aggregators", tracked(addAggregate)).Methods("POST")
 	// routes
 	router.Handle("/routes", handler(listRoutes)).Methods("GET")
-	router.Handle("/routes", handler(addRoute)).Methods("POST")
+	router.Handle("/routes", tracked(addRoute)).Methods("POST")
 	router.Handle("/routes/{key}", handler(getRoute)).Methods("GET")
 	//router.Handle("/routes/{key}", handler(updateRoute)).Methods("POST")
-	router.Handle("/routes/{key}", handler(removeRoute)).Methods("DELETE")
+	router.Handle("/routes/{key}", tracked(removeRoute)).Methods("DELETE")
 	// destinations
-	router.Handle("/routes/{key}/destinations/{index}", handler(removeDestination)).Methods("DELETE")
+	router.Handle("/routes/{key}/destinations/{index}", tracked(updateDestination)).Methods("POST")
+	router.Handle("/routes/{key}/destinations/{index}", tracked(removeDestination)).Methods("DELETE")
+	// history of changes
+	router.Handle("/history", handler(listHistory)).Methods("GET")
+	router.Handle("/history/{id}/undo", handler(undoHistory)).Methods("POST")
+
+	// pprof and expvar register their handlers on the default mux. we don't serve that one,
+	// only this router, so they can't be reached without going through authHandler
+	router.PathPrefix("/debug/").Handler(http.DefaultServeMux)
 
 	router.PathPrefix("/").Handler(http.FileServer(&assetfs.AssetFS{Asset: Asset, AssetDir: AssetDir, Prefix: "admin_http_assets/"}))
-	http.Handle("/", router)
 
 	log.Notice("admin HTTP listener starting on %v", addr)
-	err := http.ListenAndServe(addr, nil)
+	l, err := net.Listen("tcp", addr)
+	if err == nil {
+		err = http.Serve(aclListener{l, acl}, authHandler{router})
+	}
 	if err != nil {
 		fmt.Println("Error listening:", err.Error())
 		os.Exit(1)
@@ -10,7 +10,7 @@ app.controller("MainCtl", ["$scope", "$resource", "$modal", function($scope, $re
 
 
   $scope.validAddress = /^[^:]+\:[0-9]+(:[^:]+)?$/;
-  $scope.validRouteType = /^(send(All|First)Match)|(consistentHashing)/
+  $scope.validRouteType = /^(send(All|First)Match)|(consistentHashing)|(roundRobin)|(weighted)/
   $scope.validRegex = (function() {
       return {
           test: function(value) {
@@ -47,6 +47,15 @@ app.controller("MainCtl", ["$scope", "$resource", "$modal", function($scope, $re
 
   $scope.list();
 
+  // rules that didn't take any metric for a day are highlighted, so dead ones can be found and removed
+  $scope.deadAfter = 24 * 3600;
+  $scope.isDead = function(rule) {
+    return !rule.lastHit || Date.now() / 1000 - rule.lastHit > $scope.deadAfter;
+  };
+  $scope.lastHit = function(rule) {
+    return rule.lastHit ? new Date(rule.lastHit * 1000).toLocaleString() : "never";
+  };
+
   $scope.newAgg = new Aggregator({Type: "agg", Interval:60, Wait: 120});
   $scope.addAggregator = function() {
     $scope.alerts = [];
@@ -2,8 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/graphite-ng/carbon-relay-ng/telnet"
 	"net"
+	"strconv"
 	"strings"
 )
 
@@ -14,7 +16,54 @@ func max(a, b int) int {
 	return b
 }
 
+// telnetUser returns who logged in on the conn of the request, if anyone
+func telnetUser(req telnet.Req) (adminUser, bool) {
+	if !adminAuth.enabled() {
+		return anonymous, true
+	}
+	name, ok := req.Session["user"].(string)
+	role, _ := req.Session["role"].(string)
+	return adminUser{Name: name, Role: role}, ok
+}
+
+// telnetAuthorize returns the user of the request, or an error if they are not allowed to act as role
+func telnetAuthorize(req telnet.Req, role string) (adminUser, error) {
+	user, ok := telnetUser(req)
+	if !ok {
+		return user, errors.New("login required")
+	}
+	if !user.may(role) {
+		log.Warning("telnet admin: %s from %s is not allowed to %s", user.Name, (*req.Conn).RemoteAddr(), req.Command[0])
+		return user, errors.New("permission denied")
+	}
+	return user, nil
+}
+
+func tcpLoginHandler(req telnet.Req) (err error) {
+	var name, token string
+	switch len(req.Command) {
+	case 2:
+		token = req.Command[1]
+	case 3:
+		name, token = req.Command[1], req.Command[2]
+	default:
+		return errors.New("usage: login [<user>] <token>")
+	}
+	user, ok := adminAuth.byToken(name, token)
+	if !ok {
+		log.Warning("telnet admin: login failed from %s", (*req.Conn).RemoteAddr())
+		return errors.New("login failed")
+	}
+	req.Session["user"] = user.Name
+	req.Session["role"] = user.Role
+	(*req.Conn).Write([]byte("ok\n"))
+	return
+}
+
 func tcpViewHandler(req telnet.Req) (err error) {
+	if _, err := telnetAuthorize(req, roleRead); err != nil {
+		return err
+	}
 	if len(req.Command) != 1 {
 		return errors.New("extraneous arguments")
 	}
@@ -23,10 +72,92 @@ func tcpViewHandler(req telnet.Req) (err error) {
 }
 
 func tcpModHandler(req telnet.Req) (err error) {
-	err = applyCommand(table, strings.Join(req.Command, " "))
+	user, err := telnetAuthorize(req, roleWrite)
+	if err != nil {
+		return err
+	}
+	cmd := strings.Join(req.Command, " ")
+	if batch, ok := req.Session["batch"].(*TableBatch); ok {
+		err = batch.Apply(cmd)
+		if err != nil {
+			return fmt.Errorf("%s. the batch will not be applied", err)
+		}
+		(*req.Conn).Write([]byte("staged\n"))
+		return
+	}
+	err = history.track(table, "telnet", user.Name, cmd, func() error {
+		return applyCommand(table, cmd)
+	})
+	if err != nil {
+		return err
+	}
+	audit.record(user, (*req.Conn).RemoteAddr().String(), "telnet", cmd)
+	(*req.Conn).Write([]byte("ok\n"))
+	return
+}
+
+// tcpBatchHandler handles begin, commit and abort of a batch of commands, which are applied all at once, or not at all
+func tcpBatchHandler(req telnet.Req) (err error) {
+	user, err := telnetAuthorize(req, roleWrite)
 	if err != nil {
 		return err
 	}
+	if len(req.Command) != 1 {
+		return errors.New("extraneous arguments")
+	}
+	batch, inBatch := req.Session["batch"].(*TableBatch)
+	switch req.Command[0] {
+	case "begin":
+		if inBatch {
+			return errors.New("already in a batch. commit or abort it first")
+		}
+		req.Session["batch"] = table.Begin()
+	case "commit":
+		if !inBatch {
+			return errors.New("not in a batch")
+		}
+		delete(req.Session, "batch")
+		cmd := "commit: " + strings.Join(batch.Commands(), "; ")
+		err = history.track(table, "telnet", user.Name, cmd, batch.Commit)
+		if err != nil {
+			return err
+		}
+		audit.record(user, (*req.Conn).RemoteAddr().String(), "telnet", cmd)
+	case "abort":
+		if !inBatch {
+			return errors.New("not in a batch")
+		}
+		delete(req.Session, "batch")
+		batch.Abort()
+	}
+	(*req.Conn).Write([]byte("ok\n"))
+	return
+}
+
+// tcpCloseHandler aborts the batch a conn was in the middle of, if any
+func tcpCloseHandler(session map[string]interface{}) {
+	if batch, ok := session["batch"].(*TableBatch); ok {
+		batch.Abort()
+	}
+}
+
+func tcpUndoHandler(req telnet.Req) (err error) {
+	user, err := telnetAuthorize(req, roleWrite)
+	if err != nil {
+		return err
+	}
+	if len(req.Command) != 2 {
+		return errors.New("usage: undo <id>")
+	}
+	id, err := strconv.ParseInt(req.Command[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	err = history.undo(table, "telnet", user.Name, id)
+	if err != nil {
+		return err
+	}
+	audit.record(user, (*req.Conn).RemoteAddr().String(), "telnet", strings.Join(req.Command, " "))
 	(*req.Conn).Write([]byte("ok\n"))
 	return
 }
@@ -46,15 +177,31 @@ func writeHelp(conn net.Conn, write_first []byte) { // bytes.Buffer
 	help := `
 commands:
     help                                         show this menu
+    login [<user>] <token>                       authenticate, if admin users are configured
     view                                         view full current routing table
+    begin                                        start a batch: the add and mod commands that follow are applied all at once on commit,
+                                                 or, if any of them fails, not at all
+    commit                                       apply the commands of the batch
+    abort                                        discard the commands of the batch
+    undo <id>                                    undo the change with this id in the history (see /history on the http interface)
 
-    addBlack <prefix|sub|regex> <substring>      blacklist (drops matching metrics as soon as they are received)
+    addBlack <prefix|sub|regex|glob> <substring>      blacklist (drops matching metrics as soon as they are received)
+    addWhite <prefix|sub|regex|glob> <substring>      whitelist (once there are whitelist entries, drops all metrics not matching any of them.
+                                                 the blacklist is applied first)
+                                                 both also take notprefix, notsub and notregex, several <type> <pattern> pairs
+                                                 which all must match, and alternatives after "or". i.e. prefix stats. notsub .test. or prefix foo.
+    delWhite <index>                             remove the whitelist entry with this index (see view)
+    addDefault <routeKey>                        make this route the default route: it gets all metrics that no other route takes,
+                                                 and only those, regardless of its own matching options
+    delDefault                                   unset the default route. metrics that no route takes are dropped again
 
     addAgg <func> <regex> <fmt> <interval> <wait>  add a new aggregation rule.
              <func>:                             aggregation function to use
                sum
                avg
              <regex>                             regex to match incoming metrics. supports groups (numbered, see fmt)
+                                                 or glob=<pattern> to match with a graphite glob instead. its wildcards are numbered
+                                                 from left to right, i.e. glob=servers.*.cpu.{user,system} has $1 and $2
              <fmt>                               format of output metric. you can use $1, $2, etc to refer to numbered groups
              <interval>                          align odd timestamps of metrics into buckets by this interval in seconds.
              <wait>                              amount of seconds to wait for "late" metric messages before computing and flushing final result.
@@ -65,20 +212,50 @@ commands:
                sendAllMatch                      send metrics in the route to all destinations
                sendFirstMatch                    send metrics in the route to the first one that matches it
                consistentHashing                 distribute metrics between destinations using a hash algorithm
+               roundRobin                        distribute metrics evenly between destinations, skipping the ones that are down
+               weighted                          distribute metrics between destinations according to their weight, skipping the ones that are down
              <opts>:
                prefix=<str>                      only take in metrics that have this prefix
                sub=<str>                         only take in metrics that match this substring
                regex=<regex>                     only take in metrics that match this regex (expensive!)
+               glob=<pattern>                    only take in metrics whose name matches this graphite glob. i.e. servers.*.cpu.{user,system}
+               notprefix=<str>                   only take in metrics that don't have this prefix
+               notsub=<str>                      only take in metrics that don't match this substring
+               notregex=<regex>                  only take in metrics that don't match this regex
+               or                                start an alternative: the route also takes in metrics matching the options after it.
+                                                 i.e. prefix=stats. notsub=.test. or prefix=collectd.
              <dest>: <addr> <opts>
                <addr>                            a tcp endpoint. i.e. ip:port or hostname:port
                <opts>:
                    prefix=<str>                  only take in metrics that have this prefix
                    sub=<str>                     only take in metrics that match this substring
                    regex=<regex>                 only take in metrics that match this regex (expensive!)
+                   glob=<pattern>                only take in metrics whose name matches this graphite glob
+                   notprefix=<str>               only take in metrics that don't have this prefix
+                   notsub=<str>                  only take in metrics that don't match this substring
+                   notregex=<regex>              only take in metrics that don't match this regex
+                   or                            start an alternative for the matching options, like for routes
                    flush=<int>                   flush interval in ms
                    reconn=<int>                  reconnection interval in ms
                    pickle={true,false}           pickle output format instead of the default text protocol
                    spool={true,false}            enable spooling for this endpoint
+                   onfull={drop,spool,block}     what to do when this endpoint can't keep up (default drop). spool requires spool=true
+                   ack={true,false}              use acknowledged delivery. the endpoint must be a carbon-relay-ng ack_listen_addr
+                   resolve=<int>                 re-resolve the hostname every this many ms (default 0: only when connecting)
+                   multiaddr={true,false}        connect to every address the hostname resolves to, and spread the data over them
+                   connections=<int>             amount of connections to open (per address, with multiaddr). default 1
+                   spread={hash,roundrobin}      how to spread the data over the connections (default hash: per metric name)
+                   bufsize=<int>                 amount of metrics each connection can buffer in memory (default 1500000)
+                   writebuf=<int>                size in bytes of the write buffer of each connection (default 2000000)
+                   maxrate=<int>                 max amount of metrics per second to send. over the limit goes to the spool if enabled, or is dropped (default 0: unlimited)
+                   weight=<int>                  share of the metrics relative to the other destinations, for weighted routes (1-1000, default 1)
+                   prometheus={true,false}       instead of sending the metrics to <addr>, keep the latest value of each series in memory
+                                                 and serve them on http://<addr>/metrics. i.e. 0.0.0.0:9108 prometheus=true
+                   expire=<int>                  for prometheus: drop series that weren't updated for this many seconds (default 300)
+                   map=<pattern>:<template>      for prometheus: map graphite names to prometheus names and labels. can be repeated.
+                                                 a * node captures one node, refer to captured nodes with $1, $2, etc.
+                                                 i.e. map=servers.*.cpu.*:cpu{host="$1",type="$2"}
+                                                 metrics that match no mapping are exported with their name sanitized
 
     addDest <routeKey> <dest>                    not implemented yet
 
@@ -87,24 +264,46 @@ commands:
                    prefix=<str>                  new matcher prefix
                    sub=<str>                     new matcher substring
                    regex=<regex>                 new matcher regex
+                   glob=<pattern>                new matcher glob
+                   notprefix=<str>               new matcher notprefix
+                   notsub=<str>                  new matcher notsub
+                   notregex=<regex>              new matcher notregex
+                   bufsize=<int>                 new amount of metrics each connection can buffer. reopens the connections
+                   writebuf=<int>                new write buffer size in bytes. reopens the connections
+                   maxrate=<int>                 new max amount of metrics per second. 0 for unlimited
+                   weight=<int>                  new weight, for weighted routes
 
     modRoute <routeKey> <opts>:                  modify route by updating one or more space separated option strings
                    prefix=<str>                  new matcher prefix
                    sub=<str>                     new matcher substring
                    regex=<regex>                 new matcher regex
+                   glob=<pattern>                new matcher glob
+                   notprefix=<str>               new matcher notprefix
+                   notsub=<str>                  new matcher notsub
+                   notregex=<regex>              new matcher notregex
 
 
 `
 	conn.Write([]byte(help))
 }
 
-func adminListener(addr string) error {
+func adminListener(addr string, acl *acl) error {
+	telnet.HandleFunc("login", tcpLoginHandler)
 	telnet.HandleFunc("add", tcpModHandler)
 	telnet.HandleFunc("del", tcpModHandler)
 	telnet.HandleFunc("mod", tcpModHandler)
+	telnet.HandleFunc("undo", tcpUndoHandler)
+	telnet.HandleFunc("begin", tcpBatchHandler)
+	telnet.HandleFunc("commit", tcpBatchHandler)
+	telnet.HandleFunc("abort", tcpBatchHandler)
+	telnet.HandleClose(tcpCloseHandler)
 	telnet.HandleFunc("view", tcpViewHandler)
 	telnet.HandleFunc("help", tcpHelpHandler)
 	telnet.HandleFunc("", tcpDefaultHandler)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
 	log.Notice("admin TCP listener starting on %v", addr)
-	return telnet.ListenAndServe(addr)
+	return telnet.Serve(aclListener{l, acl})
 }
@@ -6,8 +6,17 @@ import (
 	"regexp"
 	"strconv"
 	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+	"github.com/graphite-ng/carbon-relay-ng/glob"
 )
 
+// HitCounter returns the counter in which an aggregator counts its hits, given a description of the aggregator.
+// the relay sets it to register the counters in its metrics registry.
+var HitCounter = func(desc string) metrics.Counter {
+	return metrics.NewCounter()
+}
+
 type Func func(in []float64) float64
 
 func Sum(in []float64) float64 {
@@ -37,14 +46,21 @@ type Aggregator struct {
 	out          chan []byte    // outgoing metrics
 	Regex        string         `json:"regex,omitempty"`
 	regex        *regexp.Regexp // compiled version of Regex
-	prefix       []byte         // automatically generated based on regex, for fast preMatch
+	Glob         string         `json:"glob,omitempty"` // used instead of Regex
+	glob         *glob.Glob     // compiled version of Glob
+	prefix       []byte         // automatically generated based on regex or glob, for fast preMatch
 	OutFmt       string
 	outFmt       []byte
 	Interval     uint             // expected interval between values in seconds, we will quantize to make sure alginment to interval-spaced timestamps
 	Wait         uint             // seconds to wait after quantized time value before flushing final outcome and ignoring future values that are sent too late.
+	Hits         int64            `json:"hits"`    // metrics that matched
+	LastHit      int64            `json:"lastHit"` // unix timestamp of the last match, 0 if never
+	numHits      metrics.Counter  // hits, as registered with HitCounter
 	aggregations []aggregation    // aggregations in process: one for each quantized timestamp and output key, i.e. for each output metric.
 	snapReq      chan bool        // chan to issue snapshot requests on
 	snapResp     chan *Aggregator // chan on which snapshot response gets sent
+	flushReq     chan bool        // chan to request flushing all aggregations, regardless of their age
+	flushResp    chan bool        // chan on which we signal the flush-all is done
 	shutdown     chan bool        // chan used internally to shut down
 }
 
@@ -76,35 +92,72 @@ func regexToPrefix(regex string) []byte {
 	return []byte(substr)
 }
 
-// New creates an aggregator
+// New creates an aggregator that matches metrics with a regex
 func New(fun, regex, outFmt string, interval, wait uint, out chan []byte) (*Aggregator, error) {
 	regexObj, err := regexp.Compile(regex)
 	if err != nil {
 		return nil, err
 	}
+	agg, err := newAggregator(fun, outFmt, interval, wait, out)
+	if err != nil {
+		return nil, err
+	}
+	agg.Regex = regex
+	agg.regex = regexObj
+	agg.prefix = regexToPrefix(regex)
+	agg.numHits = HitCounter(fmt.Sprintf("%s %s %s", fun, regex, outFmt))
+	go agg.run()
+	return agg, nil
+}
+
+// NewGlob creates an aggregator that matches metrics with a graphite glob pattern.
+// in outFmt, $1, $2, etc refer to what the wildcards of the pattern matched, from left to right.
+func NewGlob(fun, pattern, outFmt string, interval, wait uint, out chan []byte) (*Aggregator, error) {
+	globObj, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	agg, err := newAggregator(fun, outFmt, interval, wait, out)
+	if err != nil {
+		return nil, err
+	}
+	agg.Glob = pattern
+	agg.glob = globObj
+	agg.prefix = globObj.Prefix()
+	agg.numHits = HitCounter(fmt.Sprintf("%s glob %s %s", fun, pattern, outFmt))
+	go agg.run()
+	return agg, nil
+}
+
+func newAggregator(fun, outFmt string, interval, wait uint, out chan []byte) (*Aggregator, error) {
 	fn, ok := Funcs[fun]
 	if !ok {
 		return nil, fmt.Errorf("no such aggregation function '%s'", fun)
 	}
-	agg := &Aggregator{
+	return &Aggregator{
 		fun,
 		fn,
 		make(chan [][]byte, 2000),
 		out,
-		regex,
-		regexObj,
-		regexToPrefix(regex),
+		"",
+		nil,
+		"",
+		nil,
+		nil,
 		outFmt,
 		[]byte(outFmt),
 		interval,
 		wait,
+		0,
+		0,
+		nil,
 		make([]aggregation, 0, 4),
 		make(chan bool),
 		make(chan *Aggregator),
 		make(chan bool),
-	}
-	go agg.run()
-	return agg, nil
+		make(chan bool),
+		make(chan bool),
+	}, nil
 }
 
 type aggregation struct {
@@ -140,39 +193,69 @@ func (a *Aggregator) Flush(ts uint) {
 	a.aggregations = aggregations2
 }
 
+// FlushAll finalizes all aggregations in process, including the ones that aren't due yet.
+// any input still queued up is processed first. (used at shutdown)
+// when this returns, all outputs have been sent.
+func (agg *Aggregator) FlushAll() {
+	agg.flushReq <- true
+	<-agg.flushResp
+}
+
 func (agg *Aggregator) Shutdown() {
 	agg.shutdown <- true
 	return
 }
 
-//PreMatch checks if the specified metric might match the regex
+//PreMatch checks if the specified metric might match the regex (or glob)
 //by comparing it to the prefix derived from the regex
 //if this returns false, the metric will definitely not match the regex and be ignored.
 func (agg *Aggregator) PreMatch(buf []byte) bool {
 	return bytes.HasPrefix(buf, agg.prefix)
 }
 
+func (agg *Aggregator) add(fields [][]byte) {
+	// note, we rely here on the fact that the packet has already been validated
+	key := fields[0]
+
+	var dst []byte
+	var outKey string
+	if agg.glob != nil {
+		captures, ok := agg.glob.Captures(key)
+		if !ok {
+			return
+		}
+		outKey = string(expandCaptures(dst, agg.outFmt, captures))
+	} else {
+		matches := agg.regex.FindSubmatchIndex(key)
+		if len(matches) == 0 {
+			return
+		}
+		outKey = string(agg.regex.Expand(dst, agg.outFmt, key, matches))
+	}
+	agg.Hits++
+	agg.LastHit = time.Now().Unix()
+	agg.numHits.Inc(1)
+	value, _ := strconv.ParseFloat(string(fields[1]), 64)
+	t, _ := strconv.ParseUint(string(fields[2]), 10, 0)
+	ts := uint(t)
+
+	quantized := ts - (ts % agg.Interval)
+	agg.AddOrCreate(outKey, quantized, value)
+}
+
 func (agg *Aggregator) run() {
 	interval := time.Duration(agg.Interval) * time.Second
 	ticker := getAlignedTicker(interval)
 	for {
 		select {
 		case fields := <-agg.In:
-			// note, we rely here on the fact that the packet has already been validated
-			key := fields[0]
-
-			matches := agg.regex.FindSubmatchIndex(key)
-			if len(matches) == 0 {
-				continue
+			agg.add(fields)
+		case <-agg.flushReq:
+			for len(agg.In) > 0 {
+				agg.add(<-agg.In)
 			}
-			value, _ := strconv.ParseFloat(string(fields[1]), 64)
-			t, _ := strconv.ParseUint(string(fields[2]), 10, 0)
-			ts := uint(t)
-
-			var dst []byte
-			outKey := string(agg.regex.Expand(dst, agg.outFmt, key, matches))
-			quantized := ts - (ts % agg.Interval)
-			agg.AddOrCreate(outKey, quantized, value)
+			agg.Flush(^uint(0))
+			agg.flushResp <- true
 		case now := <-ticker.C:
 			thresh := now.Add(-time.Duration(agg.Wait) * time.Second)
 			agg.Flush(uint(thresh.Unix()))
@@ -187,15 +270,22 @@ func (agg *Aggregator) run() {
 				nil,
 				agg.Regex,
 				nil,
+				agg.Glob,
+				nil,
 				agg.prefix,
 				agg.OutFmt,
 				nil,
 				agg.Interval,
 				agg.Wait,
+				agg.Hits,
+				agg.LastHit,
+				nil,
 				aggs,
 				nil,
 				nil,
 				nil,
+				nil,
+				nil,
 			}
 
 			agg.snapResp <- s
@@ -221,3 +311,46 @@ func getAlignedTicker(period time.Duration) *time.Ticker {
 	diff := time.Duration(period - (time.Duration(unix) % period))
 	return time.NewTicker(diff)
 }
+
+// expandCaptures appends the template to dst, with $1, $2, etc (or ${1}, ...) replaced by the corresponding capture.
+// $$ is a literal $. references to captures that don't exist are left empty, like regexp.Expand does.
+func expandCaptures(dst, template []byte, captures [][]byte) []byte {
+	for len(template) > 0 {
+		i := bytes.IndexByte(template, '$')
+		if i < 0 || i == len(template)-1 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i+1:]
+		if template[0] == '$' {
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+		braces := template[0] == '{'
+		if braces {
+			template = template[1:]
+		}
+		n := 0
+		for n < len(template) && template[n] >= '0' && template[n] <= '9' {
+			n++
+		}
+		if n == 0 || (braces && (n == len(template) || template[n] != '}')) {
+			// not a reference
+			dst = append(dst, '$')
+			if braces {
+				dst = append(dst, '{')
+			}
+			continue
+		}
+		num, _ := strconv.Atoi(string(template[:n]))
+		if num >= 1 && num <= len(captures) {
+			dst = append(dst, captures[num-1]...)
+		}
+		template = template[n:]
+		if braces {
+			template = template[1:]
+		}
+	}
+	return append(dst, template...)
+}
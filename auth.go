@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// roles for the admin interfaces. write includes read.
+const (
+	roleRead  = "read"
+	roleWrite = "write"
+)
+
+type adminUser struct {
+	Name  string
+	Token string // for http bearer tokens, as http basic auth password, and for the telnet login command
+	Role  string // read or write
+}
+
+type adminAuthConfig struct {
+	Users     []adminUser
+	Audit_log string // file to append all changes made via the admin interfaces to
+}
+
+// authenticator checks the credentials given to the admin interfaces.
+// without any users configured, everyone has full access, as "anonymous".
+type authenticator struct {
+	users []adminUser
+}
+
+var anonymous = adminUser{Name: "anonymous", Role: roleWrite}
+
+func newAuthenticator(conf adminAuthConfig) (*authenticator, error) {
+	names := make(map[string]bool)
+	for _, u := range conf.Users {
+		if u.Name == "" || u.Token == "" {
+			return nil, errors.New("admin users need a name and a token")
+		}
+		if u.Role != roleRead && u.Role != roleWrite {
+			return nil, fmt.Errorf("admin user %s: role must be %s or %s, not '%s'", u.Name, roleRead, roleWrite, u.Role)
+		}
+		if names[u.Name] {
+			return nil, fmt.Errorf("admin user %s is defined more than once", u.Name)
+		}
+		names[u.Name] = true
+	}
+	return &authenticator{conf.Users}, nil
+}
+
+func (a *authenticator) enabled() bool {
+	return len(a.users) > 0
+}
+
+// byToken returns the user with the given token. name is optional.
+func (a *authenticator) byToken(name, token string) (adminUser, bool) {
+	if !a.enabled() {
+		return anonymous, true
+	}
+	for _, u := range a.users {
+		if (name == "" || name == u.Name) && subtle.ConstantTimeCompare([]byte(token), []byte(u.Token)) == 1 {
+			return u, true
+		}
+	}
+	return adminUser{}, false
+}
+
+func (u adminUser) may(role string) bool {
+	return u.Role == roleWrite || u.Role == role
+}
+
+// auditLog records all changes made via the admin interfaces
+type auditLog struct {
+	sync.Mutex
+	f *os.File // nil if disabled
+}
+
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	IP        string    `json:"ip"`
+	Interface string    `json:"interface"` // telnet or http
+	Command   string    `json:"command"`
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	if path == "" {
+		return &auditLog{}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f}, nil
+}
+
+func (a *auditLog) record(user adminUser, addr, iface, cmd string) {
+	ip := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip = host
+	}
+	log.Notice("audit: %s from %s via %s: %s", user.Name, ip, iface, cmd)
+	if a.f == nil {
+		return
+	}
+	line, _ := json.Marshal(auditRecord{time.Now(), user.Name, ip, iface, cmd})
+	a.Lock()
+	defer a.Unlock()
+	_, err := a.f.Write(append(line, '\n'))
+	if err != nil {
+		log.Error("could not write to audit log: %s", err)
+	}
+}
+
+var adminAuth = &authenticator{}
+var audit = &auditLog{}
+
+// paths that are always open, so that probes and scrapers don't need credentials
+var authExempt = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// statusRecorder remembers the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+type ctxKey int
+
+const userKey ctxKey = 0
+
+// requestUser returns the user who made the request, as authenticated by authHandler
+func requestUser(r *http.Request) adminUser {
+	user, ok := r.Context().Value(userKey).(adminUser)
+	if !ok {
+		return anonymous
+	}
+	return user
+}
+
+// requestCommand describes the request as method, path and body, for the audit log and history.
+// the body remains readable.
+func requestCommand(r *http.Request) string {
+	cmd := r.Method + " " + r.URL.Path
+	if r.Body == nil {
+		return cmd
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if len(body) != 0 {
+		cmd += " " + string(bytes.TrimSpace(body))
+	}
+	return cmd
+}
+
+// authHandler requires read access for GET requests, and write access for everything else,
+// as well as for the debug handlers (pprof, expvar), which expose the internals of the process.
+// it records the successful changes in the audit log.
+// credentials are a bearer token, or http basic auth with the token as password.
+type authHandler struct {
+	next http.Handler
+}
+
+func (h authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if authExempt[r.URL.Path] {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	var user adminUser
+	var ok bool
+	if name, token, basic := r.BasicAuth(); basic {
+		user, ok = adminAuth.byToken(name, token)
+	} else {
+		user, ok = adminAuth.byToken("", strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}
+	if !ok {
+		log.Warning("http admin: authentication failed for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="carbon-relay-ng"`)
+		http.Error(w, `{"error":"authentication required"}`, http.StatusUnauthorized)
+		return
+	}
+	read := r.Method == "GET" || r.Method == "HEAD"
+	role := roleWrite
+	if read && !strings.HasPrefix(r.URL.Path, "/debug/") {
+		role = roleRead
+	}
+	if !user.may(role) {
+		log.Warning("http admin: %s from %s is not allowed to %s %s", user.Name, r.RemoteAddr, r.Method, r.URL.Path)
+		http.Error(w, `{"error":"permission denied"}`, http.StatusForbidden)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), userKey, user))
+	if read {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	cmd := requestCommand(r)
+	rec := &statusRecorder{w, http.StatusOK}
+	h.next.ServeHTTP(rec, r)
+	if rec.status < 400 {
+		audit.record(user, r.RemoteAddr, "http", cmd)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/telnet"
+)
+
+var testUsers = adminAuthConfig{
+	Users: []adminUser{
+		{Name: "ops", Token: "write-token", Role: roleWrite},
+		{Name: "dash", Token: "read-token", Role: roleRead},
+	},
+}
+
+func setupAuth(t *testing.T) (auditFile string) {
+	var err error
+	adminAuth, err = newAuthenticator(testUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "carbon-relay-ng-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	audit, err = openAuditLog(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func teardownAuth(auditFile string) {
+	audit.f.Close()
+	os.Remove(auditFile)
+	adminAuth = &authenticator{}
+	audit = &auditLog{}
+}
+
+func readAudit(t *testing.T, auditFile string) []auditRecord {
+	data, err := ioutil.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []auditRecord
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var r auditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	bad := []adminAuthConfig{
+		{Users: []adminUser{{Name: "a", Token: "t", Role: "admin"}}},
+		{Users: []adminUser{{Name: "a", Role: roleRead}}},
+		{Users: []adminUser{{Name: "a", Token: "t", Role: roleRead}, {Name: "a", Token: "u", Role: roleRead}}},
+	}
+	for i, conf := range bad {
+		if _, err := newAuthenticator(conf); err == nil {
+			t.Fatalf("case %d: expected an error", i)
+		}
+	}
+	a, err := newAuthenticator(adminAuthConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, ok := a.byToken("", "")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, anonymous, user)
+}
+
+func TestAuthHandler(t *testing.T) {
+	auditFile := setupAuth(t)
+	defer teardownAuth(auditFile)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+	h := authHandler{mux}
+
+	cases := []struct {
+		method string
+		path   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"GET", "/table", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"GET", "/health", func(r *http.Request) {}, http.StatusOK},
+		{"GET", "/table", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{"GET", "/table", func(r *http.Request) { r.Header.Set("Authorization", "Bearer read-token") }, http.StatusOK},
+		{"DELETE", "/routes/foo", func(r *http.Request) { r.Header.Set("Authorization", "Bearer read-token") }, http.StatusForbidden},
+		{"DELETE", "/routes/foo", func(r *http.Request) { r.SetBasicAuth("dash", "write-token") }, http.StatusUnauthorized},
+		{"DELETE", "/routes/foo", func(r *http.Request) { r.SetBasicAuth("ops", "write-token") }, http.StatusOK},
+		{"GET", "/debug/pprof/profile", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"GET", "/debug/vars", func(r *http.Request) { r.Header.Set("Authorization", "Bearer read-token") }, http.StatusForbidden},
+		{"GET", "/debug/vars", func(r *http.Request) { r.SetBasicAuth("ops", "write-token") }, http.StatusOK},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		c.auth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Fatalf("case %d: %s %s: expected status %d, got %d", i, c.method, c.path, c.status, w.Code)
+		}
+	}
+	records := readAudit(t, auditFile)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "ops", records[0].User)
+	assert.Equal(t, "http", records[0].Interface)
+	assert.Equal(t, "DELETE /routes/foo", records[0].Command)
+}
+
+// recordConn is a conn that keeps what's written to it
+type recordConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+func (c *recordConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+}
+
+func TestTelnetAuth(t *testing.T) {
+	auditFile := setupAuth(t)
+	defer teardownAuth(auditFile)
+	table := NewTableOrFatal(t, "", "")
+	var conn net.Conn = &recordConn{}
+	session := make(map[string]interface{})
+	req := func(cmd string) error {
+		return map[string]func(telnet.Req) error{
+			"login":    tcpLoginHandler,
+			"view":     tcpViewHandler,
+			"addBlack": tcpModHandler,
+		}[strings.Fields(cmd)[0]](telnet.Req{Command: strings.Split(cmd, " "), Conn: &conn, Session: session})
+	}
+
+	assert.Equal(t, "login required", req("view").Error())
+	assert.Equal(t, "login failed", req("login dash write-token").Error())
+	assert.Equal(t, nil, req("login dash read-token"))
+	assert.Equal(t, nil, req("view"))
+	assert.Equal(t, "permission denied", req("addBlack prefix foo").Error())
+	assert.Equal(t, nil, req("login write-token"))
+	assert.Equal(t, nil, req("addBlack prefix foo"))
+	assert.Equal(t, 1, len(table.Snapshot().Blacklist))
+
+	records := readAudit(t, auditFile)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "ops", records[0].User)
+	assert.Equal(t, "10.0.0.1", records[0].IP)
+	assert.Equal(t, "telnet", records[0].Interface)
+	assert.Equal(t, "addBlack prefix foo", records[0].Command)
+}
@@ -1,23 +1,61 @@
 package badmetrics
 
 import (
+	"container/list"
 	"sort"
+	"strings"
 	"time"
 )
 
+// classes of errors, see Record.ErrType
+const (
+	ErrNameChars  = "name_chars"  // illegal characters in the metric name
+	ErrNameFormat = "name_format" // other problems with the metric name, i.e. empty nodes or missing metrics2.0 tags
+	ErrFieldCount = "field_count" // the line doesn't have 3 fields
+	ErrValue      = "value"       // the value is not a number
+	ErrTimestamp  = "timestamp"   // the timestamp is not a unix timestamp
+	ErrTooLong    = "too_long"    // the line is too long
+	ErrTsFuture   = "ts_future"   // caught by the timestamp filter: too far in the future
+	ErrTsStale    = "ts_stale"    // caught by the timestamp filter: too old
+	ErrOther      = "other"
+	ErrRepaired   = "repaired" // not an error: the line was repaired and accepted. see the sanitize config
+)
+
 type BadMetrics struct {
-	maxAge  time.Duration
-	seen    map[string]Record
-	In      chan Record
-	getReq  chan time.Time
-	getResp chan []Record
+	maxAge     time.Duration
+	maxEntries int
+	seen       map[string]*list.Element // values are *Record
+	lru        *list.List               // most recently seen in front
+	In         chan Record
+	getReq     chan Filter
+	getResp    chan []Record
 }
 
+// Record tracks the bad lines of a metric, sent by one peer, with one class of error
 type Record struct {
-	Metric   string
-	LastMsg  string
-	LastErr  string
-	LastSeen time.Time
+	Metric    string
+	Peer      string // ip address of the sender
+	ErrType   string
+	Count     int64 // amount of bad lines seen
+	FirstSeen time.Time
+	LastMsg   string
+	LastErr   string
+	LastSeen  time.Time
+}
+
+// Filter selects records. empty fields match everything
+type Filter struct {
+	Oldest   time.Time // only records seen after this
+	ErrType  string
+	Prefix   string // of the metric name
+	MinCount int64
+}
+
+func (f Filter) match(r *Record) bool {
+	return r.LastSeen.After(f.Oldest) &&
+		(f.ErrType == "" || f.ErrType == r.ErrType) &&
+		strings.HasPrefix(r.Metric, f.Prefix) &&
+		r.Count >= f.MinCount
 }
 
 // ByMetric implements sort.Interface for []Record based on
@@ -29,54 +67,103 @@ func (a ByMetric) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByMetric) Less(i, j int) bool { return a[i].Metric < a[j].Metric }
 
 // maxAge is the age after which we expire old records (in practice a bit later)
-func New(maxAge time.Duration) *BadMetrics {
+// maxEntries is the max amount of records we track. when full, the least recently seen one is evicted.
+func New(maxAge time.Duration, maxEntries int) *BadMetrics {
 	b := &BadMetrics{
 		maxAge,
-		make(map[string]Record),
+		maxEntries,
+		make(map[string]*list.Element),
+		list.New(),
 		// needs to big enough so we don't start blocking when cleans or Get()'s happen
 		// if this fills up, Add() starts blocking, which blocks the table.
 		make(chan Record, 100000),
-		make(chan time.Time),
+		make(chan Filter),
 		make(chan []Record),
 	}
 	go b.manage()
 	return b
 }
 
+// Get returns the records seen within the last expiry duration
 func (b *BadMetrics) Get(expiry time.Duration) []Record {
-	b.getReq <- time.Now().Add(-expiry)
+	return b.GetFiltered(Filter{Oldest: time.Now().Add(-expiry)})
+}
+
+// GetFiltered returns the records that match the filter
+func (b *BadMetrics) GetFiltered(f Filter) []Record {
+	b.getReq <- f
 	filtered := <-b.getResp
 	sort.Sort(ByMetric(filtered))
 	return filtered
 }
 
-func (b *BadMetrics) Add(metric []byte, msg []byte, err error) {
+// Saturation returns how full (0-1) the input channel is. when it's full, Add() blocks.
+func (b *BadMetrics) Saturation() float64 {
+	return float64(len(b.In)) / float64(cap(b.In))
+}
+
+func (b *BadMetrics) Add(metric []byte, msg []byte, err error, errType, peer string) {
+	now := time.Now()
 	b.In <- Record{
-		string(metric),
-		string(msg),
-		err.Error(),
-		time.Now(),
+		Metric:    string(metric),
+		Peer:      peer,
+		ErrType:   errType,
+		Count:     1,
+		FirstSeen: now,
+		LastMsg:   string(msg),
+		LastErr:   err.Error(),
+		LastSeen:  now,
 	}
 }
 
+func (b *BadMetrics) add(in Record) {
+	key := in.Metric + "\x00" + in.Peer + "\x00" + in.ErrType
+	if e, ok := b.seen[key]; ok {
+		record := e.Value.(*Record)
+		record.Count++
+		record.LastMsg = in.LastMsg
+		record.LastErr = in.LastErr
+		record.LastSeen = in.LastSeen
+		b.lru.MoveToFront(e)
+		return
+	}
+	b.seen[key] = b.lru.PushFront(&in)
+	if b.maxEntries > 0 && b.lru.Len() > b.maxEntries {
+		b.remove(b.lru.Back())
+	}
+}
+
+func (b *BadMetrics) remove(e *list.Element) {
+	record := b.lru.Remove(e).(*Record)
+	delete(b.seen, record.Metric+"\x00"+record.Peer+"\x00"+record.ErrType)
+}
+
 func (b *BadMetrics) manage() {
 	clean := time.NewTicker(b.maxAge / 10)
 	for {
 		select {
 		case in := <-b.In:
-			b.seen[in.Metric] = in
+			b.add(in)
 		case <-clean.C:
-			now := time.Now()
-			for metric, record := range b.seen {
-				if record.LastSeen.Before(now) {
-					delete(b.seen, metric)
-				}
+			// the least recently seen records are in the back
+			oldest := time.Now().Add(-b.maxAge)
+			for e := b.lru.Back(); e != nil && e.Value.(*Record).LastSeen.Before(oldest); e = b.lru.Back() {
+				b.remove(e)
 			}
-		case oldest := <-b.getReq:
-			filtered := make([]Record, 0, len(b.seen))
-			for _, record := range b.seen {
-				if record.LastSeen.After(oldest) {
-					filtered = append(filtered, record)
+		case f := <-b.getReq:
+			// so that we include everything that was added before the request
+			for pending := len(b.In); pending > 0; pending-- {
+				b.add(<-b.In)
+			}
+			filtered := make([]Record, 0)
+			for e := b.lru.Front(); e != nil; e = e.Next() {
+				record := e.Value.(*Record)
+				if record.LastSeen.Before(f.Oldest) {
+					// all the others were seen even earlier
+					break
+				}
+				if f.match(record) {
+					filtered = append(filtered, *record)
 				}
 			}
 			b.getResp <- filtered
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
+)
+
+func TestBadMetricType(t *testing.T) {
+	config := initIngest()
+	cases := map[string]string{
+		"foo$bar 1 1":    badmetrics.ErrNameChars,
+		"foo..bar 1 1":   badmetrics.ErrNameFormat,
+		"foo.bar 1":      badmetrics.ErrFieldCount,
+		"foo.bar abc 1":  badmetrics.ErrValue,
+		"foo.bar 1 abc":  badmetrics.ErrTimestamp,
+		"foo.bar 1 1 1 ": badmetrics.ErrFieldCount,
+	}
+	for line, exp := range cases {
+		handleLine([]byte(line), "10.0.0.1", config)
+		time.Sleep(time.Millisecond)
+		records := badMetrics.Get(time.Minute)
+		found := false
+		for _, r := range records {
+			if r.LastMsg == line {
+				found = true
+				assert.Equal(t, exp, r.ErrType, line)
+			}
+		}
+		if !found {
+			t.Fatalf("no record for '%s'", line)
+		}
+	}
+	assert.Equal(t, badmetrics.ErrTooLong, badMetricType(errLineTooLong))
+	assert.Equal(t, badmetrics.ErrOther, badMetricType(errors.New("something else")))
+}
+
+func TestBadMetricsCounts(t *testing.T) {
+	config := initIngest()
+	badMetrics = badmetrics.New(time.Minute, 3)
+	for i := 0; i < 3; i++ {
+		handleLine([]byte("a.b 1 x"), "10.0.0.1", config)
+	}
+	handleLine([]byte("a.b 1 x"), "10.0.0.2", config)
+	handleLine([]byte("a.b x 1"), "10.0.0.1", config)
+	handleLine([]byte("c.d x 1"), "10.0.0.1", config)
+	time.Sleep(10 * time.Millisecond)
+
+	// the first record was evicted, as it was seen least recently
+	records := badMetrics.Get(time.Minute)
+	assert.Equal(t, 3, len(records))
+
+	handleLine([]byte("a.b 1 x"), "10.0.0.2", config)
+	handleLine([]byte("a.b 1 x"), "10.0.0.2", config)
+	time.Sleep(10 * time.Millisecond)
+	records = badMetrics.GetFiltered(badmetrics.Filter{ErrType: badmetrics.ErrTimestamp})
+	assert.Equal(t, 1, len(records))
+	r := records[0]
+	assert.Equal(t, "a.b", r.Metric)
+	assert.Equal(t, "10.0.0.2", r.Peer)
+	assert.Equal(t, int64(3), r.Count)
+	assert.Equal(t, true, r.FirstSeen.Before(r.LastSeen))
+
+	records = badMetrics.GetFiltered(badmetrics.Filter{Prefix: "c."})
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "c.d", records[0].Metric)
+	records = badMetrics.GetFiltered(badmetrics.Filter{MinCount: 2})
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, int64(3), records[0].Count)
+	records = badMetrics.GetFiltered(badmetrics.Filter{Oldest: time.Now()})
+	assert.Equal(t, 0, len(records))
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/graphite-ng/carbon-relay-ng/aggregator"
+	"github.com/graphite-ng/carbon-relay-ng/glob"
+)
+
+var errTableChanged = errors.New("the routing table was changed by someone else since the batch began. nothing applied")
+
+// TableBatch stages changes to a table, to apply them all at once when it's committed.
+// additions are made to a copy of the table config, which replaces the config of the table on commit.
+// changes to routes and destinations are validated when staged, and applied on commit.
+// if any change fails to stage, or doesn't apply anymore on commit, the batch fails as a whole: nothing gets applied.
+type TableBatch struct {
+	table     *Table
+	version   uint64 // of the table config we copied
+	conf      TableConfig
+	changes   []*routeChange // in the order the routes were first changed
+	routes    []Route        // routes we created, they only run once committed
+	aggs      []*aggregator.Aggregator
+	delRoutes []Route                  // routes we removed, they're shut down once committed
+	delAggs   []*aggregator.Aggregator // aggregators we removed, likewise
+	commands  []string
+	err       error // first error we ran into
+	done      bool
+}
+
+// routeChange holds the options staged for a route and its destinations.
+// options staged later override the ones staged earlier.
+type routeChange struct {
+	route    Route
+	opts     map[string]string         // for Route.Update
+	destOpts map[int]map[string]string // for Destination.Update, by index of the dest
+}
+
+// Begin starts a batch of changes to the table
+func (table *Table) Begin() *TableBatch {
+	table.Lock()
+	defer table.Unlock()
+	conf := table.config.Load().(TableConfig)
+	return &TableBatch{
+		table:   table,
+		version: table.version,
+		conf: TableConfig{
+			append([]*aggregator.Aggregator{}, conf.aggregators...),
+			append([]*Matcher{}, conf.blacklist...),
+			append([]*Matcher{}, conf.whitelist...),
+			append([]Route{}, conf.routes...),
+			conf.defaultRoute,
+			nil,
+		},
+	}
+}
+
+// Apply stages the command. see applyCommand
+func (b *TableBatch) Apply(cmd string) error {
+	if b.done {
+		return errors.New("batch is already finished")
+	}
+	if b.err != nil {
+		return fmt.Errorf("batch already failed: %s", b.err)
+	}
+	err := stageCommand(b, cmd)
+	if err != nil {
+		b.err = fmt.Errorf("command #%d '%s': %s", len(b.commands)+1, cmd, err)
+		return err
+	}
+	b.commands = append(b.commands, cmd)
+	return nil
+}
+
+// Commands returns the commands staged so far
+func (b *TableBatch) Commands() []string {
+	return b.commands
+}
+
+func (b *TableBatch) GetRoute(key string) Route {
+	for _, r := range b.conf.routes {
+		if r.Key() == key {
+			return r
+		}
+	}
+	return nil
+}
+
+// AddRoute stages the addition of a route. The Route must not be running yet, it's run on commit
+func (b *TableBatch) AddRoute(route Route) {
+	b.conf.routes = append(b.conf.routes, route)
+	b.routes = append(b.routes, route)
+}
+
+// SetDefaultRoute stages making the route with the given key the default route. an empty key unsets it
+func (b *TableBatch) SetDefaultRoute(key string) error {
+	if key != "" && b.GetRoute(key) == nil {
+		return fmt.Errorf("no route '%s'", key)
+	}
+	b.conf.defaultRoute = key
+	return nil
+}
+
+func (b *TableBatch) AddBlacklist(matcher *Matcher) {
+	matcher.hits = newRuleHits("blacklist", matcher.String())
+	b.conf.blacklist = append(b.conf.blacklist, matcher)
+}
+
+func (b *TableBatch) AddWhitelist(matcher *Matcher) {
+	b.conf.whitelist = append(b.conf.whitelist, matcher)
+}
+
+func (b *TableBatch) DelWhitelist(index int) error {
+	if index < 0 || index >= len(b.conf.whitelist) {
+		return fmt.Errorf("Invalid index %d", index)
+	}
+	b.conf.whitelist = append(b.conf.whitelist[:index:index], b.conf.whitelist[index+1:]...)
+	return nil
+}
+
+func (b *TableBatch) DelBlacklist(index int) error {
+	if index < 0 || index >= len(b.conf.blacklist) {
+		return fmt.Errorf("Invalid index %d", index)
+	}
+	b.conf.blacklist = append(b.conf.blacklist[:index:index], b.conf.blacklist[index+1:]...)
+	return nil
+}
+
+func (b *TableBatch) AddAggregator(agg *aggregator.Aggregator) {
+	b.conf.aggregators = append(b.conf.aggregators, agg)
+	b.aggs = append(b.aggs, agg)
+}
+
+// DelAggregator stages the removal of an aggregator. it's shut down on commit
+func (b *TableBatch) DelAggregator(index int) error {
+	if index < 0 || index >= len(b.conf.aggregators) {
+		return fmt.Errorf("Invalid index %d", index)
+	}
+	b.delAggs = append(b.delAggs, b.conf.aggregators[index])
+	b.conf.aggregators = append(b.conf.aggregators[:index:index], b.conf.aggregators[index+1:]...)
+	return nil
+}
+
+// DelRoute stages the removal of a route, along with the changes staged for it. it's shut down on commit
+func (b *TableBatch) DelRoute(key string) error {
+	route := b.GetRoute(key)
+	if route == nil {
+		return fmt.Errorf("no route '%s'", key)
+	}
+	b.conf.routes = withoutRoute(b.conf.routes, route)
+	if b.conf.defaultRoute == key {
+		b.conf.defaultRoute = ""
+	}
+	for i, c := range b.changes {
+		if c.route == route {
+			b.changes = append(b.changes[:i:i], b.changes[i+1:]...)
+			break
+		}
+	}
+	// a route we created never ran, so there's nothing to shut down
+	for _, r := range b.routes {
+		if r == route {
+			b.routes = withoutRoute(b.routes, route)
+			return nil
+		}
+	}
+	b.delRoutes = append(b.delRoutes, route)
+	return nil
+}
+
+func withoutRoute(routes []Route, route Route) []Route {
+	out := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		if r != route {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// change returns the staged changes of the route
+func (b *TableBatch) change(route Route) *routeChange {
+	for _, c := range b.changes {
+		if c.route == route {
+			return c
+		}
+	}
+	c := &routeChange{route, nil, make(map[int]map[string]string)}
+	b.changes = append(b.changes, c)
+	return c
+}
+
+// mergeOpts returns the options of a, overridden by those of b
+func mergeOpts(a, b map[string]string) map[string]string {
+	merged := make(map[string]string, len(a)+len(b))
+	for name, val := range a {
+		merged[name] = val
+	}
+	for name, val := range b {
+		merged[name] = val
+	}
+	return merged
+}
+
+func (b *TableBatch) UpdateDestination(key string, index int, opts map[string]string) error {
+	route := b.GetRoute(key)
+	if route == nil {
+		return fmt.Errorf("Invalid route for %v", key)
+	}
+	dests := route.dests()
+	if index < 0 || index >= len(dests) {
+		return fmt.Errorf("Invalid index %d", index)
+	}
+	c := b.change(route)
+	opts = mergeOpts(c.destOpts[index], opts)
+	if _, err := dests[index].prepareUpdate(opts); err != nil {
+		return err
+	}
+	c.destOpts[index] = opts
+	return nil
+}
+
+func (b *TableBatch) UpdateRoute(key string, opts map[string]string) error {
+	route := b.GetRoute(key)
+	if route == nil {
+		return fmt.Errorf("Invalid route for %v", key)
+	}
+	c := b.change(route)
+	opts = mergeOpts(c.opts, opts)
+	if _, err := updatedMatcher(route.matcher(), opts); err != nil {
+		return err
+	}
+	c.opts = opts
+	return nil
+}
+
+// Commit applies all staged changes to the table, unless any of them failed to stage,
+// or the table was changed since the batch began. in those cases, nothing is applied.
+// destinations that need to reconnect for the changes do so after the table is unlocked again,
+// and removed routes and aggregators are shut down then, too.
+func (b *TableBatch) Commit() error {
+	if b.done {
+		return errors.New("batch is already finished")
+	}
+	if b.err != nil {
+		b.Abort()
+		return fmt.Errorf("%s. nothing applied", b.err)
+	}
+	b.table.Lock()
+	reconnects, err := b.commit()
+	b.table.Unlock()
+	if err != nil {
+		b.Abort()
+		return err
+	}
+	for _, reconnect := range reconnects {
+		reconnect()
+	}
+	for _, agg := range b.delAggs {
+		agg.Shutdown()
+	}
+	// like for Table.DelRoute, the routes are out of the table regardless
+	err = nil
+	for _, route := range b.delRoutes {
+		if e := route.Shutdown(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// commit applies the changes, and returns what needs to be done to reconnect. the caller must hold the table lock.
+// note that this is not a single swap of the table config: the live destinations and route matchers are changed
+// right before the new config is stored. but it all happens under the table lock, after everything that can fail,
+// so other changes and batches never see a half applied batch. the routing itself may, for a few metrics.
+func (b *TableBatch) commit() (reconnects []func(), err error) {
+	table := b.table
+	if table.version != b.version {
+		return nil, errTableChanged
+	}
+	// the changes were validated when staged, but routes and dests can be changed directly since,
+	// so we first work them all out again, before applying any of them.
+	matchers := make([]Matcher, len(b.changes))
+	var updates []*destUpdate
+	for i, c := range b.changes {
+		matchers[i], err = updatedMatcher(c.route.matcher(), c.opts)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s. nothing applied", c.route.Key(), err)
+		}
+		dests := c.route.dests()
+		for index, opts := range c.destOpts {
+			if index >= len(dests) {
+				return nil, fmt.Errorf("route %s: invalid index %d. nothing applied", c.route.Key(), index)
+			}
+			update, err := dests[index].prepareUpdate(opts)
+			if err != nil {
+				return nil, fmt.Errorf("route %s dest %d: %s. nothing applied", c.route.Key(), index, err)
+			}
+			updates = append(updates, update)
+		}
+	}
+	b.done = true
+	for _, update := range updates {
+		if reconnect := update.apply(); reconnect != nil {
+			reconnects = append(reconnects, reconnect)
+		}
+	}
+	// this also updates what routes derive from their dests, like the weights
+	for i, c := range b.changes {
+		c.route.UpdateMatcher(matchers[i])
+	}
+	for _, route := range b.routes {
+		route.Run()
+	}
+	table.store(b.conf)
+	return reconnects, nil
+}
+
+// Abort discards the staged changes, and shuts down the aggregators the batch created
+func (b *TableBatch) Abort() {
+	if b.done {
+		return
+	}
+	b.done = true
+	for _, agg := range b.aggs {
+		agg.Shutdown()
+	}
+}
+
+func validateMatcherOpt(name, val string) error {
+	switch name {
+	case "regex", "notregex":
+		_, err := regexp.Compile(val)
+		return err
+	case "glob":
+		if val == "" {
+			return nil
+		}
+		_, err := glob.Compile(val)
+		return err
+	}
+	return nil
+}
+
+// validateRouteOpts checks the options for Route.Update without applying them
+func validateRouteOpts(opts map[string]string) error {
+	for name, val := range opts {
+		switch name {
+		case "prefix", "sub", "regex", "glob", "notprefix", "notsub", "notregex":
+			if err := validateMatcherOpt(name, val); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("no such option '%s'", name)
+		}
+	}
+	return nil
+}
+
+// validateDestOpts checks the options for Destination.Update without applying them
+func validateDestOpts(opts map[string]string) error {
+	for name, val := range opts {
+		switch name {
+		case "addr":
+		case "prefix", "sub", "regex", "glob", "notprefix", "notsub", "notregex":
+			if err := validateMatcherOpt(name, val); err != nil {
+				return err
+			}
+		case "bufsize", "writebuf":
+			i, err := strconv.Atoi(val)
+			if err != nil {
+				return err
+			}
+			if i < 1 {
+				return fmt.Errorf("%s must be at least 1, not %d", name, i)
+			}
+		case "maxrate":
+			i, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return err
+			}
+			if i < 0 {
+				return fmt.Errorf("maxrate can't be negative")
+			}
+		case "weight":
+			i, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return err
+			}
+			if err := checkWeight(i); err != nil {
+				return err
+			}
+		default:
+			return errors.New("no such option: " + name)
+		}
+	}
+	return nil
+}
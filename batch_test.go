@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/telnet"
+)
+
+func TestBatchCommit(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch batch  127.0.0.1:2099 flush=10")
+	defer table.ShutdownOrFatal(t)
+	batch := table.Begin()
+	for _, cmd := range []string{
+		"addBlack prefix foo",
+		"addRoute sendAllMatch other  127.0.0.1:2099 flush=10",
+		"modRoute other prefix=bar",
+		"modDest batch 0 maxrate=10",
+	} {
+		if err := batch.Apply(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// nothing is applied yet
+	snap := table.Snapshot()
+	assert.Equal(t, 0, len(snap.Blacklist))
+	assert.Equal(t, 1, len(snap.Routes))
+	assert.Equal(t, int64(0), snap.Routes[0].Dests[0].MaxRate)
+
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	snap = table.Snapshot()
+	assert.Equal(t, 1, len(snap.Blacklist))
+	assert.Equal(t, 2, len(snap.Routes))
+	assert.Equal(t, int64(10), snap.Routes[0].Dests[0].MaxRate)
+	assert.Equal(t, "bar", snap.Routes[1].Matcher.Prefix)
+	if batch.Commit() == nil {
+		t.Fatal("expected an error committing a batch twice")
+	}
+}
+
+func TestBatchFailure(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch batch  127.0.0.1:2099 flush=10")
+	defer table.ShutdownOrFatal(t)
+	batch := table.Begin()
+	assert.Equal(t, nil, batch.Apply("addBlack prefix foo"))
+	assert.Equal(t, nil, batch.Apply("modDest batch 0 maxrate=10"))
+	if batch.Apply("modDest batch 0 bufsize=0") == nil {
+		t.Fatal("expected an error for bufsize=0")
+	}
+	if batch.Apply("addBlack prefix bar") == nil {
+		t.Fatal("expected a failed batch to refuse more commands")
+	}
+	if batch.Commit() == nil {
+		t.Fatal("expected an error committing a failed batch")
+	}
+	snap := table.Snapshot()
+	assert.Equal(t, 0, len(snap.Blacklist))
+	assert.Equal(t, int64(0), snap.Routes[0].Dests[0].MaxRate)
+
+	for _, cmd := range []string{
+		"modDest nope 0 maxrate=10",
+		"modDest batch 1 maxrate=10",
+		"modDest batch 0 maxrate=-1",
+		"modRoute batch regex=(",
+		"modRoute batch bogus=1",
+	} {
+		if table.Begin().Apply(cmd) == nil {
+			t.Fatalf("expected an error for '%s'", cmd)
+		}
+	}
+}
+
+func TestBatchAtomic(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch batch  127.0.0.1:2099 flush=10  127.0.0.1:2099 flush=10")
+	defer table.ShutdownOrFatal(t)
+	batch := table.Begin()
+	assert.Equal(t, nil, batch.Apply("modRoute batch prefix=foo"))
+	assert.Equal(t, nil, batch.Apply("modDest batch 0 maxrate=10"))
+	assert.Equal(t, nil, batch.Apply("modDest batch 1 maxrate=20"))
+	// a direct change to the route, which doesn't change the table version
+	assert.Equal(t, nil, table.GetRoute("batch").DelDestination(1))
+	if batch.Commit() == nil {
+		t.Fatal("expected an error for the removed destination")
+	}
+	// none of the other changes were applied either
+	snap := table.Snapshot()
+	assert.Equal(t, "", snap.Routes[0].Matcher.Prefix)
+	assert.Equal(t, int64(0), snap.Routes[0].Dests[0].MaxRate)
+}
+
+func TestBatchRunsRoutesOnCommit(t *testing.T) {
+	table := NewTableOrFatal(t, "", "")
+	defer table.ShutdownOrFatal(t)
+	batch := table.Begin()
+	assert.Equal(t, nil, batch.Apply("addRoute sendAllMatch new  127.0.0.1:2099 flush=10"))
+	assert.Equal(t, nil, batch.Apply("modDest new 0 addr=127.0.0.1:2098 maxrate=10"))
+	dest := batch.GetRoute("new").dests()[0]
+	if dest.in != nil {
+		t.Fatal("expected the destination of a staged route not to run yet")
+	}
+	assert.Equal(t, nil, batch.Commit())
+	if dest.in == nil {
+		t.Fatal("expected the destination to run once committed")
+	}
+	snap := table.Snapshot().Routes[0].Dests[0]
+	assert.Equal(t, "127.0.0.1:2098", snap.Addr)
+	assert.Equal(t, int64(10), snap.MaxRate)
+
+	// an aborted batch never runs its routes
+	batch = table.Begin()
+	assert.Equal(t, nil, batch.Apply("addRoute sendAllMatch other  127.0.0.1:2099 flush=10"))
+	dest = batch.GetRoute("other").dests()[0]
+	batch.Abort()
+	if dest.in != nil {
+		t.Fatal("expected the destination of an aborted route not to run")
+	}
+}
+
+func TestBatchConflict(t *testing.T) {
+	table := NewTableOrFatal(t, "", "")
+	batch := table.Begin()
+	assert.Equal(t, nil, batch.Apply("addBlack prefix foo"))
+	if err := applyCommand(table, "addBlack prefix bar"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, errTableChanged, batch.Commit())
+	snap := table.Snapshot()
+	assert.Equal(t, 1, len(snap.Blacklist))
+	assert.Equal(t, "bar", snap.Blacklist[0].Prefix)
+}
+
+// changes made directly, not through a batch, must also make older batches fail, rather than be overwritten
+func TestBatchConflictDirectUpdate(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch batch  127.0.0.1:2099 flush=10")
+	defer table.ShutdownOrFatal(t)
+	batch := table.Begin()
+	assert.Equal(t, nil, batch.Apply("modDest batch 0 maxrate=10"))
+	assert.Equal(t, nil, table.UpdateDestination("batch", 0, map[string]string{"maxrate": "20"}))
+	assert.Equal(t, errTableChanged, batch.Commit())
+	assert.Equal(t, int64(20), table.Snapshot().Routes[0].Dests[0].MaxRate)
+
+	batch = table.Begin()
+	assert.Equal(t, nil, batch.Apply("modRoute batch prefix=foo"))
+	assert.Equal(t, nil, table.UpdateRoute("batch", map[string]string{"prefix": "bar"}))
+	assert.Equal(t, errTableChanged, batch.Commit())
+	assert.Equal(t, "bar", table.Snapshot().Routes[0].Matcher.Prefix)
+}
+
+func TestApplyCommandAtomic(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch batch  127.0.0.1:2099 flush=10")
+	defer table.ShutdownOrFatal(t)
+	if applyCommand(table, "modDest batch 0 maxrate=10 bufsize=x") == nil {
+		t.Fatal("expected an error for bufsize=x")
+	}
+	assert.Equal(t, int64(0), table.Snapshot().Routes[0].Dests[0].MaxRate)
+	if applyCommand(table, "addRoute sendAllMatch other  127.0.0.1:2099 bogus=1") == nil {
+		t.Fatal("expected an error for an unknown option")
+	}
+	assert.Equal(t, 1, len(table.Snapshot().Routes))
+}
+
+func TestTelnetBatch(t *testing.T) {
+	table := NewTableOrFatal(t, "", "")
+	rc := &recordConn{}
+	var conn net.Conn = rc
+	session := make(map[string]interface{})
+	req := func(cmd string) error {
+		return map[string]func(telnet.Req) error{
+			"begin":    tcpBatchHandler,
+			"commit":   tcpBatchHandler,
+			"abort":    tcpBatchHandler,
+			"addBlack": tcpModHandler,
+		}[strings.Fields(cmd)[0]](telnet.Req{Command: strings.Split(cmd, " "), Conn: &conn, Session: session})
+	}
+
+	assert.Equal(t, "not in a batch", req("commit").Error())
+	assert.Equal(t, nil, req("begin"))
+	assert.Equal(t, nil, req("addBlack prefix foo"))
+	assert.Equal(t, nil, req("addBlack prefix bar"))
+	assert.Equal(t, 0, len(table.Snapshot().Blacklist))
+	assert.Equal(t, nil, req("commit"))
+	assert.Equal(t, 2, len(table.Snapshot().Blacklist))
+	assert.Equal(t, "ok\nstaged\nstaged\nok\n", rc.out.String())
+
+	assert.Equal(t, nil, req("begin"))
+	assert.Equal(t, nil, req("addBlack prefix baz"))
+	assert.Equal(t, nil, req("abort"))
+	assert.Equal(t, 2, len(table.Snapshot().Blacklist))
+
+	// a batch that's left open is aborted when the conn closes
+	assert.Equal(t, nil, req("begin"))
+	batch := session["batch"].(*TableBatch)
+	tcpCloseHandler(session)
+	if batch.Commit() == nil {
+		t.Fatal("expected the batch to be aborted")
+	}
+}
+
+func TestHTTPBatch(t *testing.T) {
+	table = NewTableOrFatal(t, "", "")
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(applyBatch).ServeHTTP(w, httptest.NewRequest("POST", "/table/batch", bytes.NewBufferString(body)))
+		return w
+	}
+	assert.Equal(t, http.StatusBadRequest, post(`{"Commands": ["addBlack prefix foo", "addBlack bogus foo"]}`).Code)
+	assert.Equal(t, 0, len(table.Snapshot().Blacklist))
+	assert.Equal(t, http.StatusBadRequest, post(`{"Commands": []}`).Code)
+	assert.Equal(t, http.StatusOK, post(`{"Commands": ["addBlack prefix foo", "addBlack sub bar"]}`).Code)
+	assert.Equal(t, 2, len(table.Snapshot().Blacklist))
+}
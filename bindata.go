@@ -90,7 +90,7 @@ func admin_http_assetsAppCss() (*asset, error) {
 	return a, nil
 }
 
-var _admin_http_assetsAppJs = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xec\x57\x4d\x6f\xe3\x36\x13\xbe\xfb\x57\x10\x82\x01\x51\x88\x22\xe7\x7d\x0f\x05\x2a\x23\xd8\xa6\x1f\x8b\xee\x21\x40\xb1\xdd\xb6\x07\xd7\x0b\xd0\x12\xa3\x08\xa1\x49\x81\xa4\xbc\x31\xbc\xfa\xef\x1d\x52\x5f\x14\xe5\xcd\xa6\x49\x7b\xab\x01\x43\x12\x39\x1f\xcf\x3c\x33\x1c\x8d\x0e\x44\x22\x52\x55\xe8\x1a\x71\xfa\x09\x11\x5e\xd4\x8c\xc8\x64\x2f\xf2\x9a\x51\x1c\x64\x44\xee\x04\xbf\x94\x94\x91\xe3\x25\x2f\x82\x18\x6d\x02\x5e\xbc\xa7\x4a\xd4\x32\xa3\xf0\x18\xd4\x65\xb2\x13\x42\x2b\x2d\x49\x15\x6c\xa3\xf5\x62\x01\xd6\x92\x4c\x70\x2d\x05\x63\x54\xe2\xe0\x96\x94\xfc\x07\xcd\xac\xee\x52\x65\xa2\xb2\x7a\x4b\xe9\x18\x59\x82\x3f\x62\x24\xee\x6a\x9e\xe9\x52\x70\xdc\x0a\xc6\x68\x10\x83\x5b\x2b\x14\x9d\x16\x08\xb5\xbb\x09\x01\xfb\x5a\x01\xf4\xcd\x76\x0d\xab\x26\x94\x0f\x64\xc7\x28\xac\x0c\x7a\x38\x58\x69\xb3\xb6\x0a\xa2\x5e\xe6\x7b\x46\xb2\x07\x56\x2a\xed\xc9\xed\xfa\x75\xb5\x4a\x4b\x9e\xd3\xc7\x51\xe5\xa6\x28\x24\x2d\x88\x16\xd2\xd3\x21\xc3\xc6\x5c\xe9\xbd\xa8\xb5\x8f\x45\x9a\x35\x10\x7d\xa0\x47\x08\xf7\x04\x97\x14\x85\xdf\xc1\x25\x6c\xe0\xb1\x19\x74\x7f\xa4\x4a\x97\x9c\x18\x2e\xbe\x6c\x61\x95\x8f\x52\xae\xfb\xc5\x48\xd1\x81\xb0\x32\xbf\xc9\x73\x30\x60\x88\x5a\x7d\xdc\x7c\x4c\xb7\x17\x7f\xa6\x9b\xab\xcb\x6f\xb7\x17\x38\xb5\x8f\xd1\x9b\xe5\x6a\xed\xe9\x58\xf0\x1f\x8e\x15\xb5\x5a\x58\x51\x9e\xe3\x1b\xc6\x3e\xbf\x2d\xa5\xd2\xd1\x2d\xd1\xd9\x7d\xf4\x19\x43\x9e\x15\x10\x46\xb9\xfe\x99\xa8\xfb\x92\x17\xd1\xca\xb7\x43\x0b\xfa\x08\x36\xf0\x90\xda\x08\x99\x14\x9a\x9f\xa4\xba\x96\x7c\x78\x34\x3f\x08\x4d\xa7\x63\x19\x80\x89\x9a\x46\x13\x09\xf3\x33\x0c\x95\xea\x77\x63\x1f\x4c\x6b\x59\xd3\xb5\x27\xa1\xe5\x71\xa6\x85\x6c\x89\x03\xa0\x9f\x1e\xab\xce\xb2\xaf\xd6\xa0\xcc\x44\x86\xcf\xf8\x44\x8e\xc7\x3b\xc2\xd4\xcc\x65\xe3\x3d\x77\xd1\x75\x5a\xae\x74\x2f\xd9\x98\xc5\x26\xc2\x26\x65\x5e\xc6\x8a\xe2\x2d\x70\xf0\x4f\xf3\x56\xde\xa1\x76\x0b\x5d\x5f\xa3\x40\xd5\xfb\xe0\x5c\x9c\x83\x83\x73\xc4\xfa\x51\x4e\x4d\x92\x43\xf1\x7a\x93\x9d\xe8\x8c\xe4\x27\x69\xeb\xce\xf3\xc0\x40\x99\x3f\x46\x2d\x0e\xdb\x12\x92\x82\xea\x91\xca\x9c\x68\x12\xf5\x28\x3b\x03\xba\xeb\x1c\x66\xaf\xf5\xda\x9e\xc6\xc6\xf7\x32\xf5\x0b\x25\x05\xc9\xea\xda\xe7\xd8\x26\xf0\xc9\x9c\x9d\x14\x18\x29\x4c\xd7\x7c\xc7\x35\x95\x40\x53\xfa\xcd\x55\x8c\xfe\x20\x25\xe4\xea\x7f\xff\xbf\x6a\x1d\xf4\xcd\x2c\xcf\x27\x5d\x66\x96\xf7\xf3\x4d\xcf\x03\x92\x2c\x15\x39\x50\x1c\x25\xfa\x9e\xf2\x31\x60\x38\xfe\xd5\x98\x17\x47\x23\xcf\x5f\x03\xdd\x31\xd6\x33\x63\x89\x8b\x07\xc7\x54\xca\x99\xdf\x31\x82\xd3\x5e\x15\x29\x02\x99\xc4\xb0\x9e\xc0\x8d\x90\xcd\xd6\x63\x7f\xd0\x93\x74\x2f\x0e\xf4\x3c\x49\x63\xba\x4d\x41\x42\x5b\xba\x2b\xe5\x1e\x87\x37\x92\xa2\xa3\xa8\x91\xaa\xbb\x9b\x4f\x84\x6b\xa4\x05\xca\x29\xa3\xd0\x9c\xc7\xf6\x8d\xa0\x87\x41\xcf\xe0\x22\x41\x21\xba\x40\xc6\xde\x08\x7c\xf4\x99\xb4\x8a\xf8\x14\xda\x6e\x1b\xa6\x20\xf8\x34\x17\x7e\x09\x01\xd9\xfd\x8b\xc1\xb6\x23\x73\x8f\x4f\xbf\x94\xf0\xea\x01\xd2\x6d\xd1\xc7\xe8\xd7\x4a\x08\x96\xda\xd3\x12\x23\x6f\xaf\xcb\x8e\xe9\xc8\xd0\x90\x6d\x27\x0e\xfc\x4a\xea\x3d\xbc\xa0\x88\xac\xea\xdf\x2c\xa3\x7f\x2b\xa0\xff\xea\xeb\xb9\xf5\x35\xec\x99\xbc\xb9\xc0\xed\xb8\xf0\xb5\xec\xb7\x39\xb7\x29\x3f\xc1\x1c\x62\x75\xe2\x49\xf1\x4c\x3d\x43\x06\x5a\x44\x5e\x1e\x9e\x9d\x81\x73\xbd\xb5\xa5\xdf\x1d\xce\x5e\xcb\xfe\x30\xd0\x3d\x49\xfe\xe0\xf1\xf5\x67\xbb\x0d\x61\x76\xf8\x60\x54\xfb\x1a\xfc\x37\xa1\x83\xa8\x4d\xc7\x80\xc6\x4c\x87\x66\xdc\x7b\x09\x96\xe9\x1c\xe9\x22\x8a\xd1\x33\x58\x9d\xc0\x72\x87\xd2\x39\xba\x18\xbd\x98\x36\xf8\x73\x9f\x34\x64\x93\xde\xf9\x36\xf3\x9e\x9d\xfe\xdf\x71\xa5\x09\xcf\xec\x54\x6d\x17\xac\x2e\xee\x11\x6a\xba\xaf\x18\xd1\xf4\x37\x09\xbd\x26\xac\x2b\xa8\xb9\x36\x1b\xb7\x56\xf6\x5e\xef\x59\xd8\x55\x2e\x02\xc8\x3b\x41\x64\xde\xb7\xa1\x6e\x79\xfc\x72\x49\x1d\x2c\xc3\xe7\xc8\x04\x45\x77\x52\xdc\x81\xa7\x27\xbf\x8b\xc6\x5e\xd7\xfe\xae\x78\x98\x04\x3a\x1d\x98\xa6\x2e\x92\x8c\x09\x45\xb1\x6b\xd6\x19\x5a\x9b\x99\xe9\xcc\x28\xb1\xe7\x9b\xcf\x4b\xb5\x2f\x95\xc2\x61\xab\x18\x9e\x33\xde\x1f\x76\x64\x5d\xa4\x9d\xab\x78\x18\x46\x95\x60\x07\x58\x1e\xbd\x58\x98\xe9\x17\x21\x74\xf3\x5d\xff\xad\x09\xc6\x8e\x93\x00\xd5\x06\x52\xbf\x75\x91\x2c\xdc\x6b\xd3\xce\x5f\x68\x5a\x11\x50\xef\xaa\x66\x7a\xfa\xc2\x42\xd8\x4b\x90\xd3\x24\xb1\x4b\xe6\xd8\x8e\x1a\xe3\xf8\xaf\x00\x00\x00\xff\xff\x5f\xd1\x4e\x78\x15\x0f\x00\x00")
+var _admin_http_assetsAppJs = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xec\x58\x6d\x6f\xdb\xb6\x13\x7f\xef\x4f\x71\x7f\x21\x80\xa5\x56\x91\xdd\xfe\x87\x02\x53\xe0\x75\xde\xba\xa2\x05\x56\x60\x48\xbb\xed\x45\xe6\x02\xb4\x78\x96\x09\xd3\xa4\x40\x52\x4e\x0c\x47\xdf\x7d\x20\xf5\x2c\xb9\x59\xd6\x6c\xef\xd6\x06\x88\x44\xde\xdd\xef\xee\x77\x0f\xa4\x72\x20\x0a\x48\x96\xc1\x02\x04\xde\x02\x11\x69\xce\x89\x8a\xf6\x92\xe6\x1c\x7d\x2f\x21\x6a\x2d\xc5\xa5\x42\x4e\x8e\x97\x22\xf5\x42\xb8\xf1\x44\x7a\x8d\x5a\xe6\x2a\x41\x2f\x04\x2f\x67\xd1\x5a\x4a\xa3\x8d\x22\x99\xb7\x0a\xae\x26\x13\x92\x65\x51\x22\x85\x51\x92\x73\x54\xbe\xf7\x81\x30\xf1\xa3\xe1\x4e\xf7\x42\x27\x32\x73\x7a\x17\xaa\x63\xe4\x62\x2f\x29\xb1\x12\x9b\x5c\x24\x86\x49\xe1\x97\x82\x21\x34\x62\x21\x94\x42\xc1\x69\x02\x50\xee\x46\x84\xa3\x32\x1a\x16\x70\xb3\xba\x9a\x00\xd8\x50\x3e\x91\x35\x47\x58\xb4\x7a\xbe\x37\x33\x76\x6d\xe6\x05\xb5\xcc\x0f\x9c\x24\x3b\xce\xb4\x19\xc8\xad\xeb\x75\x3d\x8b\x99\xa0\x78\xd7\xaa\x2c\xd3\x54\x61\x4a\x8c\x54\x03\x1d\xd2\x6c\x8c\x95\xae\x65\x6e\x86\xbe\x28\xbb\xa6\x67\xf1\x0e\x8f\x5e\x08\xa7\x1d\x1e\x63\x98\x7e\xbf\xc3\xe3\xb4\x08\xe1\x54\x34\xba\x6f\x50\x1b\x26\x88\xe5\xe2\xcb\x16\x66\xb4\x95\xea\xc2\x4f\x5a\x8a\x0e\x84\x33\xba\xa4\x54\xa1\xb6\x44\xcd\x3e\xdf\x7c\x8e\x57\xcf\xff\x88\x6f\xe6\x97\xdf\xae\x9e\xfb\xb1\x7b\x0d\x5e\x5f\xcc\xae\x06\x3a\xce\xf9\x4f\xc7\xcc\x06\x30\xfb\xec\x6b\x14\xd4\x5f\x72\x7e\xff\x96\x29\x6d\x82\x0f\xc4\x24\xdb\xe0\xde\x4f\xa4\xd0\x4c\x1b\x14\xe6\x1d\xd1\x5b\x26\xd2\xe0\xde\x57\x32\x17\xf4\x5a\xae\x99\x08\xee\xfd\x5b\x64\xe9\xd6\x20\x0d\x66\x43\xfb\x98\xe2\x1d\x2c\xc0\x6f\x52\x1e\x80\x4d\xad\xfd\xaf\xd0\xe4\x4a\x34\xaf\xf6\xc7\xa0\x36\x71\x5b\x1e\x07\xc2\x73\x6c\x15\xea\x7f\x96\x75\xa6\x7f\xb3\xfe\xc3\x02\x8c\xca\xf1\x6a\x20\x61\xd4\x71\xa4\x05\xae\xf4\xaf\x31\xfd\xe9\x2e\xab\x2c\x0f\xd5\x0a\x48\x6c\xc4\xfe\x19\x4c\xe8\x20\x6e\x08\xd7\x23\xc8\x62\xf0\x5e\x45\x57\x69\x5d\x4d\xc6\x92\x85\x5d\x2c\x02\xdf\x76\x53\x9f\xb5\x65\x9a\xbe\xcd\x45\xf2\x4f\xf3\xc6\x36\x50\x6e\xc1\x62\x01\x9e\xce\xf7\xde\x58\xa6\x03\x70\x8e\xd8\xe2\x41\x93\xe4\x90\x3e\xdd\x64\x25\x3a\x22\xf9\x41\xda\xaa\x3e\x6f\x18\x60\xf4\xce\x8d\x10\x28\x47\x45\x94\xa2\x69\xa9\xa4\xc4\x90\x6a\xb7\x31\x60\xaa\x89\x62\xf7\x4a\x0f\xcb\x2e\x2d\x86\x28\x15\xee\x6c\x06\x2a\xe7\xa8\xc1\x6c\x89\x01\xca\xa8\x98\x1a\x30\x64\x87\x40\xc4\x11\xf6\x68\x14\x4b\x60\x23\x15\x10\xa0\xe4\x08\x44\x21\x6c\x59\xba\xe5\x65\x9f\x84\xa0\x25\x50\x24\x14\xa4\x40\x0d\x09\x11\xb0\x46\xd8\xd8\x96\x02\x22\x28\x28\xdc\xcb\x03\xd2\x16\xd9\xca\x2e\x37\x06\xed\x60\x7a\xf9\x0d\x3c\x83\xff\xbf\x9a\xcf\x3b\xcd\xcc\xf4\x1b\x6b\xad\xc3\x80\xf5\xae\xce\x45\x45\xe9\xff\xec\x5a\xc4\x89\x36\xef\x98\x81\xfb\x7b\x78\x43\x0c\x46\x42\xde\xfa\x01\xcc\xe0\xc5\x7c\x3e\x87\x4b\xe8\xc9\x7c\x37\xc2\xaf\x38\x69\x80\x6b\xc9\x87\x91\x7b\x46\x5f\xbb\x46\xb4\xd8\x7e\x6f\xfd\x99\x73\x21\x88\x8c\xfc\x59\x26\x84\xe3\x47\xa3\x98\x48\xfd\x00\x62\xf0\x04\x1e\x50\x79\xa3\x84\x08\xbc\x5d\xa6\x69\x75\xaa\xb5\xd3\xdb\x3f\xd9\x91\x16\x83\x47\x52\x7b\x98\xbd\x17\x06\xd5\x81\xf0\xf8\xd5\x3c\x84\xdf\x09\x33\x31\xbc\x78\x39\x2f\x82\x4e\x18\x84\xd2\xde\xf0\x6f\x82\xa9\x03\x39\x7f\x16\x35\xeb\xa5\x23\xd1\x85\x26\x07\xf4\x83\xc8\x6c\x51\xb4\xf5\xa6\x50\x67\xb5\x9d\x9e\x06\xa5\x4f\x71\x1d\x60\x54\x98\x76\xa9\x08\x1b\x60\x54\x6a\x84\xdb\x46\x70\xda\xeb\x34\x06\x54\x2a\xb2\x45\x1f\xa1\x52\x52\x15\xab\x41\xf1\x37\x7a\x65\x49\x9e\x27\xa9\xed\x36\x3b\x0f\x12\x29\x36\x4c\xed\xfd\xe9\x52\x21\x1c\x65\x0e\x3a\xaf\x1e\x6e\x89\x30\x60\x6c\xe5\x73\x34\x08\xed\xa9\x0a\x28\xec\xc8\x16\x32\x82\x29\x3c\x07\x6b\xaf\x75\xbc\xc5\x8c\x4a\x45\xff\x34\x75\x07\xf7\x34\x66\xf4\xee\x61\x2e\xce\x14\x4c\x7d\x5e\xbb\xd3\xc0\x3e\xfb\xa7\x5f\x58\xb2\xe3\x18\x97\x33\x27\x84\x8f\x99\x94\x3c\x76\x07\x4b\x08\x83\xbd\x2a\x3b\xf6\xa0\x5c\x72\xee\x0e\x48\x6f\x58\x49\x35\x42\xc3\x4f\x1d\xcb\x28\x05\xa3\x22\x72\xaa\x7f\xb3\x8c\xfe\xad\x80\xbe\xc4\xe9\x7f\xf5\x35\xac\xaf\x66\xcf\xe6\xad\xeb\xb8\xb2\xa9\xa9\x91\x46\x04\x55\x5c\xb8\x9c\x39\x55\xff\x54\x84\xe0\x74\x3a\x77\xe4\x00\x4e\x7d\x64\x28\x42\xa7\xd7\x8a\x94\x79\x78\x74\x06\x5a\xf6\x87\xf4\x77\xef\xcc\x4f\x65\xbf\xb9\x67\x3f\x48\x7e\x83\xf8\xf4\xde\x2e\x43\x18\x35\xdf\x0e\x8f\x7f\xe5\xfe\xeb\x69\xc7\x23\x67\xa0\xf5\xc6\x5e\xda\xed\x2d\xfc\x6b\x7c\xe9\x5f\xef\xbb\x1e\x85\xf0\x08\x56\x7b\x6e\x41\xc7\xd8\xd8\xbb\x10\xbe\x9a\x36\x99\xa1\x18\x92\x06\x2e\xe9\x15\xb6\xbd\x6e\xbb\x8f\xb2\xf7\x42\x1b\x22\x12\x2b\x58\x7e\xa5\x39\x5d\xbf\xf6\xd0\xe0\x3e\xe3\xc4\xe0\xaf\x8a\xc7\x30\xcd\x33\x4a\x0c\x3a\xc3\x1f\x9c\xec\xd6\xec\xf9\xb4\xaa\x5c\xd8\xe1\x71\x2d\x89\xa2\xf5\x18\xaa\x96\xdb\x0f\xca\xf6\x36\x0b\xed\x57\x62\xcf\x8b\xaa\x53\x3a\x0c\xd5\x11\xa9\x2a\x1a\xf7\xbb\xa6\xa2\x8d\x77\xd7\x0b\xb4\xab\x0f\x03\x88\x28\xe1\x52\x63\x85\x1f\x39\x73\x0d\xb5\x55\xe3\xf7\x4c\x27\x96\x1d\xfe\x78\xf3\x94\xe9\x3d\xd3\xda\x9f\x96\x8a\xd3\x73\xc6\xeb\x66\x07\xe7\x44\x5c\x41\xd5\x8b\xf6\x8b\x91\x1f\x30\xee\xa0\x38\x37\xe3\x2f\xba\x50\xdd\xc8\xea\x3f\x01\x24\x32\x3b\xf6\x02\xd4\x37\x8c\xde\xad\xba\x9e\x54\x4f\x45\x33\xb8\xdd\x43\x3f\x12\x85\x3a\xe7\xa6\x7f\x60\x41\x7f\xfc\x35\x2c\xb9\x49\xd7\x25\xb3\x1d\x47\xc5\x2a\xb8\x9a\xfc\x39\x00\xbc\x49\xe3\xf1\xac\x10\x00\x00")
 
 func admin_http_assetsAppJsBytes() ([]byte, error) {
 	return bindataRead(
@@ -105,12 +105,12 @@ func admin_http_assetsAppJs() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "admin_http_assets/app.js", size: 3861, mode: os.FileMode(420), modTime: time.Unix(1444148082, 0)}
+	info := bindataFileInfo{name: "admin_http_assets/app.js", size: 4268, mode: os.FileMode(420), modTime: time.Unix(1792366506, 0)}
 	a := &asset{bytes: bytes, info:  info}
 	return a, nil
 }
 
-var _admin_http_assetsIndexHtml = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xd4\x1b\xed\x6e\xdc\x36\xf2\x7f\x9e\x82\x11\x0a\x38\xc1\x45\x92\xed\xa4\x68\x6b\xec\x1a\x48\xdc\xe4\x92\xbb\xe6\x62\x38\x29\xee\x0e\x41\x0e\xe0\x8a\xdc\x15\x6d\x4a\x54\x48\x6a\x6d\x5f\x90\x77\xbf\x19\x4a\x5a\x69\xb5\x92\x56\x5b\x7f\xf5\xda\x34\x16\xc9\xe1\x70\xbe\x67\x38\x66\x27\x8f\x7f\xfd\x70\xf2\xe9\xdf\xa7\xaf\x49\x6c\x13\x79\xfc\x68\x82\x3f\x88\xa4\xe9\x62\xea\xf1\xd4\x23\xe9\xc2\xa7\x59\x36\xf5\x22\xaa\x67\x2a\xf5\x35\x97\xf4\xda\x4f\x17\x1e\x42\x72\xca\x8e\x1f\x11\x32\x49\xb8\xa5\x24\x8a\xa9\x36\xdc\x4e\xbd\xdc\xce\xfd\x9f\xbd\x7a\x21\xb6\x36\xf3\xf9\xd7\x5c\x2c\xa7\xde\xbf\xfc\xdf\x5f\xfa\x27\x2a\xc9\xa8\x15\x33\xc9\x3d\x12\xa9\xd4\xf2\x14\x76\xbd\x7b\x3d\xe5\x6c\xc1\x1b\xfb\x52\x9a\xf0\xa9\xb7\x14\xfc\x32\x53\xda\x36\x40\x2f\x05\xb3\xf1\x94\xf1\xa5\x88\xb8\xef\x06\xcf\x88\x48\x85\x15\x54\xfa\x26\xa2\x92\x4f\x0f\x36\xd0\x30\x6e\x22\x2d\x32\x2b\x54\xda\xc0\xb4\x01\x46\x73\x1b\x2b\xbd\x01\x21\x45\x7a\x41\x80\xf5\xa9\x27\x22\x44\x10\x6b\x3e\x9f\x7a\x41\x10\xc2\x9f\x39\x5d\xe2\x64\x00\x7f\x01\x30\x42\x5b\x61\x25\x3f\x3e\x29\x04\x76\xe6\x04\xf6\x8f\xbf\x12\xca\x12\x91\x4e\xc2\x62\xd1\xc1\x3d\xf6\x7d\xf2\x1b\xb5\xdc\x58\x38\x2f\xc9\x84\xe4\x8c\xd0\x94\x11\x80\x13\x73\x01\x83\x93\x8f\x1f\x89\xef\xb7\x28\x30\xf6\x5a\x72\x13\x73\x6e\x2b\x3a\xc2\x30\xa1\x57\x11\x4b\x83\x99\x52\xd6\x58\x4d\x33\x1c\x00\xca\x70\x35\x11\x3e\x0f\x0e\x83\xfd\x30\x32\xa6\x9e\x0b\xe0\x9c\x00\x66\xbc\x9a\x9a\x0f\x4e\x40\x54\x12\x1b\xf3\x84\xdf\xe1\xd9\xbe\x3b\xa0\x45\xc1\xe0\x39\x60\x84\x2d\x62\xdf\x7e\x7a\xff\xdb\x8f\xc4\xc4\x22\x71\x52\x3b\xe3\x26\x53\x29\x0b\xce\x0d\x79\xf7\xfa\x67\x62\xf2\x0c\xcd\x86\xa8\x79\x09\xc8\x25\x9c\x98\x5a\x53\x88\x98\x33\x41\xc9\xd7\x9c\x6b\xc1\x4d\xc5\x27\x20\xfd\x2c\xe6\x44\x5a\x40\x40\x7e\xf9\xe2\xe6\x0a\xab\x21\x46\x47\x53\x0f\x0d\xd9\x1c\x85\xa1\x32\x26\x28\xb9\x46\x46\xd1\x61\x7e\x04\x32\x96\xc0\xe8\x4f\xc1\x61\x3d\x76\xec\x9d\x03\xc9\x93\xb0\x40\x33\x16\xa3\x2e\x58\x09\x0f\x82\x17\x80\xaf\x1c\x75\x63\x7b\xfc\x99\xa7\x4c\xcc\xbf\x20\x0b\x93\xb0\xf0\xc8\x47\x93\x99\x62\xd7\x44\x2b\x89\x86\xaf\xa2\x1c\xf9\xf6\x48\x2d\xb9\x37\xe2\x0a\xac\x2b\xa5\xcb\x19\xd5\x15\xf3\x4c\x2c\x49\x24\xa9\x31\x53\xaf\x5c\x28\x7e\xf8\x22\x5d\x72\x70\x6c\xaf\xc4\x07\xb3\x62\x41\x9d\x1f\xe1\xbe\xf5\x9d\xe8\x36\x54\xa4\x5c\x97\x6b\x5d\x78\x7d\x24\xb2\x01\x01\x30\xb4\x05\x31\xd3\xa0\xa3\x95\xe6\xbd\xb6\x2b\x4d\x42\xba\x42\x1f\x02\xfe\x81\xb3\x22\x25\x25\xcd\x0c\x27\xd5\x47\xf3\xd8\x5c\x36\xa0\x2b\x76\xe1\x47\x03\xc6\x59\x65\x05\x45\x23\x2b\x96\x7c\x6d\xd5\x11\xbf\xa2\xf3\xad\x4a\x78\x83\xb8\x82\x40\x29\x5a\xe8\xfa\xf6\xcf\x28\x7b\xcf\xad\x16\x91\x09\x0f\x5f\xc4\xa0\x6a\x14\xf1\x2b\x8a\xc6\xea\x66\x07\x31\x4f\xc2\x5c\x76\x0b\x05\x14\x1e\x06\xc0\x55\x2d\x0b\xd0\x78\x0d\x53\x7e\x3c\xea\x53\x64\xa9\xf6\x04\x46\x2e\x19\xe0\x0a\x4c\x49\xae\xa7\xde\x7b\x98\x3c\xb1\xb2\x61\x08\x5d\xaa\xd0\xea\xb2\xd8\x29\x15\xbd\x68\x6a\x1d\x70\x58\x5c\xd0\x3c\xe3\x14\x62\x6d\x31\x21\x52\xe2\x3e\xc0\xd2\xbf\x7d\x73\x5f\x41\x62\x16\xdf\xbf\x03\xfb\x38\x68\x20\x58\xa3\x57\xfa\x09\xf3\x0f\x0e\xdb\xda\x89\x0f\x8e\x5f\x49\x1a\x5d\x48\x61\x2c\xf8\xc7\x41\x6b\xd9\x52\xc8\x42\x15\x92\x62\xe0\xfe\x46\x36\x19\x4f\x0d\x67\x2d\x84\xb8\xa7\xca\x7b\xed\x79\xbd\x39\xe9\xc0\x8f\xdf\x53\x1b\xc5\x24\x03\x35\x8b\x2b\xc8\x00\xf1\x30\x9c\xc9\x67\x10\x23\x45\xba\xd8\x0e\xaa\xf9\x82\x0f\x62\x7c\x19\xa1\xa7\x9a\x6e\x10\x98\xdd\x20\x19\x21\x3b\xd8\x9b\x58\x17\x52\x1a\xca\x9a\xa1\xa2\x9c\xac\x82\x59\x25\xe0\x0d\x59\xf5\x4a\x05\x17\x18\xe8\x77\x16\x14\x52\x41\xf5\xda\x0e\xa1\x36\x21\x57\x72\x19\x03\xec\x24\xb3\x05\x70\xa5\x77\x7e\x65\xfd\x08\x62\x24\x46\x25\xf0\x47\x67\xab\x22\xba\x00\xd3\xe5\x89\x5a\xf2\x95\x01\x3d\xf9\x41\x80\x55\x5c\x3d\x05\xa8\x55\x50\x58\xc8\xeb\x2c\xc6\x02\x80\xac\xbe\xfc\x62\x9b\x1f\x09\x1d\x41\x8d\x13\x1e\xa3\xe7\x76\x53\xd2\xa3\x02\x14\x76\xcb\x52\x43\x27\xeb\x4d\xeb\x7e\xb9\x58\x00\xaf\xd4\x2a\x6d\x3a\xec\x7b\xae\x74\x52\x95\x36\x8b\xc5\x1b\x18\x79\x15\xe1\xb8\xe4\x53\xc6\x88\xfb\x58\x68\x95\x67\x24\xa6\xc6\x9f\x73\xce\x66\xc0\x70\xe5\xf9\x73\xb7\x09\x64\x02\xf2\x4f\x04\xba\x29\x63\xf5\xa1\x4f\x9e\xc2\x9a\x5a\x52\x29\x18\x54\x32\x0f\xe2\x5d\x6f\xf2\xd4\x59\xf9\x90\x1f\x9c\x6d\x73\x94\x0f\xb9\xcd\x72\xeb\x64\x41\xed\x10\xe0\x3b\x34\x13\xe0\x97\x3c\x31\x3c\x7a\x3a\x04\xf9\x4f\x2a\xec\x76\xa8\xbb\xf3\x50\x5a\x7b\x28\xad\x8d\x64\x77\x1f\xa5\xc1\x3c\x4f\xb7\xfb\x1c\x1d\xe5\x73\x0e\x10\x84\xfd\x26\xb1\x63\x20\x2b\x69\x8f\x81\x45\x79\xdf\x92\xc7\x37\xec\xfb\xc6\x2e\x3f\x4a\xa9\x3d\x5e\x5f\x90\xdd\x37\xdf\xaf\xb5\x35\x1f\xef\x70\xed\xee\x8d\x6e\xb3\x48\xd1\x0f\x40\x20\x89\x62\x58\x8a\xa7\xfc\x12\xa4\x11\x80\x93\x79\x65\x20\x99\xe3\x67\xf3\x80\xb2\x24\xf0\x48\x06\x81\x92\xc7\x4a\x32\x2c\x0e\x2a\xb7\x84\x38\x82\x97\x3f\x8d\x15\xe7\xc2\x87\x8b\x1f\x08\x3d\x85\x7b\x1d\x86\x0c\xc0\x8c\x60\x43\xf4\x60\x92\xc7\xe0\x13\xab\xcb\x55\x0c\x43\x83\x0c\x40\x31\x0e\xc7\xc0\x66\x87\xc0\x64\x34\xed\xc1\xc0\xb5\x56\x3a\x28\x49\xf2\x8e\x5f\x5f\x65\x3c\xb2\x78\x09\x2b\xd5\x0f\xd4\x93\x79\xc9\xc6\x11\xe4\xe5\x84\x28\x4d\xe8\x12\x12\x33\x22\x1d\x20\xba\x59\x80\xb5\x97\x46\x98\xe7\xed\xa9\xcd\xc5\xbd\x4a\x71\xba\x18\x6c\x57\x5d\x09\xb8\xa1\xae\x02\xdb\xae\xca\x72\xd8\x6e\xa8\xae\x12\x47\x9f\xc2\x1c\x66\xac\x86\x72\x09\x17\x17\x7e\x05\x45\x85\x31\x2e\x25\xfc\xbf\x28\xaa\x08\x89\x95\xa6\x54\x6e\xe7\x38\xda\xae\x2a\x80\xac\x1d\xec\x21\x38\xb1\xd7\x19\xde\x0a\xf3\x64\x86\x97\x85\x0d\xbe\xaa\x00\x5e\x71\x26\x56\xe3\x4e\xde\x9a\x26\x17\xfe\xe7\xf3\xbe\xff\xcb\x97\xbf\xfc\x10\xee\x6c\x72\xd5\x29\x37\xb4\xba\x1a\x4d\x6f\xa4\x20\x05\xe7\xe4\x09\xe4\x5a\x48\xf5\x50\xd7\x98\xa7\xc4\x2a\x02\xe5\x8d\xb2\x9c\x7c\xcd\x69\x6a\xc5\x7f\xa1\x68\x25\x15\xb2\x3f\xad\x4d\x6e\xd1\x24\xa6\xd7\x4a\x8b\x97\xee\xfb\x2e\x35\x88\x27\xdc\x50\x7b\x05\x8a\x3f\xaa\xb9\x84\x5e\x11\xc4\x70\x57\xea\xea\x59\x98\xe5\xd6\x42\xd6\x29\x45\x3b\xb3\x29\x81\xff\x7c\x93\xb8\x1f\x99\x16\x09\xd5\xd7\xee\x7b\x26\x15\xd6\xe8\x85\xd2\x8a\xd2\xdc\x09\x9f\x09\x83\x25\x1f\xab\xe5\x50\x4b\xf1\x25\x63\x93\xb0\x38\x61\x17\x8a\x77\x28\x58\xba\xaf\x29\x21\x9a\xc8\xe6\xd5\xe5\x0c\x82\x97\xbb\xe6\x0e\x5d\x5b\xc0\x90\x2d\xbf\xb5\x8b\x0b\x1e\xc9\x1f\xf8\xce\x52\xd4\xfd\xae\xe0\x2f\xda\x71\x97\x7c\x4f\x4a\x82\x2e\x88\xa5\xa4\x21\x31\xd7\x55\xef\xb5\x6b\xa7\xe3\x81\x5c\xf0\xeb\xc1\x0b\x8f\x03\x42\xeb\xd8\xde\x45\x78\x90\xc6\x04\x63\x98\xa3\x87\x40\x3e\x66\x4a\xc9\x21\x80\x53\xa8\xd8\xe5\x10\x7f\xd8\xf5\x43\xef\x9d\x1e\x92\x3b\xbc\x67\xe9\xfa\x9e\xe5\x8c\x75\x87\x2b\x16\xc6\x81\x22\x7a\x0d\x5c\x2d\x20\xdf\x5f\x7b\x84\x6a\x41\xfd\x58\x30\xb0\x41\xb0\x4b\x9d\x73\xd7\x08\xc6\xd0\xd4\x17\x66\x1a\x39\x41\xa4\x73\x85\xed\x34\x1d\x80\xd9\xf4\xdf\x91\x3a\x77\xa0\x0d\xed\xb8\x25\x41\x1b\xe0\x7a\x6b\x6f\x67\x70\xf3\x88\x76\xcf\xe0\xfe\x2d\xb7\xd1\xf6\xde\x95\xad\x78\xcf\xbd\xd1\x22\xad\x37\x1d\x76\xde\x23\x8b\x70\xe3\xa4\x7e\xc7\x4d\x23\x67\x62\x4d\xab\x64\x68\x95\x3a\x60\xdc\x40\x8c\x6d\xf6\xeb\x3b\x78\x3a\xc6\x30\xb4\xd5\x0e\x41\xaa\x4b\x8d\x84\x8a\x45\x6c\x87\x0c\x12\x42\xd7\x70\xea\xbb\xc1\x6a\x21\x62\x47\xe3\xb7\x3d\x46\xd3\x05\xd7\x7b\x47\xe4\x31\x0b\x54\x2a\x45\xca\x9f\x91\x3d\x54\x0c\x4c\x55\x33\xdf\xd1\x2a\xd8\x68\x93\xbc\x95\x43\xc6\x76\x2a\x6f\x7a\xce\xa8\x26\xe7\x1f\x3c\x83\x16\x01\xfa\xf6\xb1\x77\xf6\x60\xba\xf1\x3b\x03\x5c\xd5\x75\x2c\x30\x98\x11\xbc\x21\x1b\x8d\x19\x03\xd7\xe9\xf2\xdc\x01\x8f\x6e\xf0\x40\x2a\x26\xc8\xdd\x71\x91\xb9\xb4\x35\xc8\x86\xba\xf0\x8d\x58\xa4\xf7\xc5\xca\x70\x10\xfb\xb5\x0e\x21\x45\x28\x7b\xf6\x70\x6d\xf0\x9d\xdb\x61\xf7\x95\x61\x77\xbb\x75\x75\x75\x01\x5c\xae\x08\xfe\xce\xaf\xab\x4b\xd6\x27\x48\xbc\x3d\x97\xac\x55\x47\x6d\xbd\x6d\x53\x15\x86\x9d\xa7\xde\x23\x13\x05\xe5\x5b\xb9\x58\x23\xde\xf0\x94\xbd\x94\xd2\x95\x8f\x43\x2d\x43\x77\x82\x43\xda\x43\xd5\xda\x75\x72\x75\x7b\x70\x34\x6d\xbf\x50\xb6\xae\x92\xed\xed\x7d\x97\xc9\x26\xf1\xcf\xdc\xe8\x8d\xd0\xc6\x96\x63\xa5\xf1\x3d\x89\x11\x06\x9f\x94\xbc\xa5\x26\x76\x25\x74\xff\xb5\xb2\xf7\x4a\x79\x8f\x2a\x3c\x75\x79\xb2\x52\x62\x35\xda\xae\xc6\x12\xf2\x81\xa9\xff\x58\x25\xe0\x8a\x81\xc6\xc4\x76\x1e\x6a\xe0\x07\x66\x63\xad\x7b\x7b\x36\xb6\x7b\x7b\xb6\x73\xf7\xf6\x1e\x59\x2a\xef\x7d\x15\x53\xab\xe1\x0e\x61\xae\x46\xb1\xf1\xeb\x84\x72\xe5\x3e\x79\x2c\x1a\x30\x50\x8e\x45\x17\x33\x75\xe5\x75\x5a\x63\x51\xb6\x94\x96\xb8\x56\xc3\xac\xf1\xfb\x27\x23\xfb\xb4\xac\x53\xca\x10\xb0\x5e\xb5\x8c\x24\xbc\x27\x23\xdf\x79\xef\xab\x0e\xdc\xa3\xbb\x5f\xb7\xff\x0b\xfa\x76\xdf\x6b\xfd\x5d\x4e\x3d\x68\x3c\xc1\x59\xbd\xca\xc1\xbe\x50\xb8\x7a\x85\xe3\xba\x41\xd5\xf4\xab\xea\x11\x1d\x64\x15\xcd\xc9\xdf\xe8\x92\x7e\x74\x0f\xc2\x1c\xb2\xe9\xce\xff\x34\x9e\xbf\x91\x53\x74\x34\x46\xa8\xc5\x17\x80\x04\xf2\x18\xbe\x9f\xc3\xcf\xea\x19\x19\x31\xca\x8d\x33\xba\xe0\x86\x48\x45\x19\x99\x53\x48\x6c\xab\x77\x64\x5d\xcf\xdb\xe8\x39\xbd\x0a\x16\x4a\x2d\x24\xa7\x99\x30\xee\x8d\x1b\xce\x85\x52\xcc\x4c\x78\x8e\xcf\xf0\xae\xc3\x83\xe0\x00\xfe\x94\xa3\xed\x4f\xe7\xc6\x3f\x3c\x3c\x6f\xbf\x79\x5c\xc7\xdb\x47\x74\x04\xee\x10\x40\x05\x8d\xbf\x52\x3a\x37\x81\xd2\x0b\x20\xf1\x30\x38\xd8\x0f\xcb\xc9\xf1\xcf\xfb\xb6\xa2\x82\x52\xd9\xa8\x5c\x47\x7c\x0c\xdf\xc0\x27\x20\x89\xa4\xca\xd9\x1c\xf6\xf2\x96\x38\x2b\x94\xb9\xf0\x6b\x49\xec\xa3\x70\xf7\xc3\xe6\x9c\x6f\x33\x69\xb6\xc8\xc2\xbd\xba\xec\x23\xa7\x70\x3f\xbc\x00\x85\xe0\x7b\x96\x27\x10\xa5\x2d\x44\x08\x01\xfe\x97\x67\xd8\x5a\x75\x71\xe4\xbd\x62\x54\x06\xf8\x28\xb2\xe3\xc1\x60\x82\x8b\xed\x17\x81\x93\xf8\xf9\xfa\xba\x7b\x33\xeb\x1d\xff\xee\x90\x12\xe7\xdb\x47\xe4\xdb\x37\xf7\x51\x75\xb4\xe2\xe7\x6b\xce\xe4\x3e\x1b\x6d\xe4\xf9\x0d\x3a\xc8\x1b\xad\xe2\x4d\x0e\x30\x1e\x34\x9f\x16\x36\x00\xea\x33\x5a\xef\x0a\xe9\x8c\x4b\xa4\x60\xea\x61\x16\xf3\x8e\x4f\x8b\x5c\x36\x09\xdd\xca\x1a\x6c\x3b\x9d\x16\x9c\xe3\x86\x2a\x3c\x3b\x14\x63\xea\xb3\xb2\x76\x1d\x53\x1e\xac\x97\xd2\x88\xd4\x95\xbe\x7d\x55\x74\xab\x76\x6e\xc0\x0f\xff\xda\x76\xe8\xd7\xb5\xad\x52\xb8\x3d\xdc\x4d\xcc\xd8\xd2\xf0\xea\xce\xf3\x58\x31\xe3\x86\x4a\xcc\x0e\xc5\x08\x31\x1f\x1c\xfe\x14\xec\xc3\xbf\x07\x47\x87\xfb\xfb\x2f\x06\x9f\x40\x74\xd4\x2c\x1d\x82\xc7\x83\x77\x11\x7c\x01\xdf\x23\xf8\x23\xb2\x17\x2b\x63\x8f\xf0\x81\xf4\xde\x6e\x42\xef\x7d\x70\x5b\xf8\xc1\x1c\xa2\xcb\xfa\xdb\xde\x8e\xa4\x8e\x29\x9c\xf1\x39\xcd\xa5\xf5\x1a\xdd\x86\x88\xa6\x11\x97\x4f\x9e\x7a\xc7\x27\x52\x19\xbe\x99\xab\x7b\x0a\x84\xb2\x32\x68\x55\x00\xf3\xb5\xe4\xdf\x38\x46\x5d\xe0\x11\x45\x2c\x69\x9f\xb1\x96\x94\xab\xe4\x5d\xc7\x3e\x00\x77\x79\x7f\x12\x16\xff\xaf\xc4\xff\x02\x00\x00\xff\xff\x6f\x8f\xdb\x3f\x3c\x31\x00\x00")
+var _admin_http_assetsIndexHtml = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xd4\x3a\x6d\x6f\xdc\x36\xd2\xdf\xfd\x2b\x18\xa2\x80\x6d\x3c\x91\xe4\x75\x5a\xb4\x35\xa4\x05\xdc\x34\x79\x92\xbb\xe6\x12\x24\x2d\x7a\x87\xa2\x07\x70\xc5\x59\x89\x36\x45\xaa\x24\xb5\xb6\x2f\xf5\x7f\x3f\x0c\x25\xad\xa4\xf5\xae\x76\x37\x4e\xec\xde\xc6\xc8\xf2\x65\x38\x9c\x37\x0e\x67\x66\x19\x3f\xf9\xf1\xed\xf3\x9f\xff\xf5\xee\x05\xc9\x5d\x21\xa7\x07\x31\x7e\x11\xc9\x54\x96\x50\x50\x94\xa8\x2c\x60\x65\x99\xd0\x94\x99\x99\x56\x81\x01\xc9\x6e\x02\x95\x51\x84\x04\xc6\xa7\x07\x84\xc4\x05\x38\x46\xd2\x9c\x19\x0b\x2e\xa1\x95\x9b\x07\xdf\xd1\x6e\x22\x77\xae\x0c\xe0\x8f\x4a\x2c\x12\xfa\xcf\xe0\x97\xf3\xe0\xb9\x2e\x4a\xe6\xc4\x4c\x02\x25\xa9\x56\x0e\x94\x4b\xe8\xeb\x17\x09\xf0\x0c\x7a\xeb\x14\x2b\x20\xa1\x0b\x01\x57\xa5\x36\xae\x07\x7a\x25\xb8\xcb\x13\x0e\x0b\x91\x42\xe0\x3b\x4f\x89\x50\xc2\x09\x26\x03\x9b\x32\x09\xc9\xe4\x0e\x1a\x0e\x36\x35\xa2\x74\x42\xab\x1e\xa6\x3b\x60\xac\x72\xb9\x36\x77\x20\xa4\x50\x97\xc4\x80\x4c\xa8\x48\x11\x41\x6e\x60\x9e\xd0\x30\x8c\xc2\x30\x9a\xb3\x05\x0e\x86\x22\xd5\x74\x7a\x80\xf8\x9c\x70\x12\xa6\xcf\x6b\x81\xbd\xf7\x02\xfb\xc7\xff\x13\xc6\x0b\xa1\xe2\xa8\x9e\xf4\x70\x4f\x82\x80\xfc\xc4\x1c\x58\x47\x52\x5d\x94\x42\x02\x27\x4c\x71\x52\x08\x25\xe6\x02\x38\x79\xfe\xe1\x03\x09\x82\x15\x0a\xac\xbb\x91\x60\x73\x00\xd7\xd2\x11\x45\x05\xbb\x4e\xb9\x0a\x67\x5a\x3b\xeb\x0c\x2b\xb1\x93\xea\x22\x5a\x0e\x44\xcf\xc2\xd3\xf0\x24\x4a\xad\xed\xc6\xc2\x42\xa8\x30\xb5\xb6\xa1\x1a\xa9\x79\xeb\x05\xc4\x24\x71\x39\x14\xf0\x05\xf7\x0e\xfc\x06\x2b\x14\x8c\xee\xc3\xca\x72\x85\xd8\x57\x3f\xbf\xf9\xe9\x1b\x62\x73\x51\x78\xa9\xbd\x07\x5b\x6a\xc5\xc3\x0b\x4b\x5e\xbf\xf8\x8e\xd8\xaa\x44\xb3\x21\x7a\xde\x00\x82\x84\x02\x94\xb3\x1e\xb8\x00\x2e\x18\xf9\xa3\x02\x23\xc0\xb6\x7c\x3e\x09\x82\xdf\xc4\x9c\x48\x47\x5e\xbf\x20\xdf\xff\xee\xe5\x5e\x5b\x0d\xb1\x26\x4d\x28\x1a\xb2\x3d\x8b\x22\x6d\x6d\xd8\x70\x8d\x8c\xe2\x81\xf9\xc6\xe6\x62\x11\x3d\x0b\xbf\x0d\x4f\xbb\xbe\x67\xef\xc2\xd2\x69\x1c\xd5\x68\x76\xc5\x68\x6a\x56\xa2\x49\xf8\x75\x78\xda\xf6\xd6\x63\x7b\xf2\x1b\x28\x2e\xe6\xbf\x23\x0b\x71\x54\x9f\xc8\x83\x78\xa6\xf9\x0d\x31\x5a\x42\x42\xb9\x4e\x2b\xe4\x9b\x92\x4e\x72\x2f\xc5\x35\x70\xa2\xd8\x62\xc6\x4c\xcb\x3c\x17\x0b\x92\x4a\x66\x6d\x42\x9b\x89\xfa\x2b\x10\x6a\x01\xc6\x02\x6d\xf0\x29\xb6\x10\x19\x43\x33\xf1\x87\x67\xb8\x12\x8f\x0d\x13\x0a\x4c\x33\xb7\x0e\x6f\x80\x44\xf6\x20\x08\x89\xd9\x0a\xc4\xcc\x30\xc5\x97\x9a\xa7\xab\x47\x29\x8e\xd8\x12\x7d\xc4\xc5\x62\x64\xaf\x54\x4b\xc9\x4a\x0b\xa4\x6d\xf4\xb7\xad\x64\x0f\xba\x11\x47\xa0\xd8\xa2\x07\xe3\xad\xb2\x85\x62\xa9\x13\x8b\x3e\x86\x86\xf8\x25\x9d\xaf\x74\x01\x3d\xe2\xf0\x2f\x8e\xa4\x18\xf4\xa5\xd8\xb4\x7e\xc6\xf8\x1b\x70\x46\xa4\x36\x3a\xfd\x3a\x0f\x2f\x2c\x8a\xf8\x07\xc6\x49\x51\x8f\x8e\x62\x8e\xa3\x4a\xb6\xbd\xa1\x50\x9e\x04\x41\x14\x2a\xb6\xe8\x64\x11\x04\xd3\x0e\xa6\x69\x1c\x6c\x52\x64\xa3\xf6\x82\x89\xfa\x32\x40\x15\x1b\x2d\x25\x98\x84\xbe\x61\x42\x3d\x77\xb2\x91\x48\xbc\x41\x15\x46\x5f\xd5\x2b\xa5\x66\x97\x2d\x00\x32\x2e\xc1\x38\x9c\x30\x50\x02\x73\x09\xad\x07\x84\x22\xbe\x61\xe9\xf4\xe3\x47\xdf\x0a\x0b\x9b\xdd\xde\xc6\x91\xef\xf4\x10\x0c\xe8\x95\x41\xc1\x83\xc9\xe9\xaa\x76\xf2\xc9\xf4\x07\xc9\xd2\x4b\x29\xac\x8b\xa3\x7c\xb2\x32\xed\xd8\x4c\x42\x8b\xa4\xee\xf8\xff\x83\x54\x2b\x0e\xca\x02\x5f\x41\x88\x6b\xda\x7b\x6f\xf8\x89\x9d\xb9\x3b\x88\xc3\xf9\xf4\x0d\x73\x69\x4e\x4a\x03\x73\x71\x1d\x47\x2e\x1f\x87\xb3\xd5\xcc\x3a\x23\x54\xb6\x1d\xd4\x40\x06\xa3\x18\x5f\x09\x67\xc7\xe6\x7f\x62\xd6\x91\x5c\xb8\x31\x98\xf3\x14\x4f\xfb\x06\x34\x71\x74\x97\xed\x38\x5a\x2b\xa2\xd8\x79\xb7\xd4\x53\xf8\x8c\x08\x55\xcb\x3b\x9c\xb5\x4a\xba\x23\x6f\x24\xc2\xa0\x99\x34\x5a\xfa\x78\x78\xc5\x8c\x12\x2a\x3b\x3c\x23\xc2\xfe\x08\x8c\x1f\xcd\x8e\x6f\xd7\x2c\xc3\xbf\xd8\xf1\xe9\xc7\x8f\xb3\xb0\x96\x3d\x1a\x91\xe3\xe3\x90\x4b\xe9\xef\x02\xec\xe5\xbf\x0b\x60\x2e\x9c\x25\x7f\xfe\x49\x4e\xb6\x03\x4b\x66\xdd\x2b\xe1\x90\xa9\x71\xd8\xa5\xd9\xc2\xb5\x0b\x52\x50\x0e\x9d\x6a\xcc\x6a\x51\x89\xf4\x32\xa1\x06\x0a\xbd\x80\xa5\xfd\x1f\x7d\x25\x14\x87\xeb\x63\x3a\x8d\x45\xbb\x38\x93\x37\x65\x8e\xf1\x0b\x59\xb6\x82\x7a\x59\x90\x0a\x93\x4a\xa0\xd1\x14\x1d\xcf\x7a\x4a\x36\x68\x1f\xf5\x3c\x1c\x8e\x23\xaf\xe6\x95\xc1\x7c\x32\x3d\xcf\x32\x03\x19\x73\xda\xd8\x35\xc7\x73\xae\x4d\xd1\x46\x66\x59\xf6\x52\x9b\x82\xb6\x84\xe3\x54\xc0\x38\x27\xbe\x91\x19\x5d\x95\x24\x67\x36\x98\x03\xf0\x19\x4b\x2f\xdb\xfb\x0a\xa7\xbd\xfb\xb1\xd5\xac\x10\xe8\x65\x38\xef\x36\x3d\x3a\xa6\x44\xe9\x05\x93\x82\x33\x07\x8f\xe2\x1c\x5e\x56\xca\x1f\xb0\xb1\x23\xf8\x7e\xdb\x39\x7f\x5b\xb9\xb2\x72\x5e\x16\x6c\xf4\x30\xbf\x46\x33\x59\x30\x49\x8e\x2c\xa4\xc7\x63\x90\xbf\x32\xe1\xb6\x43\xfd\xb5\x1d\x0c\xeb\x1c\x0c\xeb\x0c\xed\x53\x5c\x0c\xdb\xe2\x62\x58\x38\xaf\xd4\xf6\xc3\xcd\x76\x75\x19\x2c\x7c\x5b\xb9\x97\x85\xdb\x05\xb2\xd5\xe9\x2e\xb0\xbf\x32\xb1\x13\x4e\x74\x58\xbb\xfb\x2a\xf6\xb9\x7c\x55\xef\x64\xde\xdb\x59\xed\x64\x4a\x1b\xfc\x55\x67\x4e\xeb\xc6\xcd\x56\x3e\x37\x39\xa5\xf5\x0b\xf1\x5f\x2c\x14\x9e\x60\x95\x05\x85\xe6\x98\x03\x29\xb8\x3a\xcf\xb2\xf0\x65\x85\x71\x97\x77\x81\x73\x6c\xf6\x37\x68\x62\x31\x4a\x4a\xc9\x52\xc8\xb5\xe4\x18\x95\xb5\x0e\x85\x12\x83\x59\xb7\x01\x8e\xa6\x5d\x32\xe7\xc0\xa8\x84\x7a\x67\x77\x9e\x65\x08\x36\x46\x0f\x46\x57\xe8\x36\x73\x7d\xb5\xf4\xbe\x68\xe4\xe1\x57\x42\x79\x1c\x23\x8b\xf1\x2f\xb6\x25\x53\x1b\x30\x80\x31\xda\x84\x0d\x49\x74\xfa\xe2\xba\x84\xd4\x61\xf6\xdb\xa8\x5f\x68\x45\xe6\x0d\x1b\x67\xc4\x56\x05\xd1\x86\xb0\x45\x16\x47\x88\x74\x84\xe8\x7e\xe4\x3b\xfc\xec\x64\x9e\x9f\x4f\x6d\xde\x63\xb7\x8a\xf3\x67\x7e\x17\xd5\x35\x80\x77\xd4\x55\x63\xdb\x57\x59\x1e\xdb\x3d\xd5\xd5\xe0\xd8\xa4\x30\x8f\x99\x18\xc8\x2a\xc9\x0c\x81\xeb\xd2\x80\xb5\xfe\x32\xfb\x5f\x51\x54\xed\x66\x5b\x4d\xe9\xca\xcd\x0b\xb7\x8b\xaa\x74\xe5\xba\x03\xf6\x18\x9c\xb8\x9b\x12\xd3\xf1\xaa\x98\x61\x96\x76\x87\xaf\xf6\x52\x68\x39\x13\xcb\xfe\x5a\xde\xfa\x26\x17\xfd\xfb\xb7\x93\xe0\xfb\xdf\xff\xef\xab\x68\x6f\x93\x6b\x77\xb9\xa7\xd5\x75\x68\x36\x7a\x0a\x52\x73\x4e\x8e\x84\x22\x16\x30\x22\xb3\xc7\xc4\x69\xc2\x41\x69\x07\xe4\x8f\x8a\x29\x27\xfe\x23\x54\x46\x5a\x64\x7f\x59\x9b\xdc\xa2\x49\xbc\xb2\x5b\x2d\x5e\xf9\xf6\x97\xd4\x20\xee\x70\x4f\xed\xd5\x28\x3e\x55\x73\x05\xbb\x26\x88\xe1\xcb\xa9\x4b\x4b\xc4\x9c\xd0\x53\x3a\x1d\x85\xdc\x30\x31\xab\x9c\xd3\xaa\xd5\xfa\xcc\x29\x32\x73\x2a\xb0\x85\xff\x2a\x8d\x28\x98\xb9\xf1\xed\x99\xd4\x98\x87\xd4\xea\xad\xd3\x0f\xaf\x5e\x2e\x2c\x66\x16\xbc\x93\x58\x27\xef\x73\xce\xe3\xa8\xde\x61\x1f\xde\xf6\x08\x6d\xd6\xa7\x62\x11\x5a\xef\xca\x58\x3e\x99\xbe\xd7\x95\xf3\x95\x88\xb1\xd4\xcc\xe8\xca\xc1\x67\x4b\xce\x70\x4b\x78\xe4\xbc\x0c\x27\x72\x34\x8e\x7c\x5a\x57\x4c\xaf\xe0\x50\x4a\x82\x6e\x17\x83\x4e\x4b\x72\x30\x6d\x79\x7c\xf5\x83\x2b\x3d\x0f\xe4\x12\x6e\xc6\xd2\x9e\x1a\x08\xad\x63\x0c\xea\xf1\x6a\x47\xe7\x9c\xe3\x6d\x3e\x06\xf2\xa1\xd4\x5a\x8e\x01\xbc\x13\xe9\xa5\x1c\xe3\x6f\x79\x1a\x4f\xc9\x17\xcc\x03\x4d\x97\x07\x7a\x63\xdd\x90\x02\xde\x1d\xc4\x61\x3e\xad\xfd\xdc\x48\x12\x52\x4a\x76\x43\x09\x33\x82\x05\xb9\xe0\x1c\x54\x42\x9d\xa9\x00\xfd\x0b\x2e\xdd\xe8\x66\x7a\xb7\x87\x50\x73\x8d\x15\x4f\x13\x5e\xc2\xcd\xed\x2d\x19\xec\x29\xd9\x0c\x24\xf1\xff\x07\x1c\xe6\xac\x92\x8e\x76\x7e\xd7\x2f\x21\x49\xd2\x70\xd8\x00\x78\xf3\xa2\xc4\xff\xde\x94\xd0\x0c\xf0\x97\x0f\x29\xdb\x62\x32\x71\x39\x73\x44\x69\xa2\x5d\x0e\x86\x78\xa9\x10\xc7\x2e\xc1\xd2\x69\x83\xe0\x53\x68\x47\x6b\xbe\xbd\xdd\x6b\x49\x81\xd6\x08\x66\x6b\x89\x6e\x74\xf1\x0e\x55\xbb\xd1\xf5\x5b\xb2\x72\x5c\x3b\x5a\x1e\x30\xc7\x4f\xc9\x21\x8a\xe1\xf0\x8c\x3c\x59\x8e\xdd\xd2\xee\xb2\x79\x56\x0b\xa8\xce\xab\xb1\x22\x62\x9f\x12\x4c\x9f\xcf\x48\x97\x46\x9b\xe3\xdd\xc9\xef\x30\x9f\xae\xcd\xa4\xbd\xfa\x8f\xbc\x69\x7c\xe1\x82\xdf\xb2\x7a\xd2\x9e\x36\x8e\xa7\xcd\x84\x1c\xac\x13\xaa\xff\x53\xd1\xea\xc7\x9f\xad\x27\x41\xb0\xf5\x7c\xa5\x39\x2c\x0c\x12\x2a\xb2\xdc\x8d\x1d\xb4\x20\xd8\x68\xaf\xcd\x76\x9f\x3e\x3b\xb0\x00\xce\x54\x06\x06\xb5\xcd\x43\xad\xa4\x50\xd0\x19\x40\x3b\x72\x8b\x2a\xe7\x3b\x1b\xf8\x67\xd9\x64\x87\x83\xf0\x59\xf6\xd9\x72\x60\xee\xb5\x07\xab\x2f\x9e\xcf\x8f\x7d\x6d\x15\x6a\x3d\x7e\x34\xbb\xce\xc3\xf2\xd0\xe2\x4d\x47\xc7\x6c\x34\xe7\x9c\x46\xeb\xa8\xdd\xc4\xc5\x0a\x0f\xa4\x65\x82\x7c\x39\x2e\x4a\x7f\x1d\x8f\xb2\xa1\x2f\x03\x2b\x32\xf5\x50\xac\x8c\x3b\xb1\x1f\x3b\x17\x52\xbb\xb2\xa7\x8f\xf7\x13\xc6\xde\x05\xc1\x87\x8a\x1c\xf6\xcb\x3b\x9b\x9c\x73\x90\x65\xfa\xbb\x22\xfc\x3b\xdc\xb4\x69\xe6\xcf\x37\x25\x6c\x48\x33\x97\x35\xc5\x61\xe1\xaa\x0d\x78\xd7\xee\xfa\x80\x4c\xd4\x94\x6f\xe5\x62\x40\xbc\x05\xc5\xcf\xa5\xf4\x11\x74\x8f\xc1\x7e\x42\xed\x33\x11\x2f\x26\x8f\x74\x7a\xb0\x3d\xa1\x5e\x66\x45\x21\x2e\xd9\x9e\x52\xaf\x24\xd3\xab\xcb\x37\xa5\xd3\x7d\xe2\x9f\xfa\xde\x4b\x61\xac\x6b\xfa\x98\xac\x08\x8b\x2f\xac\x5e\x31\x9b\x0b\x95\x3d\xc5\x50\x4f\xf1\xf7\x7a\x26\x14\x16\x55\xaf\x00\xaf\x54\xe0\x8d\xb9\x1d\xec\x95\x69\x3f\xa0\x5e\xdf\xf9\xcb\xb3\xd5\x6c\xdb\xdb\xae\xdb\x06\xf2\x91\xa9\xff\xd0\xde\xca\x2d\x03\xbd\x81\xed\x3c\x74\xc0\x8f\xcc\xc6\xa0\xa8\xdd\x74\xb6\x93\xdf\xae\xda\xbd\xa8\xfd\x80\x2c\x35\x49\x6e\xcb\xd4\xb2\xbb\x87\xef\xeb\x50\xac\x72\xd8\xce\x3c\x24\x8f\x98\x81\x25\x34\xcd\x21\xbd\x9c\xe9\x6b\xba\xd6\x1a\xeb\x58\xa6\xb1\xc4\x41\x60\x33\xe0\xf7\x2f\x46\xf6\xbb\x26\x78\x69\x5c\xc0\x30\x94\xd9\x91\xf0\x75\x13\x0f\x51\xe8\xeb\xbc\xf9\xce\xa5\xbe\x7b\x86\x2b\x6b\xca\x7c\xab\x45\xbe\x81\x5f\xef\x75\x9a\x66\xf7\x38\xac\x79\x36\x18\x2d\x5f\x85\xf9\xd2\x57\x3b\xfc\x43\xfb\xa8\x93\xa4\xda\x00\xf9\x1b\x5b\xb0\x0f\xfe\x81\xa2\x47\x96\xec\xfd\x69\x5f\x24\x22\xee\x77\xe8\x48\x38\x61\x0e\x5f\xa4\x12\x50\x1c\xdf\x73\x62\xb3\x7d\xd6\x48\xac\xf6\x53\x25\xcb\xc0\x12\xa9\x19\x27\x73\x66\x5d\x43\xe2\x86\xe7\x96\xec\x82\x5d\x87\x99\xd6\x99\x04\x56\x0a\xeb\xdf\x5c\xe2\x58\x24\xc5\xcc\x46\x17\xf8\x2c\xf4\x26\x9a\x84\x93\x49\x38\x69\x7a\xdb\x9f\x72\xee\xfe\x10\xf6\x62\xf5\x0d\xee\x10\xef\xa6\x37\xa2\xa9\xe6\x10\x32\xe5\x7f\x69\xbb\xb0\xa1\x36\x59\x34\x09\x4f\xc3\xc9\x49\xd4\x0c\x6e\xa7\x71\x67\x54\x81\x01\xab\x2b\x93\xc2\x76\x9c\x51\x94\x72\x75\x61\xc3\x54\xea\x8a\xcf\x25\x33\xb0\x22\xce\x16\x65\x25\x82\x4e\x12\x27\x28\xdc\x93\xa8\x3f\x16\xb8\x52\xda\x95\xbd\xee\x6c\x86\xaf\x80\x37\x91\x53\xbb\x10\xcc\xed\x22\x95\x05\x0e\x8a\x52\x32\x07\x94\x08\x9e\xd0\xaa\xc4\x3a\xb2\x77\x7f\x6f\x34\x67\x32\xc4\x47\xba\x8d\x9f\xe8\xbf\x55\x2c\x70\x72\xf5\x85\x6a\x9c\x3f\x1b\xce\xfb\x9a\x1a\x9d\xfe\xe2\x91\x62\x40\xe5\x00\xcb\x38\xbe\x81\x59\x0a\x66\xae\xf9\xb3\xc1\x61\x5a\xad\x99\xcf\xef\x51\x2e\xbf\x53\x17\xbf\xcb\x01\x26\x2a\x4b\xfa\x87\x00\xdd\x1e\x3d\x00\x7c\x98\x8a\xd5\x45\x7c\x41\x94\x50\xbc\xc5\xe8\xf4\x5d\x1d\xfc\xc6\x91\xaf\x3b\x4e\x0f\x46\xae\xd3\x9a\x73\x5c\xd0\xba\x67\x8f\x62\xad\x73\x1e\x5e\x9e\xcd\x26\x3b\x85\x07\xc3\xf8\x1a\x91\xfa\x27\x04\x9b\x42\xeb\x95\x80\xba\x07\xbf\x29\x96\xf6\x58\x46\x7f\xc5\xee\x69\x73\x5d\x77\x3f\x31\x63\x9d\x83\x76\x65\xf6\x5d\xc5\x8c\x0b\x5a\x31\x7b\x14\x3b\x88\x79\x72\xfa\x6d\x78\x12\x9e\x84\x93\xb3\xd3\x93\x93\xaf\xc7\x92\x9c\x75\x31\xcb\x1a\xc1\xe3\xc6\xfb\x08\xbe\x86\xdf\x20\xf8\x33\x72\x98\x6b\xeb\xce\xf0\xc1\xfe\xe1\x7e\x42\x1f\x76\x7a\xf2\xaf\xcf\xc1\x5c\xeb\x61\x79\x64\xdd\xa5\x8e\x57\x78\x53\xf3\x6e\x1e\x2a\xfb\xd7\x93\x29\x53\x29\xc8\xa3\x63\x3a\x7d\x2e\xb5\x85\xbb\x77\xf5\x86\x00\xa1\x89\x0c\x56\x22\x80\xf9\xe0\xf2\xef\x6d\xa3\x2f\x71\x8b\xda\x97\xac\xee\xd1\xe3\xae\xbb\xbc\x3b\xdf\x17\x47\x78\xce\xa7\x07\x71\x94\xbb\x42\x4e\x0f\xfe\x3b\x00\xcf\x31\x27\x28\xcc\x33\x00\x00")
 
 func admin_http_assetsIndexHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -125,7 +125,7 @@ func admin_http_assetsIndexHtml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "admin_http_assets/index.html", size: 12604, mode: os.FileMode(420), modTime: time.Unix(1444217349, 0)}
+	info := bindataFileInfo{name: "admin_http_assets/index.html", size: 13260, mode: os.FileMode(420), modTime: time.Unix(1792366506, 0)}
 	a := &asset{bytes: bytes, info:  info}
 	return a, nil
 }
@@ -4,7 +4,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"expvar"
 	"flag"
@@ -22,6 +21,7 @@ import (
 	m20 "github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/metrics20/go-metrics20"
 	logging "github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/op/go-logging"
 	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/rcrowley/goagain"
+	"github.com/graphite-ng/carbon-relay-ng/aggregator"
 	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
 	//"runtime"
 	"strconv"
@@ -51,6 +51,7 @@ func (l *MetricValidationLevel) UnmarshalText(text []byte) error {
 
 type Config struct {
 	Listen_addr              string
+	Ack_listen_addr          string
 	Admin_addr               string
 	Http_addr                string
 	Spool_dir                string
@@ -62,8 +63,21 @@ type Config struct {
 	Log_level                string
 	Instrumentation          instrumentation
 	Bad_metrics_max_age      string
+	Bad_metrics_max_entries  int
 	Pid_file                 string
 	Legacy_metric_validation MetricValidationLevel
+	Readiness                readiness
+	Shutdown_timeout         string
+	Max_conns                int
+	Max_conns_per_ip         int
+	Idle_timeout             string
+	Max_line_length          int
+	Acl                      aclsConfig
+	Admin_auth               adminAuthConfig
+	History                  historyConfig
+	Sanitize                 sanitizeConfig
+	Timestamp_filter         timestampFilterConfig
+	idleTimeout              time.Duration
 }
 
 type instrumentation struct {
@@ -81,6 +95,7 @@ var (
 	cpuprofile  = flag.String("cpuprofile", "", "write cpu profile to file")
 	numIn       metrics.Counter
 	numInvalid  metrics.Counter
+	numTooLong  metrics.Counter
 	badMetrics  *badmetrics.BadMetrics
 )
 
@@ -96,14 +111,31 @@ func init() {
 
 }
 
-func accept(l *net.TCPListener, config Config) {
+func accept(l *net.TCPListener, config Config, acl *acl) {
+	limits := newConnLimits("tcp", config.Max_conns, config.Max_conns_per_ip)
+	setListening("tcp", true)
 	for {
 		c, err := l.AcceptTCP()
 		if nil != err {
 			log.Error(err.Error())
+			setListening("tcp", false)
 			break
 		}
-		go handle(c, config)
+		if !acl.allow(c.RemoteAddr()) {
+			c.Close()
+			continue
+		}
+		if !limits.acquire(c) {
+			log.Info("rejecting conn from %s: too many connections", c.RemoteAddr())
+			c.Close()
+			continue
+		}
+		trackConn(c)
+		go func() {
+			handle(c, config)
+			untrackConn(c)
+			limits.release(c)
+		}()
 	}
 }
 
@@ -111,8 +143,8 @@ var emptyByteStr = []byte("")
 
 func handle(c net.Conn, config Config) {
 	defer c.Close()
-	// TODO c.SetTimeout(60e9)
-	r := bufio.NewReaderSize(c, 4096)
+	r := newLineReader(c, config.Max_line_length)
+	peer := peerName(c.RemoteAddr())
 	for {
 
 		// Note that everything in this loop should proceed as fast as it can
@@ -120,34 +152,110 @@ func handle(c net.Conn, config Config) {
 		// so the validation, the pipeline initiated via table.Dispatch(), etc
 		// must never block.
 
-		// note that we don't support lines longer than 4096B. that seems very reasonable..
-		buf, _, err := r.ReadLine()
+		if config.idleTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(config.idleTimeout))
+		}
+		buf, err := readLine(r, config.Max_line_length)
 
+		if err == errLineTooLong {
+			reportTooLong(buf, peer)
+			continue
+		}
 		if nil != err {
-			if io.EOF != err {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				log.Info("closing conn from %s: idle for %s", c.RemoteAddr(), config.idleTimeout)
+			} else if io.EOF != err {
 				log.Error(err.Error())
 			}
 			break
 		}
 
-		buf_copy := make([]byte, len(buf), len(buf))
-		copy(buf_copy, buf)
-		numIn.Inc(1)
+		handleLine(buf, peer, config)
+	}
+}
 
-		err = m20.ValidatePacket(buf, config.Legacy_metric_validation.Level)
+// handleUDP reads metrics from datagrams of the peers our acl allows
+func handleUDP(c *net.UDPConn, config Config, acl *acl) {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := c.ReadFromUDP(buf)
 		if err != nil {
-			fields := bytes.Fields(buf)
-			if len(fields) != 0 {
-				badMetrics.Add(fields[0], buf, err)
-			} else {
-				badMetrics.Add(emptyByteStr, buf, err)
-			}
-			numInvalid.Inc(1)
+			log.Error(err.Error())
+			return
+		}
+		if !acl.allowPacket(addr) {
 			continue
 		}
+		peer := peerName(addr)
+		for _, line := range bytes.Split(buf[:n], newLine) {
+			line = bytes.TrimSuffix(line, []byte{'\r'})
+			if len(line) > config.Max_line_length {
+				reportTooLong(line[:config.Max_line_length], peer)
+			} else if len(line) != 0 {
+				handleLine(line, peer, config)
+			}
+		}
+	}
+}
 
-		table.Dispatch(buf_copy)
+// badMetricType classifies the error of an invalid line
+func badMetricType(err error) string {
+	if err == errLineTooLong {
+		return badmetrics.ErrTooLong
 	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "illegal char"), strings.Contains(msg, "NULL byte"), strings.Contains(msg, "non-ASCII"):
+		return badmetrics.ErrNameChars
+	case strings.HasPrefix(msg, "metric "), strings.HasPrefix(msg, "bad metric spec"), strings.HasPrefix(msg, "duplicate tag key"):
+		return badmetrics.ErrNameFormat
+	case strings.Contains(msg, "3 fields"):
+		return badmetrics.ErrFieldCount
+	case strings.HasPrefix(msg, "value field"):
+		return badmetrics.ErrValue
+	case strings.HasPrefix(msg, "timestamp field"):
+		return badmetrics.ErrTimestamp
+	}
+	return badmetrics.ErrOther
+}
+
+// reportBad records an invalid line, sent by peer, in the bad metrics
+func reportBad(buf []byte, err error, peer string) {
+	metric := emptyByteStr
+	if fields := bytes.Fields(buf); len(fields) != 0 {
+		metric = fields[0]
+	}
+	badMetrics.Add(metric, buf, err, badMetricType(err), peer)
+}
+
+func reportTooLong(buf []byte, peer string) {
+	numTooLong.Inc(1)
+	reportBad(buf, errLineTooLong, peer)
+}
+
+// handleLine repairs (if enabled, see sanitizeConfig) and validates a metric line sent by peer and dispatches it into the table.
+// buf is copied, so the caller can reuse it.
+func handleLine(buf []byte, peer string, config Config) {
+	buf_copy := make([]byte, len(buf), len(buf))
+	copy(buf_copy, buf)
+	numIn.Inc(1)
+
+	var repairs []string
+	if config.Sanitize.enabled() {
+		buf_copy, repairs = sanitize(buf_copy, config.Sanitize, config.Legacy_metric_validation.Level)
+	}
+
+	err := m20.ValidatePacket(buf_copy, config.Legacy_metric_validation.Level)
+	if err != nil {
+		reportBad(buf, err, peer)
+		numInvalid.Inc(1)
+		return
+	}
+	if len(repairs) != 0 {
+		reportRepaired(buf, repairs, peer, config.Sanitize.Sample)
+	}
+
+	table.DispatchFrom(buf_copy, peer)
 }
 
 func usage() {
@@ -165,6 +273,17 @@ func main() {
 
 	// Default to strict validation
 	config.Legacy_metric_validation.Level = m20.Strict
+	// by default we're ready as soon as a route has an online destination
+	config.Readiness.Require_route_online = true
+	config.Readiness.Max_bad_metrics_fill = 0.9
+	config.Shutdown_timeout = "30s"
+	config.Idle_timeout = "0s"
+	config.Max_line_length = 4096
+	config.Bad_metrics_max_entries = 10000
+	config.History.Max_size = 10 * 1024 * 1024
+	config.History.Keep = 5
+	config.Sanitize.Sample = 100
+	config.Timestamp_filter.Action = "drop"
 
 	config_file = "/etc/carbon-relay-ng.ini"
 	if 1 == flag.NArg() {
@@ -217,6 +336,11 @@ func main() {
 
 	numIn = Counter("unit=Metric.direction=in")
 	numInvalid = Counter("unit=Err.type=invalid")
+	numTooLong = Counter("unit=Err.type=line_too_long")
+	numRepaired = Counter("unit=Metric.direction=repaired")
+	aggregator.HitCounter = func(desc string) metrics.Counter {
+		return hitCounter("aggregator", desc)
+	}
 	if config.Instrumentation.Graphite_addr != "" {
 		addr, err := net.ResolveTCPAddr("tcp", config.Instrumentation.Graphite_addr)
 		if err != nil {
@@ -232,7 +356,48 @@ func main() {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
-	badMetrics = badmetrics.New(maxAge)
+	shutdownTimeout, err := time.ParseDuration(config.Shutdown_timeout)
+	if err != nil {
+		log.Error("could not parse shutdown timeout")
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	config.idleTimeout, err = time.ParseDuration(config.Idle_timeout)
+	if err != nil {
+		log.Error("could not parse idle timeout")
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if config.Max_line_length < 1 {
+		log.Error("max_line_length must be at least 1")
+		os.Exit(1)
+	}
+	acls, err := newListenerACLs(config.Acl)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	goagain.OnSIGHUP = func(l net.Listener) error {
+		return reloadACLs(acls, config_file)
+	}
+	adminAuth, err = newAuthenticator(config.Admin_auth)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	audit, err = openAuditLog(config.Admin_auth.Audit_log)
+	if err != nil {
+		log.Error("could not open audit log")
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	history, err = openHistory(config.History)
+	if err != nil {
+		log.Error("could not open history")
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	badMetrics = badmetrics.New(maxAge, config.Bad_metrics_max_entries)
 	table = NewTable(config.Spool_dir)
 	log.Notice("initializing routing table...")
 	for i, cmd := range config.Init {
@@ -244,6 +409,17 @@ func main() {
 			os.Exit(1)
 		}
 	}
+	tsFilter, err := newTimestampFilter(config.Timestamp_filter)
+	if err != nil {
+		log.Error("invalid timestamp_filter config")
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if tsFilter != nil && tsFilter.action == "route" && table.GetRoute(tsFilter.route) == nil {
+		log.Error("timestamp_filter route '%s' does not exist", tsFilter.route)
+		os.Exit(1)
+	}
+	table.SetTimestampFilter(tsFilter)
 	tablePrinted := table.Print()
 	log.Notice("===========================")
 	log.Notice("========== TABLE ==========")
@@ -267,10 +443,10 @@ func main() {
 			os.Exit(1)
 		}
 		log.Notice("listening on %v/tcp", laddr)
-		go accept(l.(*net.TCPListener), config)
+		go accept(l.(*net.TCPListener), config, acls.tcp)
 	} else {
 		log.Notice("resuming listening on %v/tcp", l.Addr())
-		go accept(l.(*net.TCPListener), config)
+		go accept(l.(*net.TCPListener), config, acls.tcp)
 		if err := goagain.KillParent(ppid); nil != err {
 			log.Error(err.Error())
 			os.Exit(1)
@@ -295,7 +471,22 @@ func main() {
 		os.Exit(1)
 	}
 	log.Notice("listening on %v/udp", udp_addr)
-	go handle(udp_conn, config)
+	setListening("udp", true)
+	trackConn(udp_conn)
+	go func() {
+		handleUDP(udp_conn, config, acls.udp)
+		setListening("udp", false)
+		untrackConn(udp_conn)
+	}()
+
+	var ack_listener net.Listener
+	if config.Ack_listen_addr != "" {
+		ack_listener, err = ackListen(config.Ack_listen_addr, config, acls.ack)
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+	}
 
 	if config.Pid_file != "" {
 		f, err := os.Create(config.Pid_file)
@@ -313,7 +504,7 @@ func main() {
 
 	if config.Admin_addr != "" {
 		go func() {
-			err := adminListener(config.Admin_addr)
+			err := adminListener(config.Admin_addr, acls.admin)
 			if err != nil {
 				fmt.Println("Error listening:", err.Error())
 				os.Exit(1)
@@ -322,11 +513,16 @@ func main() {
 	}
 
 	if config.Http_addr != "" {
-		go HttpListener(config.Http_addr, table)
+		go HttpListener(config.Http_addr, table, acls.http)
 	}
 
 	if err := goagain.AwaitSignals(l); nil != err {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
+	listeners := []io.Closer{l, udp_conn}
+	if ack_listener != nil {
+		listeners = append(listeners, ack_listener)
+	}
+	shutdown(shutdownTimeout, listeners...)
 }
@@ -8,6 +8,7 @@ import (
 	logging "github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/op/go-logging"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -95,6 +96,87 @@ func TestSinglePointSingleRoute(t *testing.T) {
 	// we don't want to mess up the view of the next test
 }
 
+// with a long flush interval, everything would sit in the buffer at shutdown.
+// draining should get it all out.
+func TestDrainBeforeShutdown(t *testing.T) {
+	tE := NewTestEndpoint(t, ":2005")
+	defer tE.Close()
+	na := tE.conditionNumAccepts(1)
+	ns := tE.conditionNumSeen(packets3A.amount)
+	tE.Start()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch test1  127.0.0.1:2005 flush=100000")
+	na.Allow(50 * time.Millisecond)
+	// the endpoint accepting the conn doesn't mean the destination uses it yet, in which case it would drop our metrics
+	for i := 0; !table.Snapshot().Routes[0].Dests[0].Online; i++ {
+		if i == 100 {
+			t.Fatal("destination didn't come online")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for buf := range packets3A.All() {
+		table.Dispatch(buf)
+		time.Sleep(100 * time.Nanosecond)
+	}
+	stats := table.Drain(time.Now().Add(time.Second))
+	if stats.Delivered != int64(packets3A.amount) || stats.Spooled != 0 || stats.Dropped != 0 {
+		t.Fatalf("expected all %d metrics delivered, got %+v", packets3A.amount, stats)
+	}
+	ns.Allow(500 * time.Millisecond)
+	tE.SeenThisOrFatal(packets3A.All())
+	table.ShutdownOrFatal(t)
+	time.Sleep(100 * time.Millisecond)
+}
+
+// a drained destination must not reconnect, not even when told to reconnect often
+func TestDrainStaysDisconnected(t *testing.T) {
+	tE := NewTestEndpoint(t, ":2005")
+	defer tE.Close()
+	tE.Start()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch test1  127.0.0.1:2005 flush=10 reconn=10")
+	defer table.ShutdownOrFatal(t)
+	for i := 0; !table.Snapshot().Routes[0].Dests[0].Online; i++ {
+		if i == 100 {
+			t.Fatal("destination didn't come online")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	table.Drain(time.Now().Add(time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if table.Snapshot().Routes[0].Dests[0].Online {
+		t.Fatal("destination reconnected after it was drained")
+	}
+}
+
+// slowRoute takes its time to dispatch, and reports what it got as delivered when drained
+type slowRoute struct {
+	*RouteSendAllMatch
+	got int64
+}
+
+func (route *slowRoute) Dispatch(buf []byte) {
+	time.Sleep(50 * time.Millisecond)
+	atomic.AddInt64(&route.got, 1)
+}
+
+func (route *slowRoute) Drain(deadline time.Time) DrainStats {
+	return DrainStats{Delivered: atomic.LoadInt64(&route.got)}
+}
+
+func TestDrainAggregates(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addAgg sum ^stats\\.(.*)\\.requests totals.requests 60 120")
+	m, _ := NewMatcher("totals.", "", "", "")
+	r, _ := NewRouteSendAllMatch("slow", *m, nil)
+	table.AddRoute(&slowRoute{RouteSendAllMatch: r.(*RouteSendAllMatch)})
+	ts := time.Now().Unix()
+	table.Dispatch([]byte(fmt.Sprintf("stats.a.requests 1 %d", ts)))
+	table.Dispatch([]byte(fmt.Sprintf("stats.b.requests 2 %d", ts)))
+	// the aggregate must make it to the route before it is drained
+	stats := table.Drain(time.Now().Add(time.Second))
+	if stats.Delivered != 1 {
+		t.Fatalf("expected the aggregate delivered, got %+v", stats)
+	}
+}
+
 func Test3RangesWith2EndpointAndSpoolInMiddle(t *testing.T) {
 	test3RangesWith2EndpointAndSpoolInMiddle(t, 10, 10)
 	time.Sleep(100 * time.Millisecond)
@@ -456,7 +538,7 @@ func BenchmarkTableDispatchMillion(b *testing.B) {
 }
 
 func BenchmarkMatchPrefixMillion(b *testing.B) {
-	matcher, _ := NewMatcher("abcde_fghij.klmnopqrst", "", "")
+	matcher, _ := NewMatcher("abcde_fghij.klmnopqrst", "", "", "")
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < 1000000; j++ {
 			matcher.Match(metric70)
@@ -465,7 +547,7 @@ func BenchmarkMatchPrefixMillion(b *testing.B) {
 }
 
 func BenchmarkMatchSubstrMillion(b *testing.B) {
-	matcher, _ := NewMatcher("", "1234567890abc", "")
+	matcher, _ := NewMatcher("", "1234567890abc", "", "")
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < 1000000; j++ {
 			matcher.Match(metric70)
@@ -474,7 +556,16 @@ func BenchmarkMatchSubstrMillion(b *testing.B) {
 }
 
 func BenchmarkMatchRegexMillion(b *testing.B) {
-	matcher, _ := NewMatcher("", "", "abcde_(fghij|foo).[^\\.]+.\\.*.\\.*")
+	matcher, _ := NewMatcher("", "", "abcde_(fghij|foo).[^\\.]+.\\.*.\\.*", "")
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000000; j++ {
+			matcher.Match(metric70)
+		}
+	}
+}
+
+func BenchmarkMatchGlobMillion(b *testing.B) {
+	matcher, _ := NewMatcher("", "", "", "abcde_{fghij,foo}.*.uv_wxyz.*")
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < 1000000; j++ {
 			matcher.Match(metric70)
@@ -484,7 +575,7 @@ func BenchmarkMatchRegexMillion(b *testing.B) {
 
 // just sending into route, no matching or sending to dest
 func BenchmarkRouteDispatchMillion(b *testing.B) {
-	route, err := NewRouteSendAllMatch("", "", "", "", make([]*Destination, 0))
+	route, err := NewRouteSendAllMatch("", Matcher{}, make([]*Destination, 0))
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -494,3 +585,94 @@ func BenchmarkRouteDispatchMillion(b *testing.B) {
 		}
 	}
 }
+
+// benchRules returns n matchers that don't match metric70: 3 out of 4 prefixes, the rest anchored regexes
+func benchRules(b *testing.B, n int) []*Matcher {
+	rules := make([]*Matcher, n)
+	for i := range rules {
+		var m *Matcher
+		var err error
+		if i%4 == 3 {
+			m, err = NewMatcher("", "", fmt.Sprintf("^rule%d\\.[a-z]+\\.", i), "")
+		} else {
+			m, err = NewMatcher(fmt.Sprintf("rule%d.", i), "", "", "")
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		rules[i] = m
+	}
+	return rules
+}
+
+// compares checking the blacklist one by one, like we used to, with the table's index
+func BenchmarkBlacklistRules(b *testing.B) {
+	for _, n := range []int{10, 100, 1000, 10000} {
+		table := NewTable("")
+		batch := table.Begin()
+		for _, m := range benchRules(b, n) {
+			batch.AddBlacklist(m)
+		}
+		if err := batch.Commit(); err != nil {
+			b.Fatal(err)
+		}
+		conf := table.config.Load().(TableConfig)
+		b.Run(fmt.Sprintf("linear-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, m := range conf.blacklist {
+					if m.Match(metric70) {
+						b.Fatal("metric should not be blacklisted")
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("indexed-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if conf.index.blacklist.first(metric70) >= 0 {
+					b.Fatal("metric should not be blacklisted")
+				}
+			}
+		})
+	}
+}
+
+// compares matching routes one by one, like we used to, with the table's index.
+// the last route matches.
+func BenchmarkRouteRules(b *testing.B) {
+	for _, n := range []int{10, 100, 1000, 10000} {
+		table := NewTable("")
+		batch := table.Begin()
+		for i, m := range append(benchRules(b, n-1), &Matcher{Prefix: "abcde_", prefix: []byte("abcde_")}) {
+			route, err := NewRouteSendAllMatch(fmt.Sprintf("route%d", i), *m, make([]*Destination, 0))
+			if err != nil {
+				b.Fatal(err)
+			}
+			batch.AddRoute(route)
+		}
+		if err := batch.Commit(); err != nil {
+			b.Fatal(err)
+		}
+		conf := table.config.Load().(TableConfig)
+		b.Run(fmt.Sprintf("linear-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				matched := 0
+				for _, route := range conf.routes {
+					if route.Match(metric70) {
+						matched++
+					}
+				}
+				if matched != 1 {
+					b.Fatalf("expected 1 matching route, got %d", matched)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("indexed-%d", n), func(b *testing.B) {
+			var arr [8]int
+			for i := 0; i < b.N; i++ {
+				if matched := conf.index.routes.all(metric70, arr[:0]); len(matched) != 1 {
+					b.Fatalf("expected 1 matching route, got %d", len(matched))
+				}
+			}
+		})
+	}
+}
@@ -1,21 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
 	"fmt"
 	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
 	"io"
 	"net"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
-var bufio_buffer_size = 2000000 // in bytes. 4096 is go default
+var bufio_buffer_size = 2000000 // in bytes. 4096 is go default. default for the writebuf destination option
 
 // krux change from default 30000; we need a large enough buffer for metrics comming in
 // so that the app doesn't hang while processing & sending
 // 200,000 is too low for 1.5M metrics / min; 1,000,000 works; bumping the number up a bit
 // for growth; no negative performance impacts observable 
-var conn_in_buffer = 1500000 // in metrics. (each metric line is typically about 70 bytes)
+var conn_in_buffer = 1500000 // in metrics. (each metric line is typically about 70 bytes). default for the bufsize destination option
 
 var keepsafe_initial_cap = 100000 // not very important
 
@@ -28,7 +31,9 @@ var newLine = []byte{'\n'}
 type Conn struct {
 	conn        *net.TCPConn
 	buffered    *Writer
-	shutdown    chan bool
+	writeBuf    int       // size of buffered
+	shutdown    chan bool // true hands the pending batch over to the window, see stop() for false
+	stopped     chan bool // closed when HandleData returns
 	In          chan []byte
 	dest        *Destination // which dest do we correspond to
 	up          bool
@@ -40,6 +45,12 @@ type Conn struct {
 	periodFlush time.Duration
 	unFlushed   []byte
 	keepSafe    *keepSafe
+	numFlushed  int64      // amount of metrics flushed out. only access atomically
+	numWritten  int64      // amount of metrics written to the buffer. only access atomically
+	numDropped  int64      // amount of metrics the dest dropped because we were too slow. only access atomically
+	window      *ackWindow // if set, we speak the ack protocol, see ack.go
+	batch       [][]byte   // metrics for the next batch (ack protocol only)
+	acked       chan bool  // signals new acks came in (ack protocol only)
 
 	numErrTruncated   metrics.Counter
 	numErrWrite       metrics.Counter
@@ -52,6 +63,7 @@ type Conn struct {
 	manuFlushSize     metrics.Histogram
 	numBuffered       metrics.Gauge
 	numDropBadPickle  metrics.Counter
+	numResend         metrics.Counter
 }
 
 func NewConn(addr string, dest *Destination, periodFlush time.Duration, pickle bool) (*Conn, error) {
@@ -65,11 +77,14 @@ func NewConn(addr string, dest *Destination, periodFlush time.Duration, pickle b
 		return nil, err
 	}
 	cleanAddr := addrToPath(addr)
+	bufSize, writeBuf := dest.GetBufSizes()
 	connObj := &Conn{
 		conn:              conn,
-		buffered:          NewWriter(conn, bufio_buffer_size, cleanAddr),
+		buffered:          NewWriter(conn, writeBuf, cleanAddr),
+		writeBuf:          writeBuf,
 		shutdown:          make(chan bool, 1), // when we write here, HandleData() may not be running anymore to read from the chan
-		In:                make(chan []byte, conn_in_buffer),
+		stopped:           make(chan bool),
+		In:                make(chan []byte, bufSize),
 		dest:              dest,
 		up:                true,
 		pickle:            pickle,
@@ -79,6 +94,8 @@ func NewConn(addr string, dest *Destination, periodFlush time.Duration, pickle b
 		flushErr:          make(chan error),
 		periodFlush:       periodFlush,
 		keepSafe:          NewKeepSafe(keepsafe_initial_cap, keepsafe_keep_duration),
+		window:            dest.window,
+		acked:             make(chan bool, 1),
 		numErrTruncated:   Counter("dest=" + cleanAddr + ".unit=Err.type=truncated"),
 		numErrWrite:       Counter("dest=" + cleanAddr + ".unit=Err.type=write"),
 		numErrFlush:       Counter("dest=" + cleanAddr + ".unit=Err.type=flush"),
@@ -90,15 +107,32 @@ func NewConn(addr string, dest *Destination, periodFlush time.Duration, pickle b
 		manuFlushSize:     Histogram("dest=" + cleanAddr + ".unit=B.what=FlushSize.type=manual"),
 		numBuffered:       Gauge("dest=" + cleanAddr + ".unit=Metric.what=numBuffered"),
 		numDropBadPickle:  Counter("dest=" + cleanAddr + ".unit=Metric.action=drop.reason=bad_pickle"),
+		numResend:         Counter("dest=" + cleanAddr + ".unit=Metric.action=resend"),
 	}
 
-	go connObj.checkEOF()
+	if connObj.window != nil {
+		go connObj.readAcks()
+	} else {
+		go connObj.checkEOF()
+	}
 
 	go connObj.HandleData()
 	go connObj.HandleStatus()
 	return connObj, nil
 }
 
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		Addr:     c.conn.RemoteAddr().String(),
+		Buffered: len(c.In),
+		Written:  atomic.LoadInt64(&c.numWritten),
+		Flushed:  atomic.LoadInt64(&c.numFlushed),
+		Dropped:  atomic.LoadInt64(&c.numDropped),
+		BufSize:  cap(c.In),
+		WriteBuf: c.writeBuf,
+	}
+}
+
 func (c *Conn) isAlive() bool {
 	return <-c.checkUp
 }
@@ -128,6 +162,86 @@ func (c *Conn) checkEOF() {
 	}
 }
 
+// readAcks processes the acks from the receiving relay (ack protocol only).
+// like checkEOF, it closes the conn when the remote end does.
+func (c *Conn) readAcks() {
+	r := bufio.NewReader(c.conn)
+	for {
+		typ, body, err := readFrame(r)
+		if err != nil {
+			if err == io.EOF {
+				log.Notice("conn %s readAcks: EOF -> conn is closed. closing conn explicitly", c.dest.Addr)
+			} else {
+				log.Error("conn %s readAcks: %s. closing conn\n", c.dest.Addr, err)
+			}
+			c.Close()
+			return
+		}
+		if typ != frameAck || len(body) != 8 {
+			log.Error("conn %s readAcks: expected ack frame, got type %d. closing conn\n", c.dest.Addr, typ)
+			c.Close()
+			return
+		}
+		c.window.ack(binary.BigEndian.Uint64(body))
+		select {
+		case c.acked <- true:
+		default:
+		}
+	}
+}
+
+// hello introduces us to the receiving relay, and resends all batches it didn't ack yet (ack protocol only)
+func (c *Conn) hello() error {
+	err := writeFrame(c.buffered, frameHello, []byte(c.window.sender))
+	pending := c.window.pending()
+	if len(pending) > 0 {
+		log.Notice("conn %s resending %d unacknowledged batches\n", c.dest.Addr, len(pending))
+	}
+	for _, b := range pending {
+		if err == nil {
+			err = c.writeBatch(b)
+			c.numResend.Inc(int64(len(b.lines)))
+		}
+	}
+	if err == nil {
+		err = c.buffered.Flush()
+	}
+	return err
+}
+
+func (c *Conn) writeBatch(b ackBatch) error {
+	body := seqBytes(b.seq)
+	for i, line := range b.lines {
+		if i > 0 {
+			body = append(body, '\n')
+		}
+		body = append(body, line...)
+	}
+	return writeFrame(c.buffered, frameBatch, body)
+}
+
+// sendBatch sends the pending metrics as a batch, and flushes (ack protocol only).
+// the batch goes into the window first, so if sending fails, it is resent on the next conn.
+func (c *Conn) sendBatch() error {
+	if len(c.batch) == 0 {
+		return c.buffered.Flush()
+	}
+	b := c.window.add(c.batch)
+	c.batch = nil
+	err := c.writeBatch(b)
+	if err == nil {
+		err = c.buffered.Flush()
+	}
+	return err
+}
+
+func (c *Conn) flushData() error {
+	if c.window != nil {
+		return c.sendBatch()
+	}
+	return c.buffered.Flush()
+}
+
 // all these messages should potentially be resubmitted, because we're not confident about their delivery
 // note: getting this data means resetting it! so handle it wisely.
 // we also read out the In channel until it blocks.  Don't send any more input after calling this.
@@ -147,6 +261,19 @@ func (c *Conn) getRedo() [][]byte {
 	}
 }
 
+// drainIn returns whatever is still in the In buffer. Don't send any more input after calling this.
+func (c *Conn) drainIn() (bufs [][]byte) {
+	for {
+		select {
+		case buf := <-c.In:
+			c.numBuffered.Dec(1)
+			bufs = append(bufs, buf)
+		default:
+			return bufs
+		}
+	}
+}
+
 func (c *Conn) HandleStatus() {
 	for {
 		select {
@@ -162,27 +289,55 @@ func (c *Conn) HandleStatus() {
 }
 
 func (c *Conn) HandleData() {
+	defer close(c.stopped)
 	periodFlush := c.periodFlush
 	tickerFlush := time.NewTicker(periodFlush)
 	var now time.Time
 	var durationActive time.Duration
 	flushSize := int64(0)
+	flushCount := int64(0) // metrics written since the last flush
+
+	if c.window != nil {
+		err := c.hello()
+		if err != nil {
+			log.Warning("conn %s hello error: %s\n", c.dest.Addr, err)
+			c.updateUp <- false
+			go c.Close()
+			return
+		}
+	}
 
 	for {
 		start := time.Now()
 		var active time.Time
 		var action string
+		in := c.In
+		// with the ack protocol, don't take in more data until the receiver catches up
+		if c.window != nil && c.window.full() {
+			in = nil
+		}
 		select { // handle incoming data or flush/shutdown commands
 		// note that Writer.Write() can potentially cause a flush and hence block
 		// choose the size of In based on how long these loop iterations take
-		case buf := <-c.In:
+		case <-c.acked:
+			action = "ack"
+		case buf := <-in:
 			// seems to take about 30 micros when writing log to disk, 10 micros otherwise (100k messages/second)
 			active = time.Now()
 			c.numBuffered.Dec(1)
 			action = "write"
 			log.Info("conn %s HandleData: writing %s\n", c.dest.Addr, buf)
-			c.keepSafe.Add(buf)
-			n, err := c.Write(buf)
+			var n int
+			var err error
+			if c.window != nil {
+				c.batch = append(c.batch, buf)
+				if len(c.batch) >= ack_batch_size {
+					err = c.sendBatch()
+				}
+			} else {
+				c.keepSafe.Add(buf)
+				n, err = c.Write(buf)
+			}
 			if err != nil {
 				log.Warning("conn %s write error: %s\n", c.dest.Addr, err)
 				log.Debug("conn %s setting up=false\n", c.dest.Addr)
@@ -192,6 +347,8 @@ func (c *Conn) HandleData() {
 				return
 			}
 			c.numOut.Inc(1)
+			atomic.AddInt64(&c.numWritten, 1)
+			flushCount += 1
 			flushSize += int64(n)
 			now = time.Now()
 			durationActive = now.Sub(active)
@@ -200,7 +357,7 @@ func (c *Conn) HandleData() {
 			active = time.Now()
 			action = "auto-flush"
 			log.Debug("conn %s HandleData: c.buffered auto-flushing...\n", c.dest.Addr)
-			err := c.buffered.Flush()
+			err := c.flushData()
 			if err != nil {
 				log.Warning("conn %s HandleData c.buffered auto-flush done but with error: %s, closing\n", c.dest.Addr, err)
 				c.numErrFlush.Inc(1)
@@ -209,6 +366,8 @@ func (c *Conn) HandleData() {
 				return
 			}
 			log.Debug("conn %s HandleData c.buffered auto-flush done without error\n", c.dest.Addr)
+			atomic.AddInt64(&c.numFlushed, flushCount)
+			flushCount = 0
 			now = time.Now()
 			durationActive = now.Sub(active)
 			c.durationTickFlush.Update(durationActive)
@@ -218,7 +377,11 @@ func (c *Conn) HandleData() {
 			active = time.Now()
 			action = "manual-flush"
 			log.Debug("conn %s HandleData: c.buffered manual flushing...\n", c.dest.Addr)
-			err := c.buffered.Flush()
+			err := c.flushData()
+			if err == nil {
+				atomic.AddInt64(&c.numFlushed, flushCount)
+				flushCount = 0
+			}
 			c.flushErr <- err
 			if err != nil {
 				log.Warning("conn %s HandleData c.buffered manual flush done but witth error: %s, closing\n", c.dest.Addr, err)
@@ -233,9 +396,13 @@ func (c *Conn) HandleData() {
 			c.durationManuFlush.Update(durationActive)
 			c.manuFlushSize.Update(flushSize)
 			flushSize = 0
-		case <-c.shutdown:
+		case handOver := <-c.shutdown:
 			active = time.Now()
 			log.Debug("conn %s HandleData: shutdown received. returning.\n", c.dest.Addr)
+			if handOver && c.window != nil && len(c.batch) > 0 {
+				// not sent yet, but this way it will be on the next conn
+				c.window.add(c.batch)
+			}
 			return
 		}
 		log.Debug("conn %s HandleData %s %s (total iter %s) (use this to tune your In buffering)\n", c.dest.Addr, action, durationActive, now.Sub(start))
@@ -280,6 +447,22 @@ func (c *Conn) Flush() error {
 	return <-c.flushErr
 }
 
+// stop makes HandleData return, and waits until it has.
+// after that, nothing takes from In anymore, and it's up to the caller to flush with flushData().
+// the conn is still open, so unless it went down in the meantime, Close() it afterwards.
+func (c *Conn) stop() {
+	select {
+	case c.shutdown <- false:
+	case <-c.stopped:
+	}
+	<-c.stopped
+	// if HandleData returned on an error instead, don't leave our request in the way of its Close()
+	select {
+	case <-c.shutdown:
+	default:
+	}
+}
+
 func (c *Conn) Close() error {
 	c.updateUp <- false // redundant in case HandleData() called us, but not if the dest called us
 	log.Debug("conn %s Close() called. sending shutdown\n", c.dest.Addr)
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+func TestDefaultRoute(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch main prefix=a.  127.0.0.1:0 prometheus=true")
+	defer table.ShutdownOrFatal(t)
+	assert.Equal(t, nil, applyCommand(table, "addRoute sendAllMatch archive prefix=a.  127.0.0.1:0 prometheus=true"))
+	written := func(key string) string {
+		return promMetrics(t, table, key)
+	}
+
+	if applyCommand(table, "addDefault nosuch") == nil {
+		t.Fatal("expected an error for a default route that doesn't exist")
+	}
+	assert.Equal(t, nil, applyCommand(table, "addDefault archive"))
+	assert.Equal(t, "archive", table.Snapshot().DefaultRoute)
+	assert.Equal(t, true, strings.Contains(table.Print(), "archive"))
+
+	// the default route only gets what no other route takes, regardless of its matcher
+	unroutable := table.numUnroutable.Count()
+	table.Dispatch([]byte("a.foo 1 1"))
+	table.Dispatch([]byte("b.foo 1 1"))
+	assert.Equal(t, true, strings.Contains(written("main"), "a_foo"))
+	assert.Equal(t, false, strings.Contains(written("archive"), "a_foo"))
+	assert.Equal(t, true, strings.Contains(written("archive"), "b_foo"))
+	assert.Equal(t, unroutable, table.numUnroutable.Count())
+
+	// aggregator output that no route takes goes there too
+	table.DispatchAggregate([]byte("c.foo 1 1"))
+	assert.Equal(t, true, strings.Contains(written("archive"), "c_foo"))
+
+	assert.Equal(t, nil, applyCommand(table, "delDefault"))
+	assert.Equal(t, "", table.Snapshot().DefaultRoute)
+	table.Dispatch([]byte("d.foo 1 1"))
+	assert.Equal(t, false, strings.Contains(written("archive"), "d_foo"))
+	assert.Equal(t, unroutable+1, table.numUnroutable.Count())
+
+	// removing the default route unsets it
+	assert.Equal(t, nil, applyCommand(table, "addDefault archive"))
+	assert.Equal(t, nil, table.DelRoute("archive"))
+	assert.Equal(t, "", table.Snapshot().DefaultRoute)
+	table.Dispatch([]byte("e.foo 1 1"))
+	assert.Equal(t, unroutable+2, table.numUnroutable.Count())
+}
+
+func TestDefaultRouteUndo(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch archive prefix=a.  127.0.0.1:0 prometheus=true")
+	defer table.ShutdownOrFatal(t)
+	openTestHistory(t, historyConfig{})
+	err := history.track(table, "telnet", "", "addDefault archive", func() error {
+		return applyCommand(table, "addDefault archive")
+	})
+	assert.Equal(t, nil, err)
+	entries := history.list(0)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "defaultRoute", entries[0].Changes[0].Part)
+	assert.Equal(t, nil, history.undo(table, "telnet", "", entries[0].ID))
+	assert.Equal(t, "", table.Snapshot().DefaultRoute)
+}
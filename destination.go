@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
@@ -30,52 +36,127 @@ type Destination struct {
 	lockMatcher sync.Mutex
 	Matcher     Matcher `json:"matcher"`
 
-	Addr         string `json:"address"`  // tcp dest
-	Instance     string `json:"instance"` // Optional carbon instance name, useful only with consistent hashing
-	spoolDir     string // where to store spool files (if enabled)
-	Spool        bool   `json:"spool"`        // spool metrics to disk while dest down?
-	Pickle       bool   `json:"pickle"`       // send in pickle format?
-	Online       bool   `json:"online"`       // state of connection online/offline.
-	SlowNow      bool   `json:"slowNow"`      // did we have to drop packets in current loop
-	SlowLastLoop bool   `json:"slowLastLoop"` // "" last loop
-	cleanAddr    string
-	periodFlush  time.Duration
-	periodReConn time.Duration
+	Addr          string `json:"address"`  // tcp dest
+	Instance      string `json:"instance"` // Optional carbon instance name, useful only with consistent hashing
+	spoolDir      string // where to store spool files (if enabled)
+	Spool         bool   `json:"spool"`        // spool metrics to disk while dest down?
+	Pickle        bool   `json:"pickle"`       // send in pickle format?
+	OnFull        string `json:"onFull"`       // what to do when the conn can't keep up: drop, spool or block
+	Ack           bool   `json:"ack"`          // use the acknowledged protocol (dest must be a carbon-relay-ng ack listener)
+	Online        bool   `json:"online"`       // state of connection online/offline. only set in snapshots, see IsOnline
+	SlowNow       bool   `json:"slowNow"`      // did we have to drop packets in current loop
+	SlowLastLoop  bool   `json:"slowLastLoop"` // "" last loop
+	SpoolDepth    int64  `json:"spoolDepth"`   // amount of metrics in the spool. only set in snapshots
+	MultiAddr     bool   `json:"multiAddr"`    // open a conn to every address the hostname resolves to?
+	Connections   int    `json:"connections"`  // amount of conns to open (per address, with MultiAddr)
+	Spread        string `json:"spread"`       // how to spread the data over the conns: hash or roundrobin
+	MaxRate       int64  `json:"maxRate"`      // in metrics/s. 0 for unlimited. only access atomically
+	Weight        int64  `json:"weight"`       // share of the metrics relative to the other dests, useful only with weighted routes. only access atomically
+	online        int32  // 1 while we have a conn to send to. only access atomically
+	cleanAddr     string
+	periodFlush   time.Duration
+	periodReConn  time.Duration
+	periodResolve time.Duration // how often to re-resolve the hostname. 0 to disable
+
+	// set for prometheus dests, which serve the metrics on Addr instead of sending them
+	Prometheus *PrometheusExport `json:"prometheus,omitempty"`
+	prom       *promExporter
+
+	// results of the last resolution of our hostname, if enabled
+	lockResolved sync.Mutex
+	Resolved     []string  `json:"resolved"`   // ip:port
+	ResolvedAt   time.Time `json:"resolvedAt"` // last successful resolution
+
+	// buffer sizes for new conns. can be changed at runtime, which replaces the conns
+	lockBuf  sync.Mutex
+	BufSize  int `json:"bufSize"`  // depth of the In channel of each conn, in metrics
+	WriteBuf int `json:"writeBuf"` // size of the write buffer of each conn, in bytes
+
+	// the conns the relay loop currently sends to, for the snapshot
+	lockConns sync.Mutex
+	conns     []*Conn
+	Conns     []ConnStats `json:"conns"` // only set in snapshots
 
 	// set in/via Run()
-	in           chan []byte // incoming metrics
-	shutdown     chan bool   // signals shutdown internally
-	spool        *Spool      // queue used if spooling enabled
-	connUpdates  chan *Conn  // when the dest changes (possibly nil)
-	inConnUpdate chan bool   // to signal when we start a new conn and when we finish
+	in           chan []byte     // incoming metrics
+	shutdown     chan bool       // signals shutdown internally
+	spool        *Spool          // queue used if spooling enabled
+	window       *ackWindow      // unacknowledged batches, if ack enabled
+	connUpdates  chan connUpdate // new conns (possibly none) after a connect attempt
+	resolves     chan []string   // results of the re-resolution of our hostname
+	inConnUpdate chan bool       // to signal when we start a new conn and when we finish
 	flush        chan bool
 	flushErr     chan error
+	drainReq     chan time.Time  // to request draining before the given deadline
+	drainResp    chan DrainStats // on which the outcome of the drain gets sent
 	tasks        sync.WaitGroup
 
 	numDropNoConnNoSpool metrics.Counter
 	numDropSlowSpool     metrics.Counter
 	numDropSlowConn      metrics.Counter
+	numSpoolSlowConn     metrics.Counter
+	numBlockSlowConn     metrics.Counter
+	numDropRateLimit     metrics.Counter
+	numSpoolRateLimit    metrics.Counter
+	durationBlock        metrics.Timer
+	numErrResolve        metrics.Counter
+}
+
+// ConnStats describes one of the conns of a destination
+type ConnStats struct {
+	Addr     string `json:"address"`  // ip:port we're connected to
+	Buffered int    `json:"buffered"` // metrics waiting in the conn's In channel
+	Written  int64  `json:"written"`  // metrics written to the conn's buffer
+	Flushed  int64  `json:"flushed"`  // metrics flushed out to the network
+	Dropped  int64  `json:"dropped"`  // metrics dropped because the conn was too slow
+	BufSize  int    `json:"bufSize"`  // depth of the conn's In channel
+	WriteBuf int    `json:"writeBuf"` // size of the conn's write buffer
+}
+
+type connUpdate struct {
+	conns     []*Conn
+	requested bool // response to a connect() from the relay loop
+	replace   bool // the new conns replace all existing ones
 }
 
 // NewDestination creates a destination object. Note that it still needs to be told to run via Run().
-func NewDestination(prefix, sub, regex, addr, spoolDir string, spool, pickle bool, periodFlush, periodReConn time.Duration) (*Destination, error) {
-	m, err := NewMatcher(prefix, sub, regex)
-	if err != nil {
-		return nil, err
+// onFull is one of drop, spool or block, see the README.
+func NewDestination(matcher Matcher, addr, spoolDir string, spool, pickle, ack bool, onFull string, periodFlush, periodReConn time.Duration) (*Destination, error) {
+	if ack && pickle {
+		return nil, errors.New("ack and pickle can't be combined")
+	}
+	switch onFull {
+	case "drop", "block":
+	case "spool":
+		if !spool {
+			return nil, errors.New("onfull=spool requires spool=true")
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized onfull value '%s'", onFull)
 	}
 	addr, instance := addrInstanceSplit(addr)
 	cleanAddr := addrToPath(addr)
 	dest := &Destination{
-		Matcher:      *m,
+		Matcher:      matcher,
 		Addr:         addr,
 		Instance:     instance,
 		spoolDir:     spoolDir,
 		Spool:        spool,
 		Pickle:       pickle,
+		OnFull:       onFull,
+		Ack:          ack,
+		Connections:  1,
+		Spread:       "hash",
+		BufSize:      conn_in_buffer,
+		WriteBuf:     bufio_buffer_size,
+		Weight:       1,
 		cleanAddr:    cleanAddr,
 		periodFlush:  periodFlush,
 		periodReConn: periodReConn,
 	}
+	if ack {
+		dest.window = newAckWindow(fmt.Sprintf("%s:%s:%d", instance, addr, ackSession), cleanAddr)
+	}
 	dest.setMetrics()
 	return dest, nil
 }
@@ -84,6 +165,12 @@ func (dest *Destination) setMetrics() {
 	dest.numDropNoConnNoSpool = Counter("dest=" + dest.cleanAddr + ".unit=Metric.action=drop.reason=conn_down_no_spool")
 	dest.numDropSlowSpool = Counter("dest=" + dest.cleanAddr + ".unit=Metric.action=drop.reason=slow_spool")
 	dest.numDropSlowConn = Counter("dest=" + dest.cleanAddr + ".unit=Metric.action=drop.reason=slow_conn")
+	dest.numSpoolSlowConn = Counter("dest=" + dest.cleanAddr + ".unit=Metric.action=spool.reason=slow_conn")
+	dest.numBlockSlowConn = Counter("dest=" + dest.cleanAddr + ".unit=Metric.action=block.reason=slow_conn")
+	dest.numDropRateLimit = Counter("dest=" + dest.cleanAddr + ".unit=Metric.action=drop.reason=rate_limit")
+	dest.numSpoolRateLimit = Counter("dest=" + dest.cleanAddr + ".unit=Metric.action=spool.reason=rate_limit")
+	dest.durationBlock = Timer("dest=" + dest.cleanAddr + ".what=durationBlock")
+	dest.numErrResolve = Counter("dest=" + dest.cleanAddr + ".unit=Err.type=resolve")
 }
 
 func (dest *Destination) Match(s []byte) bool {
@@ -92,41 +179,223 @@ func (dest *Destination) Match(s []byte) bool {
 	return dest.Matcher.Match(s)
 }
 
-// can't be changed yet: pickle, spool, flush, reconn
+// SetResolve enables periodic re-resolution of the hostname, and optionally a conn to every address it resolves to.
+// must be called before Run()
+func (dest *Destination) SetResolve(periodResolve time.Duration, multiAddr bool) error {
+	if multiAddr && dest.Ack {
+		return errors.New("ack can't be combined with multiaddr: batches must go over a single conn")
+	}
+	dest.periodResolve = periodResolve
+	dest.MultiAddr = multiAddr
+	return nil
+}
+
+// SetConnections sets the amount of conns to open, and how to spread the data over them.
+// hash sends all data of a given metric name over the same conn, preserving its order. roundrobin doesn't.
+// must be called before Run()
+func (dest *Destination) SetConnections(connections int, spread string) error {
+	if connections < 1 {
+		return fmt.Errorf("connections must be at least 1, not %d", connections)
+	}
+	if connections > 1 && dest.Ack {
+		return errors.New("ack can't be combined with connections > 1: batches must go over a single conn")
+	}
+	switch spread {
+	case "hash", "roundrobin":
+	default:
+		return fmt.Errorf("unrecognized spread value '%s'", spread)
+	}
+	dest.Connections = connections
+	dest.Spread = spread
+	return nil
+}
+
+// SetBufSizes sets the sizes of the buffers of the conns: the In channel depth in metrics, and the write buffer in bytes.
+// conns that are already open keep their sizes.
+func (dest *Destination) SetBufSizes(bufSize, writeBuf int) error {
+	if bufSize < 1 {
+		return fmt.Errorf("bufsize must be at least 1, not %d", bufSize)
+	}
+	if writeBuf < 1 {
+		return fmt.Errorf("writebuf must be at least 1, not %d", writeBuf)
+	}
+	dest.lockBuf.Lock()
+	dest.BufSize = bufSize
+	dest.WriteBuf = writeBuf
+	dest.lockBuf.Unlock()
+	return nil
+}
+
+// SetMaxRate limits the rate of metrics sent out, in metrics per second. 0 disables the limit.
+// metrics over the limit go to the spool if enabled, or are dropped.
+func (dest *Destination) SetMaxRate(maxRate int64) error {
+	if maxRate < 0 {
+		return fmt.Errorf("maxrate can't be negative")
+	}
+	atomic.StoreInt64(&dest.MaxRate, maxRate)
+	return nil
+}
+
+// maxWeight limits the weights, so that the schedule of weighted routes stays small
+const maxWeight = 1000
+
+func checkWeight(weight int64) error {
+	if weight < 1 || weight > maxWeight {
+		return fmt.Errorf("weight must be between 1 and %d, not %d", maxWeight, weight)
+	}
+	return nil
+}
+
+// SetWeight sets the share of the metrics the dest gets in a weighted route, relative to the other dests.
+// the route only picks the new weight up when its config changes, i.e. via UpdateDestination.
+func (dest *Destination) SetWeight(weight int64) error {
+	if err := checkWeight(weight); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&dest.Weight, weight)
+	return nil
+}
+
+func (dest *Destination) GetWeight() int64 {
+	return atomic.LoadInt64(&dest.Weight)
+}
+
+// IsOnline returns whether the dest has a conn to send to
+func (dest *Destination) IsOnline() bool {
+	return atomic.LoadInt32(&dest.online) == 1
+}
+
+func (dest *Destination) setOnline(online bool) {
+	var i int32
+	if online {
+		i = 1
+	}
+	atomic.StoreInt32(&dest.online, i)
+}
+
+func (dest *Destination) GetBufSizes() (bufSize, writeBuf int) {
+	dest.lockBuf.Lock()
+	defer dest.lockBuf.Unlock()
+	return dest.BufSize, dest.WriteBuf
+}
+
+// can't be changed yet: pickle, spool, ack, onfull, flush, reconn, resolve, multiaddr, connections, spread
+// either all options are applied, or, if any of them is invalid, none.
 func (dest *Destination) Update(opts map[string]string) error {
-	matcher := dest.GetMatcher()
-	prefix := matcher.Prefix
-	sub := matcher.Sub
-	regex := matcher.Regex
-	updateMatcher := false
-	addr := ""
+	update, err := dest.prepareUpdate(opts)
+	if err != nil {
+		return err
+	}
+	if reconnect := update.apply(); reconnect != nil {
+		reconnect()
+	}
+	return nil
+}
+
+// destUpdate holds validated changes to a destination, see prepareUpdate
+type destUpdate struct {
+	dest          *Destination
+	matcher       Matcher
+	updateMatcher bool
+	addr          string
+	bufSize       int
+	writeBuf      int
+	updateBuf     bool
+	maxRate       int64
+	updateMaxRate bool
+	weight        int64
+	updateWeight  bool
+}
+
+// prepareUpdate checks the options for Update (see validateDestOpts), and works out the changes, without applying them.
+func (dest *Destination) prepareUpdate(opts map[string]string) (*destUpdate, error) {
+	if err := validateDestOpts(opts); err != nil {
+		return nil, err
+	}
+	if dest.prom != nil {
+		if err := checkPromDestOpts(opts); err != nil {
+			return nil, err
+		}
+	}
+	u := &destUpdate{dest: dest, matcher: dest.GetMatcher()}
+	u.bufSize, u.writeBuf = dest.GetBufSizes()
 
 	for name, val := range opts {
 		switch name {
 		case "addr":
-			addr = val
-		case "prefix":
-			prefix = val
-			updateMatcher = true
-		case "sub":
-			sub = val
-			updateMatcher = true
-		case "regex":
-			regex = val
-			updateMatcher = true
+			u.addr = val
+		case "bufsize":
+			i, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, err
+			}
+			u.bufSize = i
+			u.updateBuf = true
+		case "writebuf":
+			i, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, err
+			}
+			u.writeBuf = i
+			u.updateBuf = true
+		case "maxrate":
+			i, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			u.maxRate = i
+			u.updateMaxRate = true
+		case "weight":
+			i, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			u.weight = i
+			u.updateWeight = true
 		default:
-			return errors.New("no such option: " + name)
+			if !u.matcher.setOpt(name, val) {
+				return nil, errors.New("no such option: " + name)
+			}
+			u.updateMatcher = true
 		}
 	}
-	if addr != "" {
-		dest.updateConn(addr)
+	if u.updateMatcher {
+		if err := u.matcher.updateInternals(); err != nil {
+			return nil, err
+		}
 	}
-	if updateMatcher {
-		matcher, err := NewMatcher(prefix, sub, regex)
-		if err != nil {
-			return err
+	return u, nil
+}
+
+// apply applies the changes, which can't fail anymore.
+// if they require new conns, it returns the func that makes them. it may take a while, so call it without holding any locks.
+// dests that aren't running yet just take the new settings, they'll use them when they start.
+func (u *destUpdate) apply() (reconnect func()) {
+	dest := u.dest
+	if u.updateBuf {
+		dest.SetBufSizes(u.bufSize, u.writeBuf)
+	}
+	if u.updateMaxRate {
+		dest.SetMaxRate(u.maxRate)
+	}
+	if u.updateWeight {
+		dest.SetWeight(u.weight)
+	}
+	if u.updateMatcher {
+		dest.UpdateMatcher(u.matcher)
+	}
+	if dest.in == nil {
+		if u.addr != "" {
+			addr, instance := addrInstanceSplit(u.addr)
+			dest.setAddr(addr, instance)
 		}
-		dest.UpdateMatcher(*matcher)
+		return nil
+	}
+	if u.addr != "" {
+		return func() { dest.updateConn(u.addr) }
+	}
+	if u.updateBuf {
+		return dest.reopen
 	}
 	return nil
 }
@@ -145,15 +414,37 @@ func (dest *Destination) GetMatcher() Matcher {
 
 // a "basic" static copy of the dest, not actually running
 func (dest *Destination) Snapshot() *Destination {
-	return &Destination{
-		Matcher:   dest.GetMatcher(),
-		Addr:      dest.Addr,
-		spoolDir:  dest.spoolDir,
-		Spool:     dest.Spool,
-		Pickle:    dest.Pickle,
-		Online:    dest.Online,
-		cleanAddr: dest.cleanAddr,
+	snap := &Destination{
+		Matcher:     dest.GetMatcher(),
+		Addr:        dest.Addr,
+		spoolDir:    dest.spoolDir,
+		Spool:       dest.Spool,
+		Pickle:      dest.Pickle,
+		OnFull:      dest.OnFull,
+		Ack:         dest.Ack,
+		MultiAddr:   dest.MultiAddr,
+		Connections: dest.Connections,
+		Spread:      dest.Spread,
+		MaxRate:     atomic.LoadInt64(&dest.MaxRate),
+		Weight:      dest.GetWeight(),
+		Prometheus:  dest.Prometheus,
+		Online:      dest.IsOnline(),
+		cleanAddr:   dest.cleanAddr,
+	}
+	snap.BufSize, snap.WriteBuf = dest.GetBufSizes()
+	dest.lockConns.Lock()
+	for _, conn := range dest.conns {
+		snap.Conns = append(snap.Conns, conn.Stats())
 	}
+	dest.lockConns.Unlock()
+	dest.lockResolved.Lock()
+	snap.Resolved = dest.Resolved
+	snap.ResolvedAt = dest.ResolvedAt
+	dest.lockResolved.Unlock()
+	if dest.spool != nil {
+		snap.SpoolDepth = dest.spool.Depth()
+	}
+	return snap
 }
 
 func (dest *Destination) Run() {
@@ -162,14 +453,28 @@ func (dest *Destination) Run() {
 	}
 	dest.in = make(chan []byte)
 	dest.shutdown = make(chan bool)
-	dest.connUpdates = make(chan *Conn)
+	dest.connUpdates = make(chan connUpdate)
+	dest.resolves = make(chan []string)
 	dest.inConnUpdate = make(chan bool)
 	dest.flush = make(chan bool)
 	dest.flushErr = make(chan error)
+	dest.drainReq = make(chan time.Time)
+	dest.drainResp = make(chan DrainStats)
 	if dest.Spool {
 		dest.spool = NewSpool(dest.cleanAddr, dest.spoolDir) // TODO better naming for spool, because it won't update when addr changes
 	}
 	dest.tasks = sync.WaitGroup{}
+	if dest.spool != nil && dest.window != nil {
+		// what we didn't get acked before the previous process died
+		if lines := dest.window.openJournal("unacked_"+dest.cleanAddr, dest.spoolDir); len(lines) > 0 {
+			log.Notice("dest %v spooling %d unacknowledged metrics of the previous run\n", dest.Addr, len(lines))
+			go dest.ingestSpool(lines)
+		}
+	}
+	if dest.prom != nil {
+		go dest.relayPrometheus()
+		return
+	}
 	go dest.relay()
 }
 
@@ -178,6 +483,18 @@ func (dest *Destination) Flush() error {
 	return <-dest.flushErr
 }
 
+// Drain tries to deliver everything that is still buffered for this destination before the deadline:
+// it waits for the conn to write out its buffer and then flushes it.
+// whatever couldn't be delivered goes to the spool if enabled, or is dropped.
+// the conn is closed afterwards, and not reconnected, so this should only be called right before Shutdown().
+func (dest *Destination) Drain(deadline time.Time) DrainStats {
+	if dest.drainReq == nil {
+		return DrainStats{}
+	}
+	dest.drainReq <- deadline
+	return <-dest.drainResp
+}
+
 func (dest *Destination) Shutdown() error {
 	if dest.shutdown == nil {
 		return errors.New("not running yet")
@@ -187,6 +504,8 @@ func (dest *Destination) Shutdown() error {
 	return nil
 }
 
+// updateConn connects to the given address, i.e. when it was changed via modDest.
+// if the address is different, the new conn replaces all existing ones.
 func (dest *Destination) updateConn(addr string) {
 	log.Debug("dest %v (re)connecting to %v\n", dest.Addr, addr)
 	dest.inConnUpdate <- true
@@ -198,17 +517,88 @@ func (dest *Destination) updateConn(addr string) {
 		return
 	}
 	log.Debug("dest %v connected to %v\n", dest.Addr, addr)
-	if addr != dest.Addr {
+	replace := addr != dest.Addr
+	if replace {
 		log.Notice("dest %v update address to %v)\n", dest.Addr, addr)
-		dest.Addr = addr
-		dest.Instance = instance
-		dest.cleanAddr = addrToPath(addr)
-		dest.setMetrics()
+		dest.setAddr(addr, instance)
 	}
-	dest.connUpdates <- conn
+	dest.connUpdates <- connUpdate{conns: []*Conn{conn}, replace: replace}
 	return
 }
 
+func (dest *Destination) setAddr(addr, instance string) {
+	dest.Addr = addr
+	dest.Instance = instance
+	dest.cleanAddr = addrToPath(addr)
+	dest.setMetrics()
+	dest.lockResolved.Lock()
+	dest.Resolved = nil
+	dest.lockResolved.Unlock()
+}
+
+// reopen replaces all conns with new ones, i.e. when the buffer sizes were changed via modDest.
+// what's still buffered in the old conns moves over to the new ones.
+func (dest *Destination) reopen() {
+	dest.inConnUpdate <- true
+	defer func() { dest.inConnUpdate <- false }()
+	addrs := repeat(dest.Addr, dest.Connections)
+	if dest.MultiAddr {
+		addrs = nil
+		dest.lockResolved.Lock()
+		for _, addr := range dest.Resolved {
+			addrs = append(addrs, repeat(addr, dest.Connections)...)
+		}
+		dest.lockResolved.Unlock()
+	}
+	conns := dest.dial(addrs)
+	if len(conns) == 0 {
+		// keep the conns we have. the new sizes will apply when they get replaced
+		return
+	}
+	dest.connUpdates <- connUpdate{conns: conns, replace: true}
+}
+
+// connect opens a conn to each of the given addresses, and hands the ones that succeeded to the relay loop
+func (dest *Destination) connect(addrs []string) {
+	dest.connUpdates <- connUpdate{conns: dest.dial(addrs), requested: true}
+}
+
+func (dest *Destination) dial(addrs []string) []*Conn {
+	var conns []*Conn
+	for _, addr := range addrs {
+		log.Debug("dest %v (re)connecting to %v\n", dest.Addr, addr)
+		conn, err := NewConn(addr, dest, dest.periodFlush, dest.Pickle)
+		if err != nil {
+			log.Debug("dest %v: %v\n", dest.Addr, err.Error())
+			continue
+		}
+		log.Debug("dest %v connected to %v\n", dest.Addr, addr)
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// resolve looks up the addresses of our hostname and hands them to the relay loop, sorted. nil means the lookup failed
+func (dest *Destination) resolve() {
+	var ips []string
+	host, port, err := net.SplitHostPort(dest.Addr)
+	if err == nil {
+		ips, err = net.LookupHost(host)
+	}
+	if err != nil {
+		log.Warning("dest %v could not resolve: %s\n", dest.Addr, err)
+		dest.numErrResolve.Inc(1)
+		dest.resolves <- nil
+		return
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	sort.Strings(addrs)
+	dest.resolves <- addrs
+}
+
 func (dest *Destination) collectRedo(conn *Conn) {
 	dest.tasks.Add(1)
 	bulkData := conn.getRedo()
@@ -216,62 +606,323 @@ func (dest *Destination) collectRedo(conn *Conn) {
 	dest.tasks.Done()
 }
 
+func (dest *Destination) ingestSpool(bulkData [][]byte) {
+	dest.tasks.Add(1)
+	dest.spool.Ingest(bulkData)
+	dest.tasks.Done()
+}
+
+func repeat(s string, n int) []string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = s
+	}
+	return list
+}
+
+// hashName hashes the metric name, i.e. everything up to the first space
+func hashName(buf []byte) uint32 {
+	if i := bytes.IndexByte(buf, ' '); i != -1 {
+		buf = buf[:i]
+	}
+	h := fnv.New32a()
+	h.Write(buf)
+	return h.Sum32()
+}
+
+// pickSlot returns the conn of the slot for the given hash. if that slot has no conn,
+// its share goes to the next slot that has one, so the series of the other slots don't move.
+// returns nil if none of the slots have a conn.
+func pickSlot(slots []*Conn, hash uint32) *Conn {
+	for i := 0; i < len(slots); i++ {
+		if conn := slots[(int(hash%uint32(len(slots)))+i)%len(slots)]; conn != nil {
+			return conn
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
 // TODO func (l *TCPListener) SetDeadline(t time.Time)
 // TODO Decide when to drop this buffer and move on.
 func (dest *Destination) relay() {
 	ticker := time.NewTicker(dest.periodReConn)
+	var resolveTick <-chan time.Time
+	if dest.periodResolve > 0 {
+		resolveTicker := time.NewTicker(dest.periodResolve)
+		defer resolveTicker.Stop()
+		resolveTick = resolveTicker.C
+	}
 	var toUnspool chan []byte
-	var conn *Conn
+	var bucket tokenBucket        // for the maxrate limit
+	var rateWait <-chan time.Time // when the limit allows to unspool again
+	var conns []*Conn
+	next := 0 // to round robin over the conns
+	// with spread=hash, a metric name maps to one of the configured conns (connections per address),
+	// rather than to one of the conns we happen to have, so that a conn going down only moves its own series.
+	// a slot keeps its conn for as long as it is alive, and is nil while it has none.
+	var slots []*Conn
 
-	// try to send the data on the buffered tcp conn
-	// if that's slow or down, discard the data
-	nonBlockingSend := func(buf []byte) {
+	// try to send the data to the spool
+	// if slow or down, drop and move on
+	nonBlockingSpool := func(buf []byte) {
+		select {
+		case dest.spool.InRT <- buf:
+			log.Info("dest %s %s nonBlockingSpool -> added to spool\n", dest.Addr, buf)
+		default:
+			log.Info("dest %s %s nonBlockingSpool -> dropping due to slow spool\n", dest.Addr, buf)
+			dest.numDropSlowSpool.Inc(1)
+		}
+	}
+
+	// with onfull=block, data the conn couldn't take right away waits here, in order, until it can.
+	// while anything waits, we don't take in more data, so the backpressure reaches the route,
+	// the table and all ingest, but the loop keeps handling drains, shutdowns and conn updates.
+	// we only give up on the data if its conn goes down, in which case we handle it like we do
+	// for any data while the conn is down.
+	type blockedSend struct {
+		conn *Conn
+		buf  []byte
+	}
+	var blocked []blockedSend
+	var blockedSince time.Time
+	blockingSend := func(conn *Conn, buf []byte) {
+		if len(blocked) == 0 {
+			blockedSince = time.Now()
+		}
+		blocked = append(blocked, blockedSend{conn, buf})
+	}
+	unblock := func() {
+		blocked = blocked[1:]
+		if len(blocked) == 0 {
+			blocked = nil
+			dest.durationBlock.Update(time.Since(blockedSince))
+		}
+	}
+	// takeBlocked gives up on all the blocked data, and returns it
+	takeBlocked := func() (bufs [][]byte) {
+		for len(blocked) > 0 {
+			bufs = append(bufs, blocked[0].buf)
+			unblock()
+		}
+		return bufs
+	}
+
+	// try to send the data on one of the buffered tcp conns
+	// if that's slow, drop, spool or block, as configured
+	send := func(buf []byte) {
+		var conn *Conn
+		if dest.Spread == "hash" {
+			conn = pickSlot(slots, hashName(buf))
+			if conn == nil {
+				// conns we couldn't give a slot, while the resolved addresses change
+				conn = conns[hashName(buf)%uint32(len(conns))]
+			}
+		} else {
+			conn = conns[next%len(conns)]
+			next++
+		}
 		select {
 		// this op won't succeed as long as the conn is busy processing/flushing
 		case conn.In <- buf:
 			conn.numBuffered.Inc(1)
+			return
+		default:
+		}
+		dest.SlowNow = true
+		switch dest.OnFull {
+		case "spool":
+			log.Info("dest %s %s send -> spooling due to slow conn\n", dest.Addr, buf)
+			dest.numSpoolSlowConn.Inc(1)
+			nonBlockingSpool(buf)
+		case "block":
+			log.Info("dest %s %s send -> blocking due to slow conn\n", dest.Addr, buf)
+			dest.numBlockSlowConn.Inc(1)
+			blockingSend(conn, buf)
 		default:
-			log.Info("dest %s %s nonBlockingSend -> dropping due to slow conn\n", dest.Addr, buf)
+			log.Info("dest %s %s send -> dropping due to slow conn\n", dest.Addr, buf)
 			// TODO check if it was because conn closed
 			// we don't want to just buffer everything in memory,
 			// it would probably keep piling up until OOM.  let's just drop the traffic.
 			dest.numDropSlowConn.Inc(1)
-			dest.SlowNow = true
+			atomic.AddInt64(&conn.numDropped, 1)
 		}
 	}
 
-	// try to send the data to the spool
-	// if slow or down, drop and move on
-	nonBlockingSpool := func(buf []byte) {
-		select {
-		case dest.spool.InRT <- buf:
-			log.Info("dest %s %s nonBlockingSpool -> added to spool\n", dest.Addr, buf)
-		default:
-			log.Info("dest %s %s nonBlockingSpool -> dropping due to slow spool\n", dest.Addr, buf)
-			dest.numDropSlowSpool.Inc(1)
+	// whether the maxrate limit, if any, allows sending another metric. if so, that's accounted for
+	allow := func() bool {
+		rate := atomic.LoadInt64(&dest.MaxRate)
+		return rate <= 0 || bucket.take(rate, time.Now())
+	}
+
+	// gracefully close a healthy conn we don't want anymore.
+	// returns whatever it still had buffered
+	retire := func(conn *Conn) [][]byte {
+		log.Notice("dest %v closing conn to %v\n", dest.Addr, conn.conn.RemoteAddr())
+		conn.Flush()
+		conn.Close()
+		return conn.drainIn()
+	}
+
+	// data we have no conn for goes to the spool, or is dropped
+	discard := func(bufs [][]byte) {
+		if len(bufs) == 0 {
+			return
+		}
+		if dest.Spool {
+			go dest.ingestSpool(bufs)
+		} else {
+			dest.numDropNoConnNoSpool.Inc(int64(len(bufs)))
+		}
+	}
+
+	numConnUpdates := 0 // connects in progress via updateConn
+	connecting := false // connect in progress via connect
+	resolving := false
+	drained := false // once drained, we stay disconnected
+
+	// connect to what we should be connected to, unless we're already at it
+	reconnect := func() {
+		if drained || numConnUpdates != 0 || connecting {
+			return
+		}
+		if !dest.MultiAddr {
+			if len(conns) < dest.Connections {
+				connecting = true
+				go dest.connect(repeat(dest.Addr, dest.Connections-len(conns)))
+			}
+			return
+		}
+		dest.lockResolved.Lock()
+		resolved := dest.Resolved
+		dest.lockResolved.Unlock()
+		if resolved == nil {
+			if !resolving {
+				resolving = true
+				go dest.resolve()
+			}
+			return
+		}
+		have := make(map[string]int)
+		for _, conn := range conns {
+			have[conn.conn.RemoteAddr().String()] += 1
+		}
+		var missing []string
+		for _, addr := range resolved {
+			if have[addr] < dest.Connections {
+				missing = append(missing, repeat(addr, dest.Connections-have[addr])...)
+			}
+		}
+		if len(missing) > 0 {
+			connecting = true
+			go dest.connect(missing)
+		}
+	}
+
+	// give the conns that don't have a slot yet the first empty one for their address
+	fillSlots := func() {
+		addrs := []string{""}
+		if dest.MultiAddr {
+			dest.lockResolved.Lock()
+			addrs = dest.Resolved
+			dest.lockResolved.Unlock()
+		}
+		addrOf := func(conn *Conn) string {
+			if !dest.MultiAddr {
+				return ""
+			}
+			return conn.conn.RemoteAddr().String()
+		}
+		have := make(map[*Conn]bool)
+		for _, conn := range conns {
+			have[conn] = true
+		}
+		filled := make([]*Conn, len(addrs)*dest.Connections)
+		for i, conn := range slots {
+			if i < len(filled) && conn != nil && have[conn] && addrOf(conn) == addrs[i/dest.Connections] {
+				filled[i] = conn
+				delete(have, conn)
+			}
+		}
+		for _, conn := range conns {
+			if !have[conn] {
+				continue
+			}
+			for i := range filled {
+				if filled[i] == nil && addrOf(conn) == addrs[i/dest.Connections] {
+					filled[i] = conn
+					break
+				}
+			}
 		}
+		slots = filled
 	}
 
-	numConnUpdates := 0
-	go dest.updateConn(dest.Addr)
+	// let the snapshot know which conns we have
+	publish := func() {
+		fillSlots()
+		dest.lockConns.Lock()
+		dest.conns = append([]*Conn(nil), conns...)
+		dest.lockConns.Unlock()
+	}
+
+	reconnect()
 
 	// this loop/select should never block, we can't hang dest.In or the route & table locks up
+	// (with onfull=block we stop reading dest.In while we wait, but the loop itself keeps going)
 	for {
-		if conn != nil {
-			if !conn.isAlive() {
-				if dest.Spool {
-					go dest.collectRedo(conn)
-				}
-				conn = nil
+		alive := conns[:0]
+		for _, conn := range conns {
+			if conn.isAlive() {
+				alive = append(alive, conn)
+			} else if dest.Spool {
+				go dest.collectRedo(conn)
 			}
 		}
+		if len(alive) != len(conns) {
+			conns = alive
+			publish()
+		}
+		for len(blocked) > 0 && !blocked[0].conn.isAlive() {
+			if dest.Spool {
+				nonBlockingSpool(blocked[0].buf)
+			} else {
+				dest.numDropNoConnNoSpool.Inc(1)
+			}
+			unblock()
+		}
+		dest.setOnline(len(conns) > 0)
 		// only process spool queue if we have an outbound connection and we haven't needed to drop packets in a while
-		if conn != nil && dest.Spool && !dest.SlowLastLoop && !dest.SlowNow {
+		if len(conns) > 0 && dest.Spool && !dest.SlowLastLoop && !dest.SlowNow {
 			toUnspool = dest.spool.Out
 		} else {
 			toUnspool = nil
 		}
-		log.Debug("dest %v entering select. conn: %v spooling: %v slowLastloop: %v, slowNow: %v spoolQueue: %v", dest.Addr, conn != nil, dest.Spool, dest.SlowLastLoop, dest.SlowNow, toUnspool != nil)
+		rateWait = nil
+		if rate := atomic.LoadInt64(&dest.MaxRate); rate > 0 && toUnspool != nil {
+			if wait := bucket.wait(rate, time.Now()); wait > 0 {
+				toUnspool = nil
+				rateWait = time.After(wait)
+			}
+		}
+		in := dest.in
+		var blockedIn chan []byte
+		var blockedBuf []byte
+		var blockedCheck <-chan time.Time // to notice when the conn we're blocked on goes down
+		if len(blocked) > 0 {
+			in, toUnspool, rateWait = nil, nil, nil
+			blockedIn, blockedBuf = blocked[0].conn.In, blocked[0].buf
+			blockedCheck = time.After(100 * time.Millisecond)
+		}
+		log.Debug("dest %v entering select. conns: %d spooling: %v slowLastloop: %v, slowNow: %v spoolQueue: %v", dest.Addr, len(conns), dest.Spool, dest.SlowLastLoop, dest.SlowNow, toUnspool != nil)
 		select {
 		case inConnUpdate := <-dest.inConnUpdate:
 			if inConnUpdate {
@@ -279,45 +930,137 @@ func (dest *Destination) relay() {
 			} else {
 				numConnUpdates -= 1
 			}
-		// note: new conn can be nil and that's ok (it means we had to [re]connect but couldn't)
-		case conn = <-dest.connUpdates:
-			dest.Online = conn != nil
-			log.Notice("dest %s updating conn. online: %v\n", dest.Addr, dest.Online)
-			if dest.Online {
+		case update := <-dest.connUpdates:
+			if update.requested {
+				connecting = false
+			}
+			if drained {
+				// from a connect that was under way when we drained
+				for _, conn := range update.conns {
+					discard(retire(conn))
+				}
+				break
+			}
+			var leftover [][]byte
+			if update.replace {
+				for _, conn := range conns {
+					leftover = append(leftover, retire(conn)...)
+				}
+				leftover = append(leftover, takeBlocked()...)
+				conns = nil
+			}
+			conns = append(conns, update.conns...)
+			publish()
+			if len(conns) > 0 {
+				for _, buf := range leftover {
+					send(buf)
+				}
+			} else {
+				discard(leftover)
+			}
+			log.Notice("dest %s updating conns. online: %v (%d conns)\n", dest.Addr, len(conns) > 0, len(conns))
+			if len(update.conns) > 0 {
 				// new conn? start with a clean slate!
 				dest.SlowLastLoop = false
 				dest.SlowNow = false
 			}
-		case <-ticker.C: // periodically try to bring connection (back) up, if we have to, and no other connect is happening
-			if conn == nil && numConnUpdates == 0 {
-				go dest.updateConn(dest.Addr)
+		case addrs := <-dest.resolves:
+			resolving = false
+			if addrs == nil {
+				// keep the previous results
+				break
+			}
+			dest.lockResolved.Lock()
+			if strings.Join(addrs, " ") != strings.Join(dest.Resolved, " ") {
+				log.Notice("dest %v resolved to %v\n", dest.Addr, addrs)
+			}
+			dest.Resolved = addrs
+			dest.ResolvedAt = time.Now()
+			dest.lockResolved.Unlock()
+			keep := conns[:0]
+			for _, conn := range conns {
+				if contains(addrs, conn.conn.RemoteAddr().String()) {
+					keep = append(keep, conn)
+				} else {
+					discard(retire(conn))
+				}
+			}
+			conns = keep
+			publish()
+			reconnect()
+		case <-resolveTick:
+			if !resolving {
+				resolving = true
+				go dest.resolve()
 			}
+		case <-ticker.C: // periodically try to bring connections (back) up, if we have to, and no other connect is happening
+			reconnect()
 			dest.SlowLastLoop = dest.SlowNow
 			dest.SlowNow = false
 		case <-dest.flush:
-			if conn != nil {
-				dest.flushErr <- conn.Flush()
-			} else {
-				dest.flushErr <- nil
+			var err error
+			for _, conn := range conns {
+				if e := conn.Flush(); e != nil && err == nil {
+					err = e
+				}
 			}
+			dest.flushErr <- err
+		case deadline := <-dest.drainReq:
+			log.Notice("dest %v draining\n", dest.Addr)
+			dest.drainResp <- dest.drain(conns, takeBlocked(), deadline)
+			drained = true
+			conns = nil
+			publish()
+			dest.setOnline(false)
 		case <-dest.shutdown:
-			log.Notice("dest %v shutting down. flushing and closing conn\n", dest.Addr)
-			if conn != nil {
+			log.Notice("dest %v shutting down. flushing and closing conns\n", dest.Addr)
+			for _, conn := range conns {
 				conn.Flush()
 				conn.Close()
 			}
+			if bufs := takeBlocked(); dest.spool != nil {
+				for _, buf := range bufs {
+					dest.spool.InBulk <- buf
+				}
+			} else {
+				dest.numDropNoConnNoSpool.Inc(int64(len(bufs)))
+			}
+			if dest.window != nil && dest.spool != nil {
+				// not acked, so we can't be sure they made it. they'll be resent from the spool next time
+				for _, buf := range dest.window.takeAll() {
+					dest.spool.InBulk <- buf
+				}
+				dest.window.closeJournal()
+			}
 			if dest.spool != nil {
 				dest.spool.Close()
 			}
 			return
+		case <-rateWait:
+		case blockedIn <- blockedBuf:
+			blocked[0].conn.numBuffered.Inc(1)
+			unblock()
+		case <-blockedCheck:
+			// the top of the loop gives up on the data if the conn went down
 		case buf := <-toUnspool:
-			// we know that conn != nil here because toUnspool is set above
-			log.Info("dest %v %s received from spool -> nonBlockingSend\n", dest.Addr, buf)
-			nonBlockingSend(buf)
-		case buf := <-dest.in:
-			if conn != nil {
-				log.Info("dest %v %s received from In -> nonBlockingSend\n", dest.Addr, buf)
-				nonBlockingSend(buf)
+			// we know that we have conns here because toUnspool is set above
+			// and that the rate limit, if any, allows it
+			log.Info("dest %v %s received from spool -> send\n", dest.Addr, buf)
+			allow()
+			send(buf)
+		case buf := <-in:
+			if len(conns) > 0 && !allow() {
+				if dest.Spool {
+					log.Info("dest %v %s received from In -> nonBlockingSpool due to rate limit\n", dest.Addr, buf)
+					dest.numSpoolRateLimit.Inc(1)
+					nonBlockingSpool(buf)
+				} else {
+					log.Info("dest %v %s received from In -> dropping due to rate limit\n", dest.Addr, buf)
+					dest.numDropRateLimit.Inc(1)
+				}
+			} else if len(conns) > 0 {
+				log.Info("dest %v %s received from In -> send\n", dest.Addr, buf)
+				send(buf)
 			} else if dest.Spool {
 				log.Info("dest %v %s received from In -> nonBlockingSpool\n", dest.Addr, buf)
 				nonBlockingSpool(buf)
@@ -328,3 +1071,103 @@ func (dest *Destination) relay() {
 		}
 	}
 }
+
+// drain is run from within the relay loop, see Drain().
+// note that we don't read from the spool anymore: what's in there stays there until the next start.
+// blocked is the data we were blocked on (onfull=block), it goes out first if the conns take it in time.
+func (dest *Destination) drain(conns []*Conn, blocked [][]byte, deadline time.Time) (stats DrainStats) {
+	for _, conn := range conns {
+		for len(blocked) > 0 && conn.isAlive() && time.Now().Before(deadline) {
+			select {
+			case conn.In <- blocked[0]:
+				conn.numBuffered.Inc(1)
+				blocked = blocked[1:]
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	leftover := blocked
+	for _, conn := range conns {
+		delivered, left := dest.drainConn(conn, deadline)
+		stats.Delivered += delivered
+		leftover = append(leftover, left...)
+	}
+	if dest.window != nil {
+		leftover = append(leftover, dest.window.takeAll()...)
+	}
+	for done := false; !done; {
+		select {
+		case buf := <-dest.in:
+			leftover = append(leftover, buf)
+		default:
+			done = true
+		}
+	}
+	if dest.spool != nil {
+		// there is no more realtime traffic, so no need to throttle like Ingest() does
+		for _, buf := range leftover {
+			dest.spool.InBulk <- buf
+		}
+		stats.Spooled = int64(len(leftover))
+	} else {
+		stats.Dropped = int64(len(leftover))
+	}
+	log.Notice("dest %v drained: delivered %d, spooled %d, dropped %d\n", dest.Addr, stats.Delivered, stats.Spooled, stats.Dropped)
+	return stats
+}
+
+// drainConn waits for the conn to write out its buffer, flushes and closes it.
+// returns how many metrics were delivered, and what wasn't.
+func (dest *Destination) drainConn(conn *Conn, deadline time.Time) (delivered int64, leftover [][]byte) {
+	if !conn.isAlive() {
+		return 0, conn.getRedo()
+	}
+	flushedBefore := atomic.LoadInt64(&conn.numFlushed)
+	var ackedBefore int64
+	if dest.window != nil {
+		ackedBefore = dest.window.acked()
+	}
+	for time.Now().Before(deadline) {
+		select {
+		// late arrivals, such as the final aggregator outputs
+		case buf := <-dest.in:
+			select {
+			case conn.In <- buf:
+				conn.numBuffered.Inc(1)
+			default:
+				leftover = append(leftover, buf)
+			}
+			continue
+		default:
+		}
+		if len(conn.In) == 0 || !conn.isAlive() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// stop HandleData before we flush, otherwise what it takes from In after the flush would be lost
+	conn.stop()
+	if !conn.isAlive() {
+		// we don't know what made it, so resubmit the In buffer and the recent writes
+		log.Warning("dest %v conn failed while draining\n", dest.Addr)
+		return 0, append(leftover, conn.getRedo()...)
+	}
+	if err := conn.flushData(); err != nil {
+		log.Warning("dest %v conn failed while draining: %s\n", dest.Addr, err)
+		conn.Close()
+		return 0, append(leftover, conn.getRedo()...)
+	}
+	// everything written is flushed now
+	atomic.StoreInt64(&conn.numFlushed, atomic.LoadInt64(&conn.numWritten))
+	delivered = atomic.LoadInt64(&conn.numFlushed) - flushedBefore
+	if dest.window != nil {
+		// only what's acked counts as delivered
+		for len(dest.window.pending()) > 0 && conn.isAlive() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		delivered = dest.window.acked() - ackedBefore
+	}
+	conn.Close()
+	// whatever HandleData didn't get to before the deadline
+	return delivered, append(leftover, conn.drainIn()...)
+}
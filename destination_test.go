@@ -0,0 +1,310 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+func TestNewDestinationOnFull(t *testing.T) {
+	cases := []struct {
+		spool  bool
+		onFull string
+		ok     bool
+	}{
+		{false, "drop", true},
+		{false, "block", true},
+		{true, "spool", true},
+		{false, "spool", false},
+		{true, "wait", false},
+	}
+	for _, c := range cases {
+		_, err := NewDestination(Matcher{}, "127.0.0.1:2005", "", c.spool, false, false, c.onFull, time.Second, time.Second)
+		if (err == nil) != c.ok {
+			t.Fatalf("spool=%t onfull=%s: expected ok=%t, got error %v", c.spool, c.onFull, c.ok, err)
+		}
+	}
+}
+
+func TestMultiAddr(t *testing.T) {
+	tE := NewTestEndpoint(t, ":2005")
+	defer tE.Close()
+	ns := tE.conditionNumSeen(packets3A.amount)
+	tE.Start()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch multi  localhost:2005 flush=10 multiaddr=true resolve=50")
+	dest := table.GetRoute("multi").Snapshot().Dests[0]
+	var resolved []string
+	for i := 0; i < 100 && len(resolved) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		resolved = table.Snapshot().Routes[0].Dests[0].Resolved
+	}
+	if !contains(resolved, "127.0.0.1:2005") {
+		t.Fatalf("expected localhost to resolve to 127.0.0.1:2005, got %v", resolved)
+	}
+	if !dest.MultiAddr {
+		t.Fatal("expected multiaddr to be set")
+	}
+	time.Sleep(50 * time.Millisecond) // let the conns come up
+	for buf := range packets3A.All() {
+		table.Dispatch(buf)
+		time.Sleep(100 * time.Nanosecond)
+	}
+	ns.Allow(time.Second)
+	tE.SeenThisOrFatal(packets3A.All())
+	table.ShutdownOrFatal(t)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestMultipleConnections(t *testing.T) {
+	tE := NewTestEndpoint(t, ":2005")
+	defer tE.Close()
+	na := tE.conditionNumAccepts(4)
+	ns := tE.conditionNumSeen(packets3A.amount)
+	tE.Start()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch conns  127.0.0.1:2005 flush=10 connections=4")
+	na.Allow(time.Second)
+	for i := 0; i < 100 && len(table.Snapshot().Routes[0].Dests[0].Conns) < 4; i++ {
+		time.Sleep(10 * time.Millisecond) // let the relay pick up all conns
+	}
+	for buf := range packets3A.All() {
+		table.Dispatch(buf)
+		time.Sleep(100 * time.Nanosecond)
+	}
+	ns.Allow(time.Second)
+	tE.SeenThisOrFatal(packets3A.All())
+	dest := table.Snapshot().Routes[0].Dests[0]
+	assert.Equal(t, 4, len(dest.Conns))
+	var written int64
+	for _, conn := range dest.Conns {
+		written += conn.Written
+	}
+	assert.Equal(t, int64(packets3A.amount), written)
+	table.ShutdownOrFatal(t)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestSetConnections(t *testing.T) {
+	dest, err := NewDestination(Matcher{}, "localhost:2005", "", false, false, true, "drop", time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dest.SetConnections(1, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if dest.SetConnections(2, "hash") == nil {
+		t.Fatal("expected an error for connections=2 with ack")
+	}
+	if dest.SetConnections(0, "hash") == nil {
+		t.Fatal("expected an error for connections=0")
+	}
+	if dest.SetConnections(1, "random") == nil {
+		t.Fatal("expected an error for spread=random")
+	}
+}
+
+func TestPickSlot(t *testing.T) {
+	conns := []*Conn{{}, {}, {}}
+	slots := append([]*Conn(nil), conns...)
+	// which of the conns we got, by position
+	picked := func(hash uint32) int {
+		conn := pickSlot(slots, hash)
+		for i := range conns {
+			if conns[i] == conn {
+				return i
+			}
+		}
+		return -1
+	}
+	assert.Equal(t, 1, picked(4))
+	// only the share of the slot without a conn moves, to the next slot
+	slots[1] = nil
+	assert.Equal(t, 0, picked(3))
+	assert.Equal(t, 2, picked(4))
+	assert.Equal(t, 2, picked(5))
+	slots[2] = nil
+	assert.Equal(t, 0, picked(5))
+	slots[0] = nil
+	assert.Equal(t, -1, picked(5))
+}
+
+func TestModDestBufSizes(t *testing.T) {
+	tE := NewTestEndpoint(t, ":2005")
+	defer tE.Close()
+	na := tE.conditionNumAccepts(2)
+	ns := tE.conditionNumSeen(packets3A.amount)
+	tE.Start()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch bufs  127.0.0.1:2005 flush=10 connections=2 bufsize=100 writebuf=4096")
+	na.Allow(time.Second)
+	dest := table.Snapshot().Routes[0].Dests[0]
+	assert.Equal(t, 100, dest.BufSize)
+	assert.Equal(t, 4096, dest.WriteBuf)
+
+	err := applyCommand(table, "modDest bufs 0 bufsize=200 writebuf=8192")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []ConnStats
+	for i := 0; i < 100; i++ {
+		dest = table.Snapshot().Routes[0].Dests[0]
+		conns = dest.Conns
+		if len(conns) == 2 && conns[0].BufSize == 200 && conns[1].BufSize == 200 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 200, dest.BufSize)
+	assert.Equal(t, 8192, dest.WriteBuf)
+	assert.Equal(t, 2, len(conns))
+	for _, conn := range conns {
+		assert.Equal(t, 200, conn.BufSize)
+		assert.Equal(t, 8192, conn.WriteBuf)
+	}
+	if !strings.Contains(table.Print(), " 8192") {
+		t.Fatalf("expected the new writebuf in the table printout:\n%s", table.Print())
+	}
+
+	for buf := range packets3A.All() {
+		table.Dispatch(buf)
+		time.Sleep(100 * time.Nanosecond)
+	}
+	ns.Allow(time.Second)
+	tE.SeenThisOrFatal(packets3A.All())
+	table.ShutdownOrFatal(t)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if !b.take(10, now) {
+			t.Fatalf("expected a full bucket to allow a burst of 10, denied at %d", i)
+		}
+	}
+	if b.take(10, now) {
+		t.Fatal("expected an empty bucket to deny")
+	}
+	assert.Equal(t, 100*time.Millisecond, b.wait(10, now))
+	now = now.Add(250 * time.Millisecond)
+	assert.Equal(t, true, b.take(10, now))
+	assert.Equal(t, true, b.take(10, now))
+	assert.Equal(t, false, b.take(10, now))
+}
+
+func TestMaxRate(t *testing.T) {
+	tE := NewTestEndpoint(t, ":2005")
+	defer tE.Close()
+	na := tE.conditionNumAccepts(1)
+	tE.Start()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch rate  127.0.0.1:2005 flush=10 maxrate=100")
+	na.Allow(time.Second)
+	dest := table.GetRoute("rate").Snapshot().Dests[0]
+	assert.Equal(t, int64(100), dest.MaxRate)
+	for i := 0; i < 100 && len(dest.Conns) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		dest = table.GetRoute("rate").Snapshot().Dests[0]
+	}
+
+	dropped := Counter("dest=127_0_0_1_2005.unit=Metric.action=drop.reason=rate_limit")
+	before := dropped.Count()
+	for buf := range packets3A.All() {
+		table.Dispatch(buf)
+	}
+	time.Sleep(50 * time.Millisecond)
+	numDropped := dropped.Count() - before
+	if numDropped < int64(packets3A.amount)/2 {
+		t.Fatalf("expected most of the %d metrics to be dropped by maxrate=100, only %d were", packets3A.amount, numDropped)
+	}
+
+	// lift the limit
+	err := applyCommand(table, "modDest rate 0 maxrate=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := tE.conditionNumSeen(packets3A.amount - int(numDropped) + packets3B.amount)
+	for buf := range packets3B.All() {
+		table.Dispatch(buf)
+	}
+	ns.Allow(time.Second)
+	assert.Equal(t, numDropped, dropped.Count()-before)
+	table.ShutdownOrFatal(t)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestBlockedDestKeepsRelaying(t *testing.T) {
+	// an endpoint that doesn't read anything until we tell it to
+	l, err := net.Listen("tcp", "127.0.0.1:2007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	resume := make(chan bool)
+	go func() {
+		var conns []net.Conn
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, c)
+		}
+		<-resume
+		for _, c := range conns {
+			go io.Copy(ioutil.Discard, c)
+		}
+	}()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch block  127.0.0.1:2007 onfull=block bufsize=1 writebuf=16")
+	dest := table.GetRoute("block").(*RouteSendAllMatch).dests()[0]
+	for i := 0; !dest.IsOnline(); i++ {
+		if i == 100 {
+			t.Fatal("destination didn't come online")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	blocked := Counter("dest=127_0_0_1_2007.unit=Metric.action=block.reason=slow_conn")
+	before := blocked.Count()
+	stop := make(chan bool)
+	fed := make(chan bool)
+	go func() {
+		buf := []byte("some.metric." + strings.Repeat("a", 8192) + " 1 1")
+		for {
+			select {
+			case dest.in <- buf:
+			case <-stop:
+				fed <- true
+				return
+			}
+		}
+	}()
+	for i := 0; blocked.Count() == before; i++ {
+		if i == 500 {
+			t.Fatal("destination didn't block")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond) // let the endpoint's socket fill up
+
+	// while blocked, the relay loop still handles conn updates
+	updated := make(chan error)
+	go func() {
+		updated <- dest.Update(map[string]string{"addr": "127.0.0.1:2008"}) // nothing listens there
+	}()
+	select {
+	case err := <-updated:
+		assert.Equal(t, nil, err)
+	case <-time.After(time.Second):
+		t.Fatal("modDest hung on the blocked destination")
+	}
+
+	close(stop)
+	<-fed
+	close(resume)
+	l.Close()
+	table.ShutdownOrFatal(t)
+}
@@ -0,0 +1,328 @@
+// Package glob implements graphite style glob patterns for metric names, like servers.*.cpu.{user,system} or app[0-9].requests
+//
+// patterns are matched node by node: a pattern matches a metric name with the same amount of nodes,
+// where each node of the name matches the corresponding node of the pattern.
+// within a node, a star matches any amount of characters, ? matches one character, [abc] or [a-z] one character in the set
+// ([!abc] one character not in the set) and {foo,bar} one of the alternatives, which are literal strings.
+// wildcards never match a dot.
+package glob
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type kind int
+
+const (
+	segLiteral kind = iota
+	segStar
+	segSingle
+	segClass
+	segAlt
+)
+
+type segment struct {
+	kind    kind
+	literal []byte
+	class   [256]bool // for class: which bytes match
+	alts    [][]byte
+}
+
+// node is a compiled node of the pattern
+type node struct {
+	segs []segment
+	// fast paths
+	literal []byte // the node is a literal
+	all     bool   // the node is a single *
+}
+
+type Glob struct {
+	pattern  string
+	nodes    []node
+	prefix   []byte
+	captures int
+}
+
+// Compile parses a glob pattern
+func Compile(pattern string) (*Glob, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty glob pattern")
+	}
+	g := &Glob{pattern: pattern}
+	static := true
+	for i, part := range splitNodes(pattern) {
+		n, err := compileNode(part)
+		if err != nil {
+			return nil, fmt.Errorf("glob '%s': %s", pattern, err)
+		}
+		g.nodes = append(g.nodes, n)
+		for _, seg := range n.segs {
+			if seg.kind != segLiteral {
+				g.captures++
+			}
+		}
+		if !static {
+			continue
+		}
+		if i > 0 {
+			g.prefix = append(g.prefix, '.')
+		}
+		for _, seg := range n.segs {
+			if seg.kind != segLiteral {
+				static = false
+				break
+			}
+			g.prefix = append(g.prefix, seg.literal...)
+		}
+	}
+	return g, nil
+}
+
+// splitNodes splits the pattern on the dots outside of braces, so that a dot inside an alternative gives an error
+func splitNodes(pattern string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, pattern[start:])
+}
+
+func compileNode(part string) (node, error) {
+	var n node
+	var lit []byte
+	flush := func() {
+		if len(lit) > 0 {
+			n.segs = append(n.segs, segment{kind: segLiteral, literal: lit})
+			lit = nil
+		}
+	}
+	for i := 0; i < len(part); i++ {
+		ch := part[i]
+		switch ch {
+		case '*':
+			flush()
+			// consecutive stars are the same as one
+			if len(n.segs) == 0 || n.segs[len(n.segs)-1].kind != segStar {
+				n.segs = append(n.segs, segment{kind: segStar})
+			}
+		case '?':
+			flush()
+			n.segs = append(n.segs, segment{kind: segSingle})
+		case '[':
+			flush()
+			end := indexFrom(part, i+1, ']')
+			if end < 0 {
+				return n, fmt.Errorf("unterminated [")
+			}
+			seg, err := compileClass(part[i+1 : end])
+			if err != nil {
+				return n, err
+			}
+			n.segs = append(n.segs, seg)
+			i = end
+		case '{':
+			flush()
+			end := indexFrom(part, i+1, '}')
+			if end < 0 {
+				return n, fmt.Errorf("unterminated {")
+			}
+			seg := segment{kind: segAlt}
+			for _, a := range bytes.Split([]byte(part[i+1:end]), []byte{','}) {
+				if bytes.ContainsAny(a, "*?[{.") {
+					return n, fmt.Errorf("alternatives in {} can't contain wildcards or dots")
+				}
+				seg.alts = append(seg.alts, a)
+			}
+			n.segs = append(n.segs, seg)
+			i = end
+		case ']', '}':
+			return n, fmt.Errorf("unexpected %c", ch)
+		default:
+			lit = append(lit, ch)
+		}
+	}
+	flush()
+	if len(n.segs) == 0 {
+		return n, fmt.Errorf("empty node")
+	}
+	if len(n.segs) == 1 {
+		switch n.segs[0].kind {
+		case segLiteral:
+			n.literal = n.segs[0].literal
+		case segStar:
+			n.all = true
+		}
+	}
+	return n, nil
+}
+
+func indexFrom(s string, from int, ch byte) int {
+	for i := from; i < len(s); i++ {
+		if s[i] == ch {
+			return i
+		}
+	}
+	return -1
+}
+
+func compileClass(spec string) (segment, error) {
+	seg := segment{kind: segClass}
+	negate := false
+	if len(spec) > 0 && (spec[0] == '!' || spec[0] == '^') {
+		negate = true
+		spec = spec[1:]
+	}
+	if len(spec) == 0 {
+		return seg, fmt.Errorf("empty []")
+	}
+	for i := 0; i < len(spec); i++ {
+		lo, hi := spec[i], spec[i]
+		if i+2 < len(spec) && spec[i+1] == '-' {
+			hi = spec[i+2]
+			i += 2
+		}
+		if lo > hi {
+			return seg, fmt.Errorf("invalid range %c-%c", lo, hi)
+		}
+		for c := int(lo); c <= int(hi); c++ {
+			seg.class[c] = true
+		}
+	}
+	if negate {
+		for c := range seg.class {
+			seg.class[c] = !seg.class[c]
+		}
+	}
+	seg.class['.'] = false
+	return seg, nil
+}
+
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+// Prefix returns the static prefix of the pattern: every name the pattern matches starts with it
+func (g *Glob) Prefix() []byte {
+	return g.prefix
+}
+
+// Match returns whether the metric name matches the pattern
+func (g *Glob) Match(name []byte) bool {
+	return g.match(name, nil)
+}
+
+// Captures returns what each of the wildcards of the pattern matched, from left to right, if the name matches.
+func (g *Glob) Captures(name []byte) ([][]byte, bool) {
+	caps := make([][]byte, g.captures)
+	if !g.match(name, caps) {
+		return nil, false
+	}
+	return caps, true
+}
+
+func (g *Glob) match(name []byte, caps [][]byte) bool {
+	for i := range g.nodes {
+		n := &g.nodes[i]
+		var part []byte
+		if i == len(g.nodes)-1 {
+			if bytes.IndexByte(name, '.') >= 0 {
+				return false
+			}
+			part = name
+		} else {
+			dot := bytes.IndexByte(name, '.')
+			if dot < 0 {
+				return false
+			}
+			part, name = name[:dot], name[dot+1:]
+		}
+		switch {
+		case n.literal != nil:
+			if !bytes.Equal(part, n.literal) {
+				return false
+			}
+		case n.all && caps == nil:
+		default:
+			var nodeCaps [][]byte
+			if caps != nil {
+				nodeCaps = caps
+				caps = caps[n.wildcards():]
+			}
+			if !matchSegs(n.segs, part, nodeCaps) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (n *node) wildcards() int {
+	count := 0
+	for _, seg := range n.segs {
+		if seg.kind != segLiteral {
+			count++
+		}
+	}
+	return count
+}
+
+// matchSegs matches the segments of a node against s, recording what the wildcards matched in caps, if not nil
+func matchSegs(segs []segment, s []byte, caps [][]byte) bool {
+	if len(segs) == 0 {
+		return len(s) == 0
+	}
+	seg := &segs[0]
+	if seg.kind == segLiteral {
+		return bytes.HasPrefix(s, seg.literal) && matchSegs(segs[1:], s[len(seg.literal):], caps)
+	}
+	var rest [][]byte
+	if caps != nil {
+		rest = caps[1:]
+	}
+	capture := func(matched []byte) bool {
+		if caps != nil {
+			caps[0] = matched
+		}
+		return true
+	}
+	switch seg.kind {
+	case segStar:
+		// the last segment takes all the rest
+		if len(segs) == 1 {
+			return capture(s)
+		}
+		for i := 0; i <= len(s); i++ {
+			if matchSegs(segs[1:], s[i:], rest) {
+				return capture(s[:i])
+			}
+		}
+	case segSingle:
+		if len(s) > 0 && matchSegs(segs[1:], s[1:], rest) {
+			return capture(s[:1])
+		}
+	case segClass:
+		if len(s) > 0 && seg.class[s[0]] && matchSegs(segs[1:], s[1:], rest) {
+			return capture(s[:1])
+		}
+	case segAlt:
+		for _, a := range seg.alts {
+			if bytes.HasPrefix(s, a) && matchSegs(segs[1:], s[len(a):], rest) {
+				return capture(s[:len(a)])
+			}
+		}
+	}
+	return false
+}
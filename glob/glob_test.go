@@ -0,0 +1,82 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"servers.web1.cpu", "servers.web1.cpu", true},
+		{"servers.web1.cpu", "servers.web1.cpux", false},
+		{"servers.*.cpu", "servers.web1.cpu", true},
+		{"servers.*.cpu", "servers.web1.eu.cpu", false},
+		{"servers.*", "servers.web1.cpu", false},
+		{"servers.*.*", "servers.web1.cpu", true},
+		{"servers.web*.cpu", "servers.web12.cpu", true},
+		{"servers.web*.cpu", "servers.db1.cpu", false},
+		{"servers.*1*.cpu", "servers.web12.cpu", true},
+		{"servers.web?.cpu", "servers.web1.cpu", true},
+		{"servers.web?.cpu", "servers.web12.cpu", false},
+		{"app[0-9].requests", "app3.requests", true},
+		{"app[0-9].requests", "appx.requests", false},
+		{"app[!0-9].requests", "appx.requests", true},
+		{"app[abc].requests", "appb.requests", true},
+		{"servers.*.cpu.{user,system}", "servers.web1.cpu.system", true},
+		{"servers.*.cpu.{user,system}", "servers.web1.cpu.idle", false},
+		{"servers.*.cpu.{user,system}", "servers.web1.cpu.users", false},
+		{"a.{b,bc}d", "a.bcd", true},
+		{"a.*b*c", "a.xbyyc", true},
+		{"a.*b*c", "a.xbyyd", false},
+	}
+	for _, c := range cases {
+		g, err := Compile(c.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.match, g.Match([]byte(c.name)), c.pattern+" "+c.name)
+	}
+}
+
+func TestGlobCompile(t *testing.T) {
+	for _, pattern := range []string{"", "a..b", "a.[b", "a.{b,c", "a.b]", "a.{b,c*}", "a.{b.c}", "a.[]", "a.[z-a]"} {
+		if _, err := Compile(pattern); err == nil {
+			t.Fatalf("expected an error for '%s'", pattern)
+		}
+	}
+	prefixes := map[string]string{
+		"servers.web1.cpu":           "servers.web1.cpu",
+		"servers.*.cpu":              "servers.",
+		"app[0-9].requests":          "app",
+		"servers.web*.{user,system}": "servers.web",
+		"{a,b}.c":                    "",
+	}
+	for pattern, prefix := range prefixes {
+		g, err := Compile(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, prefix, string(g.Prefix()), pattern)
+	}
+}
+
+func TestGlobCaptures(t *testing.T) {
+	g, err := Compile("servers.*.cpu[0-9].{user,system}.x*y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps, ok := g.Captures([]byte("servers.web1.cpu3.system.xaay"))
+	assert.Equal(t, true, ok)
+	var got []string
+	for _, c := range caps {
+		got = append(got, string(c))
+	}
+	assert.Equal(t, []string{"web1", "3", "system", "aa"}, got)
+	_, ok = g.Captures([]byte("servers.web1.cpu3.idle.xaay"))
+	assert.Equal(t, false, ok)
+}
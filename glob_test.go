@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/aggregator"
+)
+
+func TestGlobMatcher(t *testing.T) {
+	m, err := NewMatcher("servers.", "", "", "servers.*.cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, true, m.Match([]byte("servers.web1.cpu 1 1")))
+	assert.Equal(t, false, m.Match([]byte("servers.web1.mem 1 1")))
+	if _, err := NewMatcher("", "", "", "a.[b"); err == nil {
+		t.Fatal("expected an error for an invalid glob")
+	}
+
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch globs glob=servers.*.cpu  127.0.0.1:2099 glob=*.web?.*")
+	defer table.ShutdownOrFatal(t)
+	snap := table.Snapshot()
+	assert.Equal(t, "servers.*.cpu", snap.Routes[0].Matcher.Glob)
+	assert.Equal(t, "*.web?.*", snap.Routes[0].Dests[0].Matcher.Glob)
+	assert.Equal(t, nil, applyCommand(table, "modRoute globs glob=servers.*.mem"))
+	assert.Equal(t, "servers.*.mem", table.Snapshot().Routes[0].Matcher.Glob)
+	if applyCommand(table, "modRoute globs glob=a.[b") == nil {
+		t.Fatal("expected an error for an invalid glob")
+	}
+	assert.Equal(t, nil, applyCommand(table, "addBlack glob servers.*.secret"))
+	assert.Equal(t, "servers.*.secret", table.Snapshot().Blacklist[0].Glob)
+	assert.Equal(t, nil, applyCommand(table, "addAgg sum glob=servers.*.requests servers.all.requests 60 10"))
+	assert.Equal(t, "servers.*.requests", table.Snapshot().Aggregators[0].Glob)
+}
+
+func TestGlobAggregator(t *testing.T) {
+	out := make(chan []byte, 10)
+	agg, err := aggregator.NewGlob("sum", "servers.*.requests.{get,post}", "totals.$2.requests", 60, 120, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agg.Shutdown()
+	assert.Equal(t, true, agg.PreMatch([]byte("servers.")))
+	ts := time.Now().Unix() / 60 * 60
+	for _, line := range []string{
+		fmt.Sprintf("servers.web1.requests.get 1 %d", ts),
+		fmt.Sprintf("servers.web2.requests.get 2 %d", ts),
+		fmt.Sprintf("servers.web2.requests.post 5 %d", ts),
+		fmt.Sprintf("servers.web2.requests.put 7 %d", ts),
+	} {
+		agg.In <- bytes.Fields([]byte(line))
+	}
+	agg.FlushAll()
+	close(out)
+	var got []string
+	for buf := range out {
+		got = append(got, string(buf))
+	}
+	sort.Strings(got)
+	assert.Equal(t, []string{
+		fmt.Sprintf("totals.get.requests 3.000000 %d", ts),
+		fmt.Sprintf("totals.post.requests 5.000000 %d", ts),
+	}, got)
+
+	if _, err := aggregator.NewGlob("sum", "a.[b", "x", 60, 0, out); err == nil {
+		t.Fatal("expected an error for an invalid glob")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// conditions under which /ready reports that we're not ready to receive traffic
+type readiness struct {
+	Require_route_online bool     // at least one route must have an online destination
+	Required_routes      []string // each of these routes must have at least one online destination
+	Max_spool_depth      int64    // max amount of metrics in the spool of any destination. 0 disables the check
+	Max_bad_metrics_fill float64  // max fill ratio (0-1) of the bad metrics input channel. 0 disables the check
+}
+
+// listenerStates tracks whether our ingest listeners are up, for /health
+var listenerStates = struct {
+	sync.Mutex
+	up map[string]bool
+}{up: make(map[string]bool)}
+
+func setListening(name string, up bool) {
+	listenerStates.Lock()
+	listenerStates.up[name] = up
+	listenerStates.Unlock()
+}
+
+func getListening() (states map[string]bool, allUp bool) {
+	listenerStates.Lock()
+	defer listenerStates.Unlock()
+	states = make(map[string]bool, len(listenerStates.up))
+	allUp = true
+	for name, up := range listenerStates.up {
+		states[name] = up
+		allUp = allUp && up
+	}
+	return states, allUp
+}
+
+func routeOnline(route RouteSnapshot) bool {
+	for _, dest := range route.Dests {
+		if dest.Online {
+			return true
+		}
+	}
+	return false
+}
+
+// readinessProblems checks the table (and bad metrics tracker, if set) against the given conditions
+// and returns a description of every condition that failed.
+func readinessProblems(t TableSnapshot, b BadMetricsSaturation, r readiness) []string {
+	problems := make([]string, 0)
+	if r.Require_route_online {
+		online := false
+		for _, route := range t.Routes {
+			online = online || routeOnline(route)
+		}
+		if !online {
+			problems = append(problems, "no route has an online destination")
+		}
+	}
+	for _, key := range r.Required_routes {
+		found := false
+		for _, route := range t.Routes {
+			if route.Key == key {
+				found = true
+				if !routeOnline(route) {
+					problems = append(problems, fmt.Sprintf("all destinations of route '%s' are offline", key))
+				}
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("route '%s' does not exist", key))
+		}
+	}
+	if r.Max_spool_depth > 0 {
+		for _, route := range t.Routes {
+			for _, dest := range route.Dests {
+				if dest.SpoolDepth > r.Max_spool_depth {
+					problems = append(problems, fmt.Sprintf("spool of route '%s' dest %s holds %d metrics (max %d)", route.Key, dest.Addr, dest.SpoolDepth, r.Max_spool_depth))
+				}
+			}
+		}
+	}
+	if r.Max_bad_metrics_fill > 0 && b != nil {
+		if fill := b.Saturation(); fill > r.Max_bad_metrics_fill {
+			problems = append(problems, fmt.Sprintf("bad metrics channel is %.0f%% full (max %.0f%%)", fill*100, r.Max_bad_metrics_fill*100))
+		}
+	}
+	return problems
+}
+
+// BadMetricsSaturation is implemented by *badmetrics.BadMetrics
+type BadMetricsSaturation interface {
+	Saturation() float64
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error marshalling JSON:'%s'", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(bytes)
+}
+
+// healthHandler reports whether the process is alive and all ingest listeners are accepting
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	states, allUp := getListening()
+	code := http.StatusOK
+	status := "ok"
+	if !allUp {
+		code = http.StatusServiceUnavailable
+		status = "listener down"
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "listeners": states})
+}
+
+// readyHandler reports whether we're in a state to accept traffic, as configured in the readiness section
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	var b BadMetricsSaturation
+	if badMetrics != nil {
+		b = badMetrics
+	}
+	problems := readinessProblems(table.Snapshot(), b, config.Readiness)
+	code := http.StatusOK
+	if len(problems) > 0 {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"ready": len(problems) == 0, "problems": problems})
+}
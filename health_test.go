@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+type fakeSaturation float64
+
+func (f fakeSaturation) Saturation() float64 {
+	return float64(f)
+}
+
+func TestReadinessProblems(t *testing.T) {
+	snap := TableSnapshot{
+		Routes: []RouteSnapshot{
+			{Key: "up", Dests: []*Destination{{Addr: "a:2003", Online: false}, {Addr: "b:2003", Online: true, SpoolDepth: 50}}},
+			{Key: "down", Dests: []*Destination{{Addr: "c:2003", Online: false, SpoolDepth: 200}}},
+		},
+	}
+	r := readiness{Require_route_online: true}
+	assert.Equal(t, []string{}, readinessProblems(snap, nil, r))
+	assert.Equal(t, []string{"no route has an online destination"}, readinessProblems(TableSnapshot{}, nil, r))
+
+	r = readiness{
+		Required_routes:      []string{"up", "down", "missing"},
+		Max_spool_depth:      100,
+		Max_bad_metrics_fill: 0.5,
+	}
+	expected := []string{
+		"all destinations of route 'down' are offline",
+		"route 'missing' does not exist",
+		"spool of route 'down' dest c:2003 holds 200 metrics (max 100)",
+		"bad metrics channel is 90% full (max 50%)",
+	}
+	assert.Equal(t, expected, readinessProblems(snap, fakeSaturation(0.9), r))
+	assert.Equal(t, expected[:3], readinessProblems(snap, fakeSaturation(0.1), r))
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/aggregator"
+)
+
+type historyConfig struct {
+	File     string // file to append all changes to the routing table to. rotated when it reaches max_size
+	Max_size int64  // in bytes
+	Keep     int    // amount of rotated files to keep, as file.1 (newest) to file.<keep>
+}
+
+// amount of history entries we keep in memory, for viewing and undoing
+var history_max_entries = 1000
+
+// historyChange is the before/after state of a part of the table: "blacklist", "whitelist", "aggregators" or "routes/<key>".
+// Before is null when the part was added, After when it was removed.
+type historyChange struct {
+	Part   string          `json:"part"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type historyEntry struct {
+	ID        int64           `json:"id"`
+	Time      time.Time       `json:"time"`
+	Interface string          `json:"interface"` // telnet or http
+	User      string          `json:"user"`
+	Command   string          `json:"command"`
+	Error     string          `json:"error,omitempty"` // if the command failed after changing some parts
+	Changes   []historyChange `json:"changes"`
+}
+
+// changeHistory records every change to the routing table made via the admin interfaces.
+// it also serializes those changes, so that each entry describes exactly one of them.
+type changeHistory struct {
+	sync.Mutex
+	conf    historyConfig
+	f       *os.File // nil if not persisted
+	size    int64
+	entries []historyEntry
+	nextID  int64
+}
+
+var history = &changeHistory{nextID: 1}
+
+// openHistory loads the existing history from the history files, if any,
+// and opens the file to append new entries to.
+func openHistory(conf historyConfig) (*changeHistory, error) {
+	h := &changeHistory{conf: conf, nextID: 1}
+	if conf.File == "" {
+		return h, nil
+	}
+	if conf.Max_size <= 0 {
+		return nil, errors.New("history max_size must be > 0")
+	}
+	if conf.Keep < 0 {
+		return nil, errors.New("history keep must be >= 0")
+	}
+	for i := conf.Keep; i >= 0; i-- {
+		err := h.load(h.rotatedName(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(conf.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	h.f = f
+	h.size = fi.Size()
+	return h, nil
+}
+
+func (h *changeHistory) rotatedName(i int) string {
+	if i == 0 {
+		return h.conf.File
+	}
+	return h.conf.File + "." + strconv.Itoa(i)
+}
+
+func (h *changeHistory) load(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Warning("history: skipping unparseable entry in %s: %s", name, err)
+			continue
+		}
+		h.add(e)
+	}
+	return scanner.Err()
+}
+
+func (h *changeHistory) add(e historyEntry) {
+	h.entries = append(h.entries, e)
+	if len(h.entries) > history_max_entries {
+		h.entries = h.entries[len(h.entries)-history_max_entries:]
+	}
+	if e.ID >= h.nextID {
+		h.nextID = e.ID + 1
+	}
+}
+
+// persist appends the entry to the history file, rotating it first if it would grow too big.
+func (h *changeHistory) persist(e historyEntry) {
+	if h.f == nil {
+		return
+	}
+	line, _ := json.Marshal(e)
+	line = append(line, '\n')
+	if h.size > 0 && h.size+int64(len(line)) > h.conf.Max_size {
+		err := h.rotate()
+		if err != nil {
+			log.Error("could not rotate history file: %s", err)
+		}
+	}
+	n, err := h.f.Write(line)
+	h.size += int64(n)
+	if err != nil {
+		log.Error("could not write to history file: %s", err)
+	}
+}
+
+// rotate moves the history file aside, and starts a new one.
+// the old file stays open until the new one is, so if anything fails, we keep appending to it.
+func (h *changeHistory) rotate() error {
+	if h.conf.Keep == 0 {
+		os.Remove(h.conf.File)
+	}
+	for i := h.conf.Keep - 1; i >= 0; i-- {
+		err := os.Rename(h.rotatedName(i), h.rotatedName(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	f, err := os.OpenFile(h.conf.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	h.f.Close()
+	h.f = f
+	h.size = 0
+	return nil
+}
+
+// track runs fn, which changes the table, and records what changed.
+// that's also done when fn fails, in case it changed something before it did.
+// changes are serialized: only one fn runs at a time.
+func (h *changeHistory) track(table *Table, iface, user, cmd string, fn func() error) error {
+	h.Lock()
+	defer h.Unlock()
+	before := tableParts(table.Snapshot())
+	err := fn()
+	changes := diffParts(before, tableParts(table.Snapshot()))
+	if len(changes) == 0 {
+		return err
+	}
+	e := historyEntry{
+		ID:        h.nextID,
+		Time:      time.Now(),
+		Interface: iface,
+		User:      user,
+		Command:   cmd,
+		Changes:   changes,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	h.add(e)
+	h.persist(e)
+	return err
+}
+
+// list returns the last limit entries, oldest first. limit <= 0 means all of them.
+func (h *changeHistory) list(limit int) []historyEntry {
+	h.Lock()
+	defer h.Unlock()
+	entries := h.entries
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	out := make([]historyEntry, len(entries))
+	copy(out, entries)
+	return out
+}
+
+// tableParts returns the json of each part of the table we track, without runtime state.
+func tableParts(snap TableSnapshot) map[string]json.RawMessage {
+	parts := make(map[string]json.RawMessage)
+	// hits change all the time, they're not part of the config
+	for _, black := range snap.Blacklist {
+		black.Hits, black.LastHit = 0, 0
+	}
+	for _, agg := range snap.Aggregators {
+		agg.Hits, agg.LastHit = 0, 0
+	}
+	parts["blacklist"], _ = json.Marshal(snap.Blacklist)
+	parts["whitelist"], _ = json.Marshal(snap.Whitelist)
+	parts["aggregators"], _ = json.Marshal(snap.Aggregators)
+	parts["defaultRoute"], _ = json.Marshal(snap.DefaultRoute)
+	for _, route := range snap.Routes {
+		for _, dest := range route.Dests {
+			dest.Online = false
+			dest.SlowNow = false
+			dest.SlowLastLoop = false
+			dest.SpoolDepth = 0
+			dest.Conns = nil
+			dest.Resolved = nil
+			dest.ResolvedAt = time.Time{}
+		}
+		route.Hits, route.LastHit = 0, 0
+		parts["routes/"+route.Key], _ = json.Marshal(route)
+	}
+	return parts
+}
+
+func diffParts(before, after map[string]json.RawMessage) []historyChange {
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var changes []historyChange
+	for _, name := range names {
+		b, a := before[name], after[name]
+		if sameJSON(b, a) {
+			continue
+		}
+		if b == nil {
+			b = json.RawMessage("null")
+		}
+		if a == nil {
+			a = json.RawMessage("null")
+		}
+		changes = append(changes, historyChange{name, b, a})
+	}
+	return changes
+}
+
+// isNull returns whether the part didn't exist
+func isNull(m json.RawMessage) bool {
+	return len(m) == 0 || string(m) == "null"
+}
+
+func sameJSON(a, b json.RawMessage) bool {
+	if isNull(a) || isNull(b) {
+		return isNull(a) && isNull(b)
+	}
+	return bytes.Equal(a, b)
+}
+
+// undo restores the parts of the table changed by the given entry to their state before it,
+// and records that as a change of its own.
+// that's only possible if they haven't been changed since, and for routes, if they still exist
+// and only have changes that can be made at runtime.
+// the undo is applied as one batch: either all changes are undone, or, if one of them can't be, none.
+func (h *changeHistory) undo(table *Table, iface, user string, id int64) error {
+	return h.track(table, iface, user, fmt.Sprintf("undo %d", id), func() error {
+		return h.revert(table, id)
+	})
+}
+
+// revert does the actual undoing. the caller must hold the lock.
+func (h *changeHistory) revert(table *Table, id int64) error {
+	var e *historyEntry
+	for i := range h.entries {
+		if h.entries[i].ID == id {
+			e = &h.entries[i]
+		}
+	}
+	if e == nil {
+		return fmt.Errorf("no history entry %d", id)
+	}
+	// begin before looking at the table, so the batch fails if it changes in between
+	batch := table.Begin()
+	current := tableParts(table.Snapshot())
+	for _, c := range e.Changes {
+		if !sameJSON(current[c.Part], c.After) {
+			batch.Abort()
+			return fmt.Errorf("%s has changed since history entry %d", c.Part, id)
+		}
+		var err error
+		switch {
+		case c.Part == "blacklist":
+			err = undoMatchers(c, batch.DelBlacklist, batch.AddBlacklist)
+		case c.Part == "whitelist":
+			err = undoMatchers(c, batch.DelWhitelist, batch.AddWhitelist)
+		case c.Part == "aggregators":
+			err = undoAggregators(batch, table.In, c)
+		case c.Part == "defaultRoute":
+			err = undoDefaultRoute(batch, c)
+		case strings.HasPrefix(c.Part, "routes/"):
+			err = undoRoute(batch, strings.TrimPrefix(c.Part, "routes/"), c)
+		default:
+			err = fmt.Errorf("don't know how to undo a change of %s", c.Part)
+		}
+		if err != nil {
+			batch.Abort()
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// removed returns the elements of a that are not in b (as a multiset)
+func removed(a, b []json.RawMessage) []json.RawMessage {
+	var out []json.RawMessage
+	used := make([]bool, len(b))
+outer:
+	for _, x := range a {
+		for i, y := range b {
+			if !used[i] && bytes.Equal(x, y) {
+				used[i] = true
+				continue outer
+			}
+		}
+		out = append(out, x)
+	}
+	return out
+}
+
+func unmarshalList(data json.RawMessage) ([]json.RawMessage, error) {
+	var list []json.RawMessage
+	if isNull(data) {
+		return list, nil
+	}
+	err := json.Unmarshal(data, &list)
+	return list, err
+}
+
+// undoList returns the entries to remove from, and to add back to, a list part of the table
+func undoList(c historyChange) (toDel, toAdd []json.RawMessage, err error) {
+	before, err := unmarshalList(c.Before)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := unmarshalList(c.After)
+	if err != nil {
+		return nil, nil, err
+	}
+	return removed(after, before), removed(before, after), nil
+}
+
+// indicesOf returns the indices of the entries in the json list, highest first,
+// so that deleting them one by one doesn't shift the ones still to delete.
+func indicesOf(list json.RawMessage, entries []json.RawMessage) ([]int, error) {
+	all, err := unmarshalList(list)
+	if err != nil {
+		return nil, err
+	}
+	used := make([]bool, len(all))
+	var indices []int
+outer:
+	for _, entry := range entries {
+		for i, e := range all {
+			if !used[i] && bytes.Equal(e, entry) {
+				used[i] = true
+				indices = append(indices, i)
+				continue outer
+			}
+		}
+		return nil, errors.New("entry disappeared")
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(indices)))
+	return indices, nil
+}
+
+// undoMatchers undoes a change of a list of matchers (the blacklist or the whitelist) with the given del and add functions
+func undoMatchers(c historyChange, del func(int) error, add func(*Matcher)) error {
+	toDel, toAdd, err := undoList(c)
+	if err != nil {
+		return err
+	}
+	indices, err := indicesOf(c.After, toDel)
+	if err != nil {
+		return fmt.Errorf("%s: %s", c.Part, err)
+	}
+	for _, i := range indices {
+		if err := del(i); err != nil {
+			return err
+		}
+	}
+	for _, entry := range toAdd {
+		matcher := new(Matcher)
+		if err := json.Unmarshal(entry, matcher); err != nil {
+			return err
+		}
+		if err := matcher.compile(); err != nil {
+			return err
+		}
+		add(matcher)
+	}
+	return nil
+}
+
+func undoDefaultRoute(batch *TableBatch, c historyChange) error {
+	var key string
+	if err := json.Unmarshal(c.Before, &key); err != nil {
+		return err
+	}
+	return batch.SetDefaultRoute(key)
+}
+
+// undoAggregators stages removing the aggregators that were added, and adding back those that were removed.
+// the latter start running right away, the batch shuts them down again if it's aborted.
+func undoAggregators(batch *TableBatch, out chan []byte, c historyChange) error {
+	toDel, toAdd, err := undoList(c)
+	if err != nil {
+		return err
+	}
+	indices, err := indicesOf(c.After, toDel)
+	if err != nil {
+		return fmt.Errorf("aggregators: %s", err)
+	}
+	for _, i := range indices {
+		if err := batch.DelAggregator(i); err != nil {
+			return err
+		}
+	}
+	for _, entry := range toAdd {
+		var a aggregator.Aggregator
+		if err := json.Unmarshal(entry, &a); err != nil {
+			return err
+		}
+		var agg *aggregator.Aggregator
+		if a.Glob != "" {
+			agg, err = aggregator.NewGlob(a.Fun, a.Glob, a.OutFmt, a.Interval, a.Wait, out)
+		} else {
+			agg, err = aggregator.New(a.Fun, a.Regex, a.OutFmt, a.Interval, a.Wait, out)
+		}
+		if err != nil {
+			return err
+		}
+		batch.AddAggregator(agg)
+	}
+	return nil
+}
+
+func matcherOpts(before, after Matcher) map[string]string {
+	opts := make(map[string]string)
+	if before.Prefix != after.Prefix {
+		opts["prefix"] = before.Prefix
+	}
+	if before.Sub != after.Sub {
+		opts["sub"] = before.Sub
+	}
+	if before.Regex != after.Regex {
+		opts["regex"] = before.Regex
+	}
+	if before.Glob != after.Glob {
+		opts["glob"] = before.Glob
+	}
+	if before.NotPrefix != after.NotPrefix {
+		opts["notprefix"] = before.NotPrefix
+	}
+	if before.NotSub != after.NotSub {
+		opts["notsub"] = before.NotSub
+	}
+	if before.NotRegex != after.NotRegex {
+		opts["notregex"] = before.NotRegex
+	}
+	return opts
+}
+
+func undoRoute(batch *TableBatch, key string, c historyChange) error {
+	if isNull(c.Before) {
+		return batch.DelRoute(key)
+	}
+	if isNull(c.After) {
+		return fmt.Errorf("route %s was removed. add it again instead", key)
+	}
+	var before, after RouteSnapshot
+	if err := json.Unmarshal(c.Before, &before); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(c.After, &after); err != nil {
+		return err
+	}
+	if len(before.Dests) != len(after.Dests) {
+		return fmt.Errorf("route %s: can't undo adding or removing destinations", key)
+	}
+	if opts := matcherOpts(before.Matcher, after.Matcher); len(opts) != 0 {
+		if err := batch.UpdateRoute(key, opts); err != nil {
+			return err
+		}
+	}
+	for i := range before.Dests {
+		b, a := before.Dests[i], after.Dests[i]
+		opts := matcherOpts(b.Matcher, a.Matcher)
+		if b.Addr != a.Addr {
+			opts["addr"] = b.Addr
+		}
+		if b.BufSize != a.BufSize || b.WriteBuf != a.WriteBuf {
+			opts["bufsize"] = strconv.Itoa(b.BufSize)
+			opts["writebuf"] = strconv.Itoa(b.WriteBuf)
+		}
+		if b.MaxRate != a.MaxRate {
+			opts["maxrate"] = strconv.FormatInt(b.MaxRate, 10)
+		}
+		if b.Weight != a.Weight {
+			opts["weight"] = strconv.FormatInt(b.Weight, 10)
+		}
+		// make sure there's nothing else that differs, which we can't change back
+		a.Matcher, a.Addr, a.BufSize, a.WriteBuf, a.MaxRate, a.Weight = b.Matcher, b.Addr, b.BufSize, b.WriteBuf, b.MaxRate, b.Weight
+		bj, _ := json.Marshal(b)
+		aj, _ := json.Marshal(a)
+		if !bytes.Equal(bj, aj) {
+			return fmt.Errorf("route %s: destination %d has changes that can't be undone", key, i)
+		}
+		if len(opts) != 0 {
+			if err := batch.UpdateDestination(key, i, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/gorilla/mux"
+)
+
+func openTestHistory(t *testing.T, conf historyConfig) {
+	var err error
+	history, err = openHistory(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryTrackAndUndo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := historyConfig{File: filepath.Join(dir, "history"), Max_size: 1024 * 1024, Keep: 2}
+	openTestHistory(t, conf)
+	defer func() { history = &changeHistory{nextID: 1} }()
+
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch hist  127.0.0.1:2099 flush=10")
+	defer table.ShutdownOrFatal(t)
+	apply := func(cmd string) error {
+		return history.track(table, "telnet", "ops", cmd, func() error {
+			return applyCommand(table, cmd)
+		})
+	}
+	if err := apply("addBlack prefix foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply("modDest hist 0 prefix=a maxrate=50"); err != nil {
+		t.Fatal(err)
+	}
+	if err := apply("modDest hist 0 bogus=1"); err == nil {
+		t.Fatal("expected an error for an invalid option")
+	}
+	err = history.track(table, "http", "ops", "no-op", func() error { return nil })
+	assert.Equal(t, nil, err)
+
+	entries := history.list(0)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, int64(1), entries[0].ID)
+	assert.Equal(t, "addBlack prefix foo", entries[0].Command)
+	assert.Equal(t, 1, len(entries[0].Changes))
+	assert.Equal(t, "blacklist", entries[0].Changes[0].Part)
+	assert.Equal(t, "[]", string(entries[0].Changes[0].Before))
+	assert.Equal(t, "routes/hist", entries[1].Changes[0].Part)
+	assert.Equal(t, 1, len(history.list(1)))
+
+	if err := history.undo(table, "telnet", "ops", 2); err != nil {
+		t.Fatal(err)
+	}
+	dest := table.GetRoute("hist").Snapshot().Dests[0]
+	assert.Equal(t, "", dest.Matcher.Prefix)
+	assert.Equal(t, int64(0), dest.MaxRate)
+	if err := history.undo(table, "telnet", "ops", 1); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(table.Snapshot().Blacklist))
+	if history.undo(table, "telnet", "ops", 1) == nil {
+		t.Fatal("expected an error undoing a change of a part that has changed since")
+	}
+	if history.undo(table, "telnet", "ops", 42) == nil {
+		t.Fatal("expected an error undoing a non-existent entry")
+	}
+
+	// adding a route is undone by removing it
+	if err := apply("addRoute sendAllMatch other  127.0.0.1:2099 flush=10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.undo(table, "telnet", "ops", 5); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, nil, table.GetRoute("other"))
+
+	entries = history.list(0)
+	assert.Equal(t, 6, len(entries))
+	assert.Equal(t, "undo 2", entries[2].Command)
+
+	// everything was persisted, and survives a restart
+	openTestHistory(t, conf)
+	loaded := history.list(0)
+	assert.Equal(t, len(entries), len(loaded))
+	for i, e := range loaded {
+		assert.Equal(t, true, e.Time.Equal(entries[i].Time))
+		e.Time = entries[i].Time
+		assert.Equal(t, entries[i], e)
+	}
+	assert.Equal(t, int64(7), history.nextID)
+}
+
+func TestHistoryUndoAtomic(t *testing.T) {
+	openTestHistory(t, historyConfig{})
+	defer func() { history = &changeHistory{nextID: 1} }()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch hist  127.0.0.1:2099 flush=10  127.0.0.1:2098 flush=10")
+	defer table.ShutdownOrFatal(t)
+	track := func(cmds ...string) error {
+		return history.track(table, "telnet", "ops", "several", func() error {
+			for _, cmd := range cmds {
+				if err := applyCommand(table, cmd); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	// one entry changing several parts is undone as a whole
+	err := track("addBlack prefix foo", "addAgg sum ^stats\\.(.*)\\.requests totals.requests 60 120", "modRoute hist prefix=a")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, history.undo(table, "telnet", "ops", 1))
+	snap := table.Snapshot()
+	assert.Equal(t, 0, len(snap.Blacklist))
+	assert.Equal(t, 0, len(snap.Aggregators))
+	assert.Equal(t, "", snap.Routes[0].Matcher.Prefix)
+	assert.Equal(t, 3, len(history.list(0)[1].Changes))
+
+	// if any of the changes can't be undone, none are
+	err = history.track(table, "telnet", "ops", "several", func() error {
+		if err := applyCommand(table, "addBlack prefix bar"); err != nil {
+			return err
+		}
+		return table.DelDestination("hist", 1)
+	})
+	assert.Equal(t, nil, err)
+	if history.undo(table, "telnet", "ops", 3) == nil {
+		t.Fatal("expected an error undoing the removal of a destination")
+	}
+	assert.Equal(t, 1, len(table.Snapshot().Blacklist))
+	assert.Equal(t, 3, len(history.list(0)))
+}
+
+func TestHistoryTrackFailure(t *testing.T) {
+	openTestHistory(t, historyConfig{})
+	defer func() { history = &changeHistory{nextID: 1} }()
+	table := NewTableOrFatal(t, "", "")
+	// what fn changed before it failed is recorded too
+	err := history.track(table, "telnet", "ops", "partial", func() error {
+		if err := applyCommand(table, "addBlack prefix foo"); err != nil {
+			return err
+		}
+		return errors.New("failed halfway")
+	})
+	assert.Equal(t, "failed halfway", err.Error())
+	entries := history.list(0)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "failed halfway", entries[0].Error)
+	assert.Equal(t, "blacklist", entries[0].Changes[0].Part)
+}
+
+func TestHistoryRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := historyConfig{File: filepath.Join(dir, "history"), Max_size: 1000, Keep: 2}
+	openTestHistory(t, conf)
+	defer func() { history = &changeHistory{nextID: 1} }()
+
+	table := NewTableOrFatal(t, "", "")
+	for i := 0; i < 10; i++ {
+		cmd := "addBlack prefix foo"
+		err := history.track(table, "telnet", "ops", cmd, func() error {
+			return applyCommand(table, cmd)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"history", "history.1", "history.2"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > conf.Max_size {
+			t.Fatalf("%s is %d bytes, more than the max of %d", name, fi.Size(), conf.Max_size)
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, "history.3"))
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// only the entries of the files we kept are loaded
+	openTestHistory(t, conf)
+	entries := history.list(0)
+	assert.Equal(t, int64(10), entries[len(entries)-1].ID)
+	assert.NotEqual(t, int64(1), entries[0].ID)
+}
+
+func TestHistoryRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := historyConfig{File: filepath.Join(dir, "history"), Max_size: 300, Keep: 1}
+	openTestHistory(t, conf)
+	defer func() { history = &changeHistory{nextID: 1} }()
+	// a non-empty directory in the way makes the rename fail
+	if err := os.MkdirAll(filepath.Join(dir, "history.1", "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	table := NewTableOrFatal(t, "", "")
+	for i := 0; i < 5; i++ {
+		cmd := "addBlack prefix foo"
+		err := history.track(table, "telnet", "ops", cmd, func() error {
+			return applyCommand(table, cmd)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// we kept appending to the old file
+	data, err := ioutil.ReadFile(conf.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 5, bytes.Count(data, []byte("\n")))
+}
+
+func TestHistoryHTTP(t *testing.T) {
+	openTestHistory(t, historyConfig{})
+	defer func() { history = &changeHistory{nextID: 1} }()
+	table = NewTableOrFatal(t, "", "addBlack prefix foo")
+	router := mux.NewRouter()
+	router.Handle("/blacklists/{index}", tracked(removeBlacklist)).Methods("DELETE")
+	router.Handle("/history", handler(listHistory)).Methods("GET")
+	router.Handle("/history/{id}/undo", handler(undoHistory)).Methods("POST")
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/blacklists/0").Code)
+	assert.Equal(t, 0, len(table.Snapshot().Blacklist))
+	var entries []historyEntry
+	w := do("GET", "/history")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "http", entries[0].Interface)
+	assert.Equal(t, "anonymous", entries[0].User)
+	assert.Equal(t, "DELETE /blacklists/0", entries[0].Command)
+
+	assert.Equal(t, http.StatusOK, do("POST", "/history/1/undo").Code)
+	assert.Equal(t, 1, len(table.Snapshot().Blacklist))
+	assert.Equal(t, http.StatusConflict, do("POST", "/history/1/undo").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/history?limit=x").Code)
+}
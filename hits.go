@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+)
+
+// ruleHits counts the metrics that a rule (a blacklist entry or a route) took, and remembers when it last took one,
+// so that rules that no longer do anything can be found.
+// the count is that of the counter in the metrics registry, so rules with the same id share it.
+type ruleHits struct {
+	lastHit int64 // unix timestamp, atomic. 0 if never hit
+	counter metrics.Counter
+}
+
+// unixNow is the current unix timestamp, as of the last tick of the clock. only access atomically.
+// hits are on the path of every metric, this saves them from getting the time.
+var unixNow int64
+var startClock sync.Once
+
+// newRuleHits registers the counter for the rule of the given kind (blacklist, route, aggregator) and id
+func newRuleHits(kind, id string) *ruleHits {
+	startClock.Do(func() {
+		atomic.StoreInt64(&unixNow, time.Now().Unix())
+		go func() {
+			for now := range time.Tick(time.Second) {
+				atomic.StoreInt64(&unixNow, now.Unix())
+			}
+		}()
+	})
+	return &ruleHits{counter: hitCounter(kind, id)}
+}
+
+func hitCounter(kind, id string) metrics.Counter {
+	return Counter(kind + "=" + ruleID(id) + ".unit=Metric.what=hits")
+}
+
+// ruleID turns the description of a rule, such as the string of its matcher, into something usable as a metric node
+func ruleID(desc string) string {
+	id := []byte(desc)
+	for i, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			id[i] = '_'
+		}
+	}
+	return string(id)
+}
+
+// hit records a hit. it's a no-op on nil, for rules that were never added to a table.
+func (h *ruleHits) hit() {
+	if h == nil {
+		return
+	}
+	h.counter.Inc(1)
+	// avoid contended writes when hit in the same second
+	if now := atomic.LoadInt64(&unixNow); atomic.LoadInt64(&h.lastHit) != now {
+		atomic.StoreInt64(&h.lastHit, now)
+	}
+}
+
+// get returns the amount of hits and the time of the last one, which may be up to a second behind
+func (h *ruleHits) get() (count, lastHit int64) {
+	if h == nil {
+		return 0, 0
+	}
+	return h.counter.Count(), atomic.LoadInt64(&h.lastHit)
+}
+
+// withHits returns a copy of the matcher, with its hits filled in for a snapshot
+func (m *Matcher) withHits() *Matcher {
+	c := *m
+	c.Hits, c.LastHit = m.hits.get()
+	return &c
+}
+
+// lastHitString formats a last-hit timestamp for the table overview
+func lastHitString(lastHit int64) string {
+	if lastHit == 0 {
+		return "never"
+	}
+	return time.Unix(lastHit, 0).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/aggregator"
+)
+
+func registeredHits(t *testing.T, key string) int64 {
+	c, ok := metrics.DefaultRegistry.Get(expandKey("target_type=counter." + key + ".unit=Metric.what=hits")).(metrics.Counter)
+	if !ok {
+		t.Fatalf("no hit counter registered for %s", key)
+	}
+	return c.Count()
+}
+
+func TestHitsBlacklistAndRoutes(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addBlack prefix stats.secret.")
+	defer table.ShutdownOrFatal(t)
+	// enough entries for the blacklist to be indexed
+	for i := 0; i < minIndexRules; i++ {
+		assert.Equal(t, nil, applyCommand(table, fmt.Sprintf("addBlack prefix black%d.", i)))
+	}
+	assert.Equal(t, nil, applyCommand(table, "addRoute sendAllMatch main prefix=stats.  127.0.0.1:0 prometheus=true"))
+	assert.Equal(t, nil, applyCommand(table, "addRoute sendAllMatch idle prefix=nothing.  127.0.0.1:0 prometheus=true"))
+	registered := registeredHits(t, "blacklist=prefix_black3_")
+	before := tableParts(table.Snapshot())
+	// the counts are those of the registry, which other tests may have added to already
+	snap := table.Snapshot()
+	var blackBefore []int64
+	for _, black := range snap.Blacklist {
+		blackBefore = append(blackBefore, black.Hits)
+	}
+	routesBefore := []int64{snap.Routes[0].Hits, snap.Routes[1].Hits}
+
+	// last hits are tracked with a clock that ticks every second
+	start := time.Now().Unix() - 1
+	table.Dispatch([]byte("black3.foo 1 1"))
+	table.Dispatch([]byte("black3.bar 1 1"))
+	table.Dispatch([]byte("stats.secret.foo 1 1"))
+	table.Dispatch([]byte("stats.foo 1 1"))
+
+	snap = table.Snapshot()
+	assert.Equal(t, int64(1), snap.Blacklist[0].Hits-blackBefore[0])
+	assert.Equal(t, int64(2), snap.Blacklist[4].Hits-blackBefore[4])
+	assert.Equal(t, true, snap.Blacklist[4].LastHit >= start)
+	assert.Equal(t, int64(0), snap.Blacklist[5].Hits-blackBefore[5])
+	assert.Equal(t, int64(0), snap.Blacklist[5].LastHit)
+	assert.Equal(t, registered+2, registeredHits(t, "blacklist=prefix_black3_"))
+
+	assert.Equal(t, int64(1), snap.Routes[0].Hits-routesBefore[0])
+	assert.Equal(t, true, snap.Routes[0].LastHit >= start)
+	assert.Equal(t, int64(0), snap.Routes[1].Hits-routesBefore[1])
+	assert.Equal(t, int64(0), snap.Routes[1].LastHit)
+	assert.Equal(t, true, strings.Contains(table.Print(), "never"))
+
+	// hits are not part of the config, so they don't show up as changes in the history
+	after := tableParts(table.Snapshot())
+	assert.Equal(t, string(before["blacklist"]), string(after["blacklist"]))
+	assert.Equal(t, string(before["routes/main"]), string(after["routes/main"]))
+}
+
+func TestHitsAggregator(t *testing.T) {
+	var counter metrics.Counter
+	defer func(orig func(string) metrics.Counter) {
+		aggregator.HitCounter = orig
+	}(aggregator.HitCounter)
+	aggregator.HitCounter = func(desc string) metrics.Counter {
+		counter = metrics.NewCounter()
+		return counter
+	}
+	table := NewTableOrFatal(t, "", "addAgg sum ^stats\\.(.*)\\.requests totals.requests 60 120")
+	defer table.ShutdownOrFatal(t)
+
+	ts := time.Now().Unix()
+	table.Dispatch([]byte(fmt.Sprintf("stats.web1.requests 1 %d", ts)))
+	table.Dispatch([]byte(fmt.Sprintf("stats.web1.errors 1 %d", ts)))
+	table.config.Load().(TableConfig).aggregators[0].FlushAll()
+	agg := table.Snapshot().Aggregators[0]
+	assert.Equal(t, int64(1), agg.Hits)
+	assert.Equal(t, true, agg.LastHit >= ts)
+	assert.Equal(t, int64(1), counter.Count())
+}
+
+func TestRuleID(t *testing.T) {
+	assert.Equal(t, "prefix_stats__notsub__test__or_glob_a___b", ruleID("prefix=stats. notsub=.test. or glob=a.*.b"))
+}
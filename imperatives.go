@@ -13,10 +13,16 @@ import (
 
 const (
 	addBlack toki.Token = iota
+	addWhite
+	delWhite
+	addDefault
+	delDefault
 	addAgg
 	addRouteSendAllMatch
 	addRouteSendFirstMatch
 	addRouteConsistentHashing
+	addRouteRoundRobin
+	addRouteWeighted
 	addDest
 	modDest
 	modRoute
@@ -25,15 +31,21 @@ const (
 	word
 )
 
-// we should make sure we apply changes atomatically. e.g. when changing dest between address A and pickle=false and B with pickle=true,
-// we should never half apply the change if one of them fails.
+// commands are staged in a TableBatch, so that we never half apply a change, or a set of changes, if any part of it fails.
+// e.g. when changing dest between address A and pickle=false and B with pickle=true.
 
 var tokenDefGlobal = []toki.Def{
 	{Token: addBlack, Pattern: "addBlack .*"},
+	{Token: addWhite, Pattern: "addWhite .*"},
+	{Token: delWhite, Pattern: "delWhite .*"},
+	{Token: addDefault, Pattern: "addDefault .*"},
+	{Token: delDefault, Pattern: "delDefault"},
 	{Token: addAgg, Pattern: "addAgg .*"},
 	{Token: addRouteSendAllMatch, Pattern: "addRoute sendAllMatch [a-z-_]+"},
 	{Token: addRouteSendFirstMatch, Pattern: "addRoute sendFirstMatch [a-z-_]+"},
 	{Token: addRouteConsistentHashing, Pattern: "addRoute consistentHashing [a-z-_]+"},
+	{Token: addRouteRoundRobin, Pattern: "addRoute roundRobin [a-z-_]+"},
+	{Token: addRouteWeighted, Pattern: "addRoute weighted [a-z-_]+"},
 	{Token: addDest, Pattern: "addDest [a-z-_]+"},
 	{Token: modDest, Pattern: "modDest .*"},
 	{Token: modRoute, Pattern: "modRoute .*"},
@@ -55,10 +67,23 @@ func readDestinations(specs []string, table *Table, allowMatcher bool) (destinat
 	s := toki.NewScanner(tokenDefDest)
 	for _, spec := range specs {
 		//fmt.Println("spec" + spec)
-		var prefix, sub, regex, addr, spoolDir string
-		var spool, pickle bool
+		var addr, spoolDir string
+		matcher := new(Matcher)
+		clause := matcher // the clause we're reading matching options for
+		var spool, pickle, ack, multiAddr bool
+		onFull := "drop"
 		flush := 1000
 		reconn := 10000
+		resolve := 0
+		connections := 1
+		spread := "hash"
+		bufSize := conn_in_buffer
+		writeBuf := bufio_buffer_size
+		var maxRate int64
+		var weight int64 = 1
+		var prometheus bool
+		expire := -1 // in seconds. only for prometheus dests, which default to 300
+		var mappings []*promMapping
 		spoolDir = table.spoolDir
 		s.SetInput(spec)
 		t := s.Next()
@@ -74,19 +99,18 @@ func readDestinations(specs []string, table *Table, allowMatcher bool) (destinat
 		}
 		for {
 			t := s.Next()
+			if t.Token == word && string(t.Value) == "or" {
+				clause = new(Matcher)
+				matcher.Or = append(matcher.Or, clause)
+				continue
+			}
 			if t.Token == opt {
 				val := string(t.Value)
 				//fmt.Println("yes got my opt with val", val)
 				switch val {
-				case "prefix=":
-					val := s.Next()
-					prefix = string(val.Value)
-				case "sub=":
-					val := s.Next()
-					sub = string(val.Value)
-				case "regex=":
-					val := s.Next()
-					regex = string(val.Value)
+				case "prefix=", "sub=", "regex=", "glob=", "notprefix=", "notsub=", "notregex=":
+					v := s.Next()
+					clause.setOpt(strings.TrimSuffix(val, "="), string(v.Value))
 				case "flush=":
 					val := s.Next()
 					i, err := strconv.Atoi(string(val.Value))
@@ -101,6 +125,81 @@ func readDestinations(specs []string, table *Table, allowMatcher bool) (destinat
 						return destinations, err
 					}
 					reconn = i
+				case "resolve=":
+					val := s.Next()
+					i, err := strconv.Atoi(string(val.Value))
+					if err != nil {
+						return destinations, err
+					}
+					resolve = i
+				case "multiaddr=":
+					t := s.Next()
+					val := string(t.Value)
+					if val == "true" {
+						multiAddr = true
+					} else if val != "false" {
+						return destinations, fmt.Errorf("unrecognized multiaddr value '%s'", val)
+					}
+				case "connections=":
+					val := s.Next()
+					i, err := strconv.Atoi(string(val.Value))
+					if err != nil {
+						return destinations, err
+					}
+					connections = i
+				case "bufsize=":
+					val := s.Next()
+					i, err := strconv.Atoi(string(val.Value))
+					if err != nil {
+						return destinations, err
+					}
+					bufSize = i
+				case "writebuf=":
+					val := s.Next()
+					i, err := strconv.Atoi(string(val.Value))
+					if err != nil {
+						return destinations, err
+					}
+					writeBuf = i
+				case "maxrate=":
+					val := s.Next()
+					i, err := strconv.ParseInt(string(val.Value), 10, 64)
+					if err != nil {
+						return destinations, err
+					}
+					maxRate = i
+				case "weight=":
+					val := s.Next()
+					i, err := strconv.ParseInt(string(val.Value), 10, 64)
+					if err != nil {
+						return destinations, err
+					}
+					weight = i
+				case "prometheus=":
+					t := s.Next()
+					val := string(t.Value)
+					if val == "true" {
+						prometheus = true
+					} else if val != "false" {
+						return destinations, fmt.Errorf("unrecognized prometheus value '%s'", val)
+					}
+				case "expire=":
+					val := s.Next()
+					i, err := strconv.Atoi(string(val.Value))
+					if err != nil {
+						return destinations, err
+					}
+					expire = i
+				case "map=":
+					val := s.Next()
+					m, err := readPromMapping(string(val.Value))
+					if err != nil {
+						return destinations, err
+					}
+					mappings = append(mappings, m)
+				case "spread=":
+					t := s.Next()
+					spread = string(t.Value)
 				case "pickle=":
 					t := s.Next()
 					val := string(t.Value)
@@ -118,6 +217,17 @@ func readDestinations(specs []string, table *Table, allowMatcher bool) (destinat
 					} else if val != "false" {
 						return destinations, fmt.Errorf("unrecognized spool value '%s'", val)
 					}
+				case "ack=":
+					t := s.Next()
+					val := string(t.Value)
+					if val == "true" {
+						ack = true
+					} else if val != "false" {
+						return destinations, fmt.Errorf("unrecognized ack value '%s'", val)
+					}
+				case "onfull=":
+					t := s.Next()
+					onFull = string(t.Value)
 				default:
 					return destinations, fmt.Errorf("unrecognized option '%s'", val)
 				}
@@ -129,10 +239,54 @@ func readDestinations(specs []string, table *Table, allowMatcher bool) (destinat
 
 		periodFlush := time.Duration(flush) * time.Millisecond
 		periodReConn := time.Duration(reconn) * time.Millisecond
-		if !allowMatcher && (prefix != "" || sub != "" || regex != "") {
-			return destinations, fmt.Errorf("matching options (prefix, sub, and regex) not allowed for this route type")
+		if !allowMatcher && !matcher.Empty() {
+			return destinations, fmt.Errorf("matching options (prefix, sub, regex, glob, their negations and or) not allowed for this route type")
+		}
+		if err := matcher.compile(); err != nil {
+			return destinations, err
+		}
+		if prometheus {
+			if spool || pickle || ack || maxRate != 0 {
+				return destinations, errors.New("spool, pickle, ack and maxrate are not supported for prometheus destinations")
+			}
+			if expire == -1 {
+				expire = 300
+			}
+			dest, err := NewPrometheusDestination(*matcher, addr, time.Duration(expire)*time.Second, mappings, periodReConn)
+			if err != nil {
+				return destinations, err
+			}
+			err = dest.SetWeight(weight)
+			if err != nil {
+				return destinations, err
+			}
+			destinations = append(destinations, dest)
+			continue
+		}
+		if expire != -1 || len(mappings) != 0 {
+			return destinations, errors.New("expire and map are only for prometheus destinations (prometheus=true)")
+		}
+		dest, err := NewDestination(*matcher, addr, spoolDir, spool, pickle, ack, onFull, periodFlush, periodReConn)
+		if err != nil {
+			return destinations, err
 		}
-		dest, err := NewDestination(prefix, sub, regex, addr, spoolDir, spool, pickle, periodFlush, periodReConn)
+		err = dest.SetResolve(time.Duration(resolve)*time.Millisecond, multiAddr)
+		if err != nil {
+			return destinations, err
+		}
+		err = dest.SetConnections(connections, spread)
+		if err != nil {
+			return destinations, err
+		}
+		err = dest.SetBufSizes(bufSize, writeBuf)
+		if err != nil {
+			return destinations, err
+		}
+		err = dest.SetMaxRate(maxRate)
+		if err != nil {
+			return destinations, err
+		}
+		err = dest.SetWeight(weight)
 		if err != nil {
 			return destinations, err
 		}
@@ -141,61 +295,128 @@ func readDestinations(specs []string, table *Table, allowMatcher bool) (destinat
 	return destinations, nil
 }
 
+// readPromMapping parses a mapping spec like 'servers.*.cpu.*:cpu{host="$1",type="$2"}'
+func readPromMapping(spec string) (*promMapping, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected '<pattern>:<template>' mapping, not '%s'", spec)
+	}
+	return NewPromMapping(parts[0], parts[1])
+}
+
 // note the two spaces between a route and endpoints
-//"addBlack [prefix|sub|regex] filter-out-all-metrics-matching-this-substring",
+//"addBlack [prefix|sub|regex|glob|notprefix|notsub|notregex] filter-out-all-metrics-matching-this-substring",
 //"addRoute sendAllMatch carbon-default  127.0.0.1:2005 spool=false pickle=false",
 //"addRoute sendFirstMatch demo sub=foo prefix=foo re=foo  127.0.0.1:12345 spool=true"
+//"addRoute sendAllMatch demo prefix=stats. notsub=.test. or prefix=collectd.  127.0.0.1:12345"
+//"addRoute weighted lb prefix=stats.  127.0.0.1:12345 weight=3  127.0.0.1:12346"
+//"addRoute sendAllMatch cpu prefix=servers.  127.0.0.1:2003  0.0.0.0:9108 prometheus=true expire=300 map=servers.*.cpu.*:cpu{host="$1",type="$2"}"
 //addBlack string-without-spaces
+//"addWhite [prefix|sub|regex|glob|notprefix|notsub|notregex] only-accept-metrics-matching-this-or-other-whitelist-entries",
+//"delWhite <index>",
+//"addDefault <routeKey>", # the route gets all metrics that no other route takes
+//"delDefault",
 //addRoute <type> <key> <match options>  <dests> # match options can't have spaces for now. sorry
 //dests:
 // <tcp addr> <options>
 
+// readListMatcher reads the matcher of an addBlack or addWhite command:
+// <cmd> <method> <pattern> [<method> <pattern> ...] [or <method> <pattern> ...], or <cmd> <substring>
+func readListMatcher(cmd string, inputs []string) (*Matcher, error) {
+	usage := cmd + " [prefix|sub|regex|glob|notprefix|notsub|notregex] <pattern> [...] [or ...]"
+	matcher := new(Matcher)
+
+	if len(inputs) == 2 {
+		// The fallback case to support the default substring method
+		matcher.Sub = inputs[1]
+	} else if len(inputs) >= 3 {
+		// one or more method and pattern pairs, optionally with alternatives
+		clause := matcher
+		for i := 1; i < len(inputs); i++ {
+			if inputs[i] == "or" {
+				if clause != matcher && !clause.hasConditions() || i+1 == len(inputs) {
+					return nil, errors.New(usage + " (empty alternative)")
+				}
+				clause = new(Matcher)
+				matcher.Or = append(matcher.Or, clause)
+				continue
+			}
+			if i+1 == len(inputs) {
+				return nil, errors.New(usage)
+			}
+			if !clause.setOpt(inputs[i], inputs[i+1]) {
+				return nil, errors.New(usage + " (invalid match type)")
+			}
+			i++
+		}
+	} else {
+		return nil, errors.New(usage)
+	}
+
+	if err := matcher.compile(); err != nil {
+		return nil, err
+	}
+	return matcher, nil
+}
+
+// applyCommand applies the command to the table, as a batch of one:
+// either the command is applied completely, or, if anything about it fails, not at all.
 func applyCommand(table *Table, cmd string) error {
+	batch := table.Begin()
+	err := batch.Apply(cmd)
+	if err != nil {
+		batch.Abort()
+		return err
+	}
+	return batch.Commit()
+}
+
+// stageCommand stages the command in the batch
+func stageCommand(batch *TableBatch, cmd string) error {
 	inputs := strings.Split(cmd, "  ")
 	s := toki.NewScanner(tokenDefGlobal)
 	s.SetInput(inputs[0])
 	t := s.Next()
 	if t.Token == addBlack {
+		m, err := readListMatcher("addBlack", strings.Fields(cmd))
+		if err != nil {
+			return err
+		}
+		batch.AddBlacklist(m)
+	} else if t.Token == addWhite {
+		m, err := readListMatcher("addWhite", strings.Fields(cmd))
+		if err != nil {
+			return err
+		}
+		batch.AddWhitelist(m)
+	} else if t.Token == delWhite {
 		inputs = strings.Fields(cmd)
-
-		prefix_pat := ""
-		sub_pat := ""
-		regex_pat := ""
-
-		if len(inputs) == 2 {
-			// The fallback case to support the default substring method
-			sub_pat = inputs[1]
-		} else if len(inputs) == 3 {
-			// New case supporting prefix, sub and regex patterns
-			match_method := inputs[1]
-			pattern := inputs[2]
-
-			if match_method == "prefix" {
-				prefix_pat = pattern
-			} else if match_method == "sub" {
-				sub_pat = pattern
-			} else if match_method == "regex" {
-				regex_pat = pattern
-			} else {
-				return errors.New("addBlack [prefix|sub|regex] <pattern> (invalid match type)")
-			}
-		} else {
-			return errors.New("addBlack [prefix|sub|regex] <pattern>")
+		if len(inputs) != 2 {
+			return errors.New("delWhite <index>")
 		}
-
-		m, err := NewMatcher(prefix_pat, sub_pat, regex_pat)
+		index, err := strconv.Atoi(inputs[1])
 		if err != nil {
 			return err
 		}
-		table.AddBlacklist(m)
-
+		return batch.DelWhitelist(index)
+	} else if t.Token == addDefault {
+		inputs = strings.Fields(cmd)
+		if len(inputs) != 2 {
+			return errors.New("addDefault <routeKey>")
+		}
+		return batch.SetDefaultRoute(inputs[1])
+	} else if t.Token == delDefault {
+		if len(strings.Fields(cmd)) != 1 {
+			return errors.New("delDefault takes no arguments")
+		}
+		return batch.SetDefaultRoute("")
 	} else if t.Token == addAgg {
 		inputs = strings.Fields(cmd)
 		if len(inputs) != 6 {
 			return errors.New("addAgg <func> <match> <key> <interval> <wait>")
 		}
 		fun := inputs[1]
-		regex := inputs[2]
+		match := inputs[2]
 		outFmt := inputs[3]
 		interval, err := strconv.Atoi(inputs[4])
 		if err != nil {
@@ -205,11 +426,16 @@ func applyCommand(table *Table, cmd string) error {
 		if err != nil {
 			return err
 		}
-		agg, err := aggregator.New(fun, regex, outFmt, uint(interval), uint(wait), table.In)
+		var agg *aggregator.Aggregator
+		if strings.HasPrefix(match, "glob=") {
+			agg, err = aggregator.NewGlob(fun, strings.TrimPrefix(match, "glob="), outFmt, uint(interval), uint(wait), batch.table.In)
+		} else {
+			agg, err = aggregator.New(fun, match, outFmt, uint(interval), uint(wait), batch.table.In)
+		}
 		if err != nil {
 			return err
 		}
-		table.AddAggregator(agg)
+		batch.AddAggregator(agg)
 	} else if t.Token == addRouteSendAllMatch {
 		split := strings.Split(string(t.Value), " ")
 		key := split[2]
@@ -217,19 +443,19 @@ func applyCommand(table *Table, cmd string) error {
 			return fmt.Errorf("must get at least 1 destination for route '%s'", key)
 		}
 
-		prefix, sub, regex, err := readRouteOpts(s)
+		matcher, err := readRouteOpts(s)
 		if err != nil {
 			return err
 		}
-		destinations, err := readDestinations(inputs[1:], table, true)
+		destinations, err := readDestinations(inputs[1:], batch.table, true)
 		if err != nil {
 			return err
 		}
-		route, err := NewRouteSendAllMatch(key, prefix, sub, regex, destinations)
+		route, err := NewRouteSendAllMatch(key, *matcher, destinations)
 		if err != nil {
 			return err
 		}
-		table.AddRoute(route)
+		batch.AddRoute(route)
 	} else if t.Token == addRouteSendFirstMatch {
 		split := strings.Split(string(t.Value), " ")
 		key := split[2]
@@ -237,19 +463,19 @@ func applyCommand(table *Table, cmd string) error {
 			return fmt.Errorf("must get at least 1 destination for route '%s'", key)
 		}
 
-		prefix, sub, regex, err := readRouteOpts(s)
+		matcher, err := readRouteOpts(s)
 		if err != nil {
 			return err
 		}
-		destinations, err := readDestinations(inputs[1:], table, true)
+		destinations, err := readDestinations(inputs[1:], batch.table, true)
 		if err != nil {
 			return err
 		}
-		route, err := NewRouteSendFirstMatch(key, prefix, sub, regex, destinations)
+		route, err := NewRouteSendFirstMatch(key, *matcher, destinations)
 		if err != nil {
 			return err
 		}
-		table.AddRoute(route)
+		batch.AddRoute(route)
 	} else if t.Token == addRouteConsistentHashing {
 		split := strings.Split(string(t.Value), " ")
 		key := split[2]
@@ -257,19 +483,43 @@ func applyCommand(table *Table, cmd string) error {
 			return fmt.Errorf("must get at least 2 destinations for consistent hashing route '%s'", key)
 		}
 
-		prefix, sub, regex, err := readRouteOpts(s)
+		matcher, err := readRouteOpts(s)
+		if err != nil {
+			return err
+		}
+		destinations, err := readDestinations(inputs[1:], batch.table, false)
+		if err != nil {
+			return err
+		}
+		route, err := NewRouteConsistentHashing(key, *matcher, destinations)
+		if err != nil {
+			return err
+		}
+		batch.AddRoute(route)
+	} else if t.Token == addRouteRoundRobin || t.Token == addRouteWeighted {
+		split := strings.Split(string(t.Value), " ")
+		key := split[2]
+		if len(inputs) < 2 {
+			return fmt.Errorf("must get at least 1 destination for route '%s'", key)
+		}
+
+		matcher, err := readRouteOpts(s)
 		if err != nil {
 			return err
 		}
-		destinations, err := readDestinations(inputs[1:], table, false)
+		destinations, err := readDestinations(inputs[1:], batch.table, false)
 		if err != nil {
 			return err
 		}
-		route, err := NewRouteConsistentHashing(key, prefix, sub, regex, destinations)
+		newRoute := NewRouteWeighted
+		if t.Token == addRouteRoundRobin {
+			newRoute = NewRouteRoundRobin
+		}
+		route, err := newRoute(key, *matcher, destinations)
 		if err != nil {
 			return err
 		}
-		table.AddRoute(route)
+		batch.AddRoute(route)
 	} else if t.Token == addDest {
 		//split := strings.Split(string(t.Value), " ")
 		//key := split[2]
@@ -295,7 +545,7 @@ func applyCommand(table *Table, cmd string) error {
 			opts[opt[0]] = opt[1]
 		}
 
-		return table.UpdateDestination(key, index, opts)
+		return batch.UpdateDestination(key, index, opts)
 	} else if t.Token == modRoute {
 		split := strings.Split(string(t.Value), " ")
 		if len(split) < 3 {
@@ -311,7 +561,7 @@ func applyCommand(table *Table, cmd string) error {
 			opts[opt[0]] = opt[1]
 		}
 
-		return table.UpdateRoute(key, opts)
+		return batch.UpdateRoute(key, opts)
 	} else {
 		return fmt.Errorf("unrecognized command '%s'", t.Value)
 	}
@@ -322,7 +572,11 @@ func applyCommand(table *Table, cmd string) error {
 	return nil
 }
 
-func readRouteOpts(s *toki.Scanner) (prefix, sub, regex string, err error) {
+// readRouteOpts reads the matching options of a route. "or" starts an alternative,
+// e.g. prefix=stats. notsub=.test. or prefix=collectd.
+func readRouteOpts(s *toki.Scanner) (*Matcher, error) {
+	matcher := new(Matcher)
+	clause := matcher
 	for {
 		t := s.Next()
 		//spew.Dump(t.Token)
@@ -331,24 +585,22 @@ func readRouteOpts(s *toki.Scanner) (prefix, sub, regex string, err error) {
 			break
 		}
 		if t.Token == toki.Error {
-			return "", "", "", errors.New("read the error token instead of one i recognize")
+			return nil, errors.New("read the error token instead of one i recognize")
+		}
+		if t.Token == word && string(t.Value) == "or" {
+			clause = new(Matcher)
+			matcher.Or = append(matcher.Or, clause)
 		}
 		if t.Token == opt {
 			//fmt.Println("yet")
-			switch string(t.Value) {
-			case "prefix=":
-				val := s.Next()
-				prefix = string(val.Value)
-			case "sub=":
-				val := s.Next()
-				sub = string(val.Value)
-			case "regex=":
-				val := s.Next()
-				regex = string(val.Value)
-			default:
-				return "", "", "", fmt.Errorf("unrecognized option '%s'", t.Value)
+			val := s.Next()
+			if !clause.setOpt(strings.TrimSuffix(string(t.Value), "="), string(val.Value)) {
+				return nil, fmt.Errorf("unrecognized option '%s'", t.Value)
 			}
 		}
 	}
-	return
+	if err := matcher.compile(); err != nil {
+		return nil, err
+	}
+	return matcher, nil
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+)
+
+var errLineTooLong = errors.New("line exceeds max_line_length")
+
+// connLimits enforces the max_conns and max_conns_per_ip limits of a listener,
+// and tracks its active connections
+type connLimits struct {
+	sync.Mutex
+	maxConns int // 0 for unlimited
+	maxPerIP int // 0 for unlimited
+	numConns int
+	perIP    map[string]int
+
+	numActive         metrics.Gauge
+	numRejectMaxConns metrics.Counter
+	numRejectMaxPerIP metrics.Counter
+}
+
+func newConnLimits(listener string, maxConns, maxPerIP int) *connLimits {
+	return &connLimits{
+		maxConns:          maxConns,
+		maxPerIP:          maxPerIP,
+		perIP:             make(map[string]int),
+		numActive:         Gauge("listener=" + listener + ".unit=Conn.what=numActive"),
+		numRejectMaxConns: Counter("listener=" + listener + ".unit=Conn.action=reject.reason=max_conns"),
+		numRejectMaxPerIP: Counter("listener=" + listener + ".unit=Conn.action=reject.reason=max_conns_per_ip"),
+	}
+}
+
+func remoteIP(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
+}
+
+// acquire returns whether the conn is within the limits. if so, it must be released when done.
+func (l *connLimits) acquire(c net.Conn) bool {
+	ip := remoteIP(c)
+	l.Lock()
+	defer l.Unlock()
+	if l.maxConns > 0 && l.numConns >= l.maxConns {
+		l.numRejectMaxConns.Inc(1)
+		return false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		l.numRejectMaxPerIP.Inc(1)
+		return false
+	}
+	l.numConns++
+	l.perIP[ip]++
+	l.numActive.Update(int64(l.numConns))
+	return true
+}
+
+func (l *connLimits) release(c net.Conn) {
+	ip := remoteIP(c)
+	l.Lock()
+	defer l.Unlock()
+	l.numConns--
+	l.perIP[ip]--
+	if l.perIP[ip] == 0 {
+		delete(l.perIP, ip)
+	}
+	l.numActive.Update(int64(l.numConns))
+}
+
+// newLineReader returns a reader that can hold lines up to maxLen bytes, plus their line ending
+func newLineReader(r io.Reader, maxLen int) *bufio.Reader {
+	return bufio.NewReaderSize(r, maxLen+2)
+}
+
+// readLine returns the next line without its line ending. the line is only valid until the next read.
+// lines longer than maxLen are discarded, in which case we return the start of it, along with errLineTooLong.
+func readLine(r *bufio.Reader, maxLen int) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		start := append([]byte(nil), line[:maxLen]...)
+		for err == bufio.ErrBufferFull {
+			_, err = r.ReadSlice('\n')
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return start, errLineTooLong
+	}
+	if len(line) == 0 {
+		return nil, err
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	// the last line may lack a line ending. the EOF will come with the next read
+	line = bytes.TrimSuffix(line, newLine)
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(line) > maxLen {
+		return line[:maxLen], errLineTooLong
+	}
+	return line, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+// addrConn is a conn that appears to come from the given address
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newAddrConn(ip string) net.Conn {
+	return addrConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+}
+
+func TestConnLimits(t *testing.T) {
+	l := newConnLimits("test", 3, 2)
+	a1, a2, a3 := newAddrConn("10.0.0.1"), newAddrConn("10.0.0.1"), newAddrConn("10.0.0.1")
+	b1, b2 := newAddrConn("10.0.0.2"), newAddrConn("10.0.0.2")
+	assert.Equal(t, true, l.acquire(a1))
+	assert.Equal(t, true, l.acquire(a2))
+	assert.Equal(t, false, l.acquire(a3)) // per ip limit
+	assert.Equal(t, true, l.acquire(b1))
+	assert.Equal(t, false, l.acquire(b2)) // total limit
+	assert.Equal(t, int64(3), l.numActive.Value())
+	l.release(a1)
+	assert.Equal(t, true, l.acquire(a3))
+	assert.Equal(t, int64(1), l.numRejectMaxPerIP.Count())
+	assert.Equal(t, int64(1), l.numRejectMaxConns.Count())
+}
+
+func TestReadLine(t *testing.T) {
+	input := "a.b 1 1\nthis.line.is.too.long 1 1\nc 2 2\r\nlast"
+	r := newLineReader(strings.NewReader(input), 10)
+	expect := []struct {
+		line string
+		err  error
+	}{
+		{"a.b 1 1", nil},
+		{"this.line.", errLineTooLong},
+		{"c 2 2", nil},
+		{"last", nil},
+		{"", io.EOF},
+	}
+	for i, e := range expect {
+		line, err := readLine(r, 10)
+		if string(line) != e.line || err != e.err {
+			t.Fatalf("line %d: expected %q, %v. got %q, %v", i, e.line, e.err, line, err)
+		}
+	}
+}
+
+func TestReadLineTooLongWithinBuffer(t *testing.T) {
+	// fits in the buffer, together with the line ending, but is still too long
+	r := bufio.NewReaderSize(strings.NewReader("abcdefghijk\n"), 16)
+	line, err := readLine(r, 10)
+	assert.Equal(t, errLineTooLong, err)
+	assert.Equal(t, "abcdefghij", string(line))
+}
+
+func TestIdleTimeout(t *testing.T) {
+	config := initIngest()
+	config.Max_line_length = 4096
+	config.idleTimeout = 50 * time.Millisecond
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan bool)
+	go func() {
+		handle(server, config)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the idle conn to be closed")
+	}
+}
@@ -2,23 +2,42 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"regexp"
+	"strings"
+
+	"github.com/graphite-ng/carbon-relay-ng/glob"
 )
 
+// Matcher matches metrics that satisfy all of its conditions, or any of its alternatives.
+// a matcher without conditions or alternatives matches everything.
 type Matcher struct {
-	Prefix string `json:"prefix,omitempty"`
-	Sub    string `json:"substring,omitempty"`
-	Regex  string `json:"regex,omitempty"`
+	Prefix    string     `json:"prefix,omitempty"`
+	Sub       string     `json:"substring,omitempty"`
+	Regex     string     `json:"regex,omitempty"`
+	Glob      string     `json:"glob,omitempty"` // graphite glob pattern, matched against the metric name only
+	NotPrefix string     `json:"notprefix,omitempty"`
+	NotSub    string     `json:"notsubstring,omitempty"`
+	NotRegex  string     `json:"notregex,omitempty"`
+	Or        []*Matcher `json:"or,omitempty"` // alternatives, OR-ed with the conditions above
+	// for blacklist entries: how many metrics they dropped and when they last did (unix timestamp). only set in snapshots
+	Hits    int64 `json:"hits,omitempty"`
+	LastHit int64 `json:"lastHit,omitempty"`
+	hits    *ruleHits
 	// internal represenation for performance optimalization
-	prefix, substring []byte
-	regex             *regexp.Regexp // compiled version of Regex
+	prefix, substring       []byte
+	notPrefix, notSubstring []byte
+	regex                   *regexp.Regexp // compiled version of Regex
+	notRegex                *regexp.Regexp // compiled version of NotRegex
+	glob                    *glob.Glob     // compiled version of Glob
 }
 
-func NewMatcher(prefix, sub, regex string) (*Matcher, error) {
+func NewMatcher(prefix, sub, regex, glob string) (*Matcher, error) {
 	match := new(Matcher)
 	match.Prefix = prefix
 	match.Sub = sub
 	match.Regex = regex
+	match.Glob = glob
 	err := match.updateInternals()
 	if err != nil {
 		return nil, err
@@ -26,9 +45,13 @@ func NewMatcher(prefix, sub, regex string) (*Matcher, error) {
 	return match, nil
 }
 
+// updateInternals compiles the conditions of the matcher itself. see compile for the alternatives.
 func (m *Matcher) updateInternals() error {
 	m.prefix = []byte(m.Prefix)
 	m.substring = []byte(m.Sub)
+	m.notPrefix = []byte(m.NotPrefix)
+	m.notSubstring = []byte(m.NotSub)
+	m.regex = nil
 	if len(m.Regex) > 0 {
 		regexObj, err := regexp.Compile(m.Regex)
 		if err != nil {
@@ -36,18 +59,143 @@ func (m *Matcher) updateInternals() error {
 		}
 		m.regex = regexObj
 	}
+	m.notRegex = nil
+	if len(m.NotRegex) > 0 {
+		regexObj, err := regexp.Compile(m.NotRegex)
+		if err != nil {
+			return err
+		}
+		m.notRegex = regexObj
+	}
+	m.glob = nil
+	if len(m.Glob) > 0 {
+		globObj, err := glob.Compile(m.Glob)
+		if err != nil {
+			return err
+		}
+		m.glob = globObj
+	}
 	return nil
 }
 
+// compile updates the internals of the matcher and all of its alternatives,
+// e.g. after it was decoded from json or read from a command.
+// it fails on empty alternatives, as they would match everything.
+func (m *Matcher) compile() error {
+	if err := m.updateInternals(); err != nil {
+		return err
+	}
+	for _, alt := range m.Or {
+		if alt == nil || alt.Empty() {
+			return errors.New("empty alternative: 'or' must be followed by matching options")
+		}
+		if err := alt.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setOpt sets the condition with the given option name, as used in commands (prefix, sub, regex, glob, notprefix, notsub, notregex)
+// it returns false if there is no such option. the internals still need to be updated afterwards.
+func (m *Matcher) setOpt(name, val string) bool {
+	switch name {
+	case "prefix":
+		m.Prefix = val
+	case "sub":
+		m.Sub = val
+	case "regex":
+		m.Regex = val
+	case "glob":
+		m.Glob = val
+	case "notprefix":
+		m.NotPrefix = val
+	case "notsub":
+		m.NotSub = val
+	case "notregex":
+		m.NotRegex = val
+	default:
+		return false
+	}
+	return true
+}
+
+// hasConditions returns whether the matcher has any conditions of its own
+func (m *Matcher) hasConditions() bool {
+	return m.Prefix != "" || m.Sub != "" || m.Regex != "" || m.Glob != "" || m.NotPrefix != "" || m.NotSub != "" || m.NotRegex != ""
+}
+
+// Empty returns whether the matcher has no conditions and no alternatives, i.e. matches everything
+func (m *Matcher) Empty() bool {
+	return !m.hasConditions() && len(m.Or) == 0
+}
+
 func (m *Matcher) Match(s []byte) bool {
+	// with alternatives, a matcher without conditions of its own only matches what the alternatives match
+	if (len(m.Or) == 0 || m.hasConditions()) && m.matchConditions(s) {
+		return true
+	}
+	for _, alt := range m.Or {
+		if alt.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) matchConditions(s []byte) bool {
 	if len(m.prefix) > 0 && !bytes.HasPrefix(s, m.prefix) {
 		return false
 	}
 	if len(m.substring) > 0 && !bytes.Contains(s, m.substring) {
 		return false
 	}
+	if len(m.notPrefix) > 0 && bytes.HasPrefix(s, m.notPrefix) {
+		return false
+	}
+	if len(m.notSubstring) > 0 && bytes.Contains(s, m.notSubstring) {
+		return false
+	}
 	if m.regex != nil && !m.regex.Match(s) {
 		return false
 	}
+	if m.notRegex != nil && m.notRegex.Match(s) {
+		return false
+	}
+	if m.glob != nil {
+		name := s
+		if i := bytes.IndexByte(s, ' '); i >= 0 {
+			name = s[:i]
+		}
+		if !m.glob.Match(name) {
+			return false
+		}
+	}
 	return true
 }
+
+// String returns the matcher in the syntax of the route and destination options, like "prefix=foo notsub=bar or prefix=baz"
+func (m *Matcher) String() string {
+	var clauses []string
+	if m.hasConditions() {
+		var opts []string
+		for _, opt := range []struct{ name, val string }{
+			{"prefix", m.Prefix},
+			{"sub", m.Sub},
+			{"regex", m.Regex},
+			{"glob", m.Glob},
+			{"notprefix", m.NotPrefix},
+			{"notsub", m.NotSub},
+			{"notregex", m.NotRegex},
+		} {
+			if opt.val != "" {
+				opts = append(opts, opt.name+"="+opt.val)
+			}
+		}
+		clauses = append(clauses, strings.Join(opts, " "))
+	}
+	for _, alt := range m.Or {
+		clauses = append(clauses, alt.String())
+	}
+	return strings.Join(clauses, " or ")
+}
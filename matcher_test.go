@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+func TestMatcherNegations(t *testing.T) {
+	m := &Matcher{Prefix: "stats.", NotSub: ".test.", NotPrefix: "stats.internal.", NotRegex: "\\.tmp[0-9]+ "}
+	if err := m.compile(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"stats.web.requests 1 1":      true,
+		"stats.web.test.requests 1 1": false,
+		"stats.internal.requests 1 1": false,
+		"stats.web.tmp12 1 1":         false,
+		"collectd.web.requests 1 1":   false,
+	}
+	for line, exp := range cases {
+		assert.Equal(t, exp, m.Match([]byte(line)), line)
+	}
+	if err := (&Matcher{NotRegex: "("}).compile(); err == nil {
+		t.Fatal("expected an error for an invalid notregex")
+	}
+}
+
+func TestMatcherOr(t *testing.T) {
+	m := &Matcher{Prefix: "stats.", NotSub: ".test.", Or: []*Matcher{{Prefix: "collectd."}, {Glob: "app.*.requests"}}}
+	if err := m.compile(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"stats.web.requests 1 1":      true,
+		"stats.web.test.requests 1 1": false,
+		"collectd.test.cpu 1 1":       true,
+		"app.web1.requests 1 1":       true,
+		"app.web1.errors 1 1":         false,
+	}
+	for line, exp := range cases {
+		assert.Equal(t, exp, m.Match([]byte(line)), line)
+	}
+	assert.Equal(t, "prefix=stats. notsub=.test. or prefix=collectd. or glob=app.*.requests", m.String())
+
+	// without conditions of its own, only the alternatives count
+	m = &Matcher{Or: []*Matcher{{Prefix: "a."}, {Prefix: "b."}}}
+	if err := m.compile(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, true, m.Match([]byte("b.foo 1 1")))
+	assert.Equal(t, false, m.Match([]byte("c.foo 1 1")))
+	assert.Equal(t, "prefix=a. or prefix=b.", m.String())
+
+	// an empty matcher still matches everything
+	assert.Equal(t, true, new(Matcher).Match([]byte("c.foo 1 1")))
+}
+
+func TestMatcherJSON(t *testing.T) {
+	var m Matcher
+	err := json.Unmarshal([]byte(`{"prefix": "stats.", "notsubstring": ".test.", "or": [{"prefix": "collectd."}]}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.compile(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, false, m.Match([]byte("stats.test.foo 1 1")))
+	assert.Equal(t, true, m.Match([]byte("collectd.test.foo 1 1")))
+	out, _ := json.Marshal(m)
+	assert.Equal(t, `{"prefix":"stats.","notsubstring":".test.","or":[{"prefix":"collectd."}]}`, string(out))
+}
+
+func TestMatcherCommands(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch main prefix=stats. notsub=.test. or prefix=collectd.  127.0.0.1:0 prometheus=true")
+	defer table.ShutdownOrFatal(t)
+	written := func() string {
+		return promMetrics(t, table, "main")
+	}
+	for _, line := range []string{"stats.web.requests", "stats.web.test.requests", "collectd.web.cpu", "other.web.cpu"} {
+		table.Dispatch([]byte(line + " 1 1"))
+	}
+	out := written()
+	assert.Equal(t, true, strings.Contains(out, "stats_web_requests"))
+	assert.Equal(t, false, strings.Contains(out, "stats_web_test_requests"))
+	assert.Equal(t, true, strings.Contains(out, "collectd_web_cpu"))
+	assert.Equal(t, false, strings.Contains(out, "other_web_cpu"))
+	assert.Equal(t, true, strings.Contains(table.Print(), "prefix=stats. notsub=.test. or prefix=collectd."))
+
+	// modRoute changes the first set of conditions, and keeps the alternatives
+	assert.Equal(t, nil, applyCommand(table, "modRoute main notsub= notprefix=stats.web."))
+	m := table.Snapshot().Routes[0].Matcher
+	assert.Equal(t, "prefix=stats. notprefix=stats.web. or prefix=collectd.", m.String())
+	if applyCommand(table, "modRoute main notregex=(") == nil {
+		t.Fatal("expected an error for an invalid notregex")
+	}
+
+	assert.Equal(t, nil, applyCommand(table, "addBlack prefix stats. notsub .keep. or sub secret"))
+	black := table.Snapshot().Blacklist[0]
+	assert.Equal(t, "prefix=stats. notsub=.keep. or sub=secret", black.String())
+	assert.Equal(t, true, black.Match([]byte("collectd.secret 1 1")))
+	assert.Equal(t, false, black.Match([]byte("stats.keep.x 1 1")))
+	if applyCommand(table, "addBlack prefix stats. notsub") == nil {
+		t.Fatal("expected an error for a pattern without a value")
+	}
+	if applyCommand(table, "addBlack prefix stats. nosuch foo") == nil {
+		t.Fatal("expected an error for an invalid match type")
+	}
+	// an empty alternative would match everything
+	for _, cmd := range []string{"addBlack prefix foo or", "addBlack prefix foo or or sub bar", "addWhite prefix foo or"} {
+		if applyCommand(table, cmd) == nil {
+			t.Fatalf("expected an error for an empty alternative in %q", cmd)
+		}
+	}
+	if applyCommand(table, "addRoute sendAllMatch empty prefix=a. or  127.0.0.1:2099") == nil {
+		t.Fatal("expected an error for an empty route alternative")
+	}
+	if (&Matcher{Prefix: "foo", Or: []*Matcher{{}}}).compile() == nil {
+		t.Fatal("expected an error for an empty alternative from json")
+	}
+	assert.Equal(t, 1, len(table.Snapshot().Blacklist))
+
+	table2 := NewTableOrFatal(t, "", "addRoute sendAllMatch dests  127.0.0.1:2099 prefix=a. or prefix=b. notsub=c spool=false")
+	defer table2.ShutdownOrFatal(t)
+	dest := table2.Snapshot().Routes[0].Dests[0]
+	assert.Equal(t, "prefix=a. or prefix=b. notsub=c", dest.Matcher.String())
+	assert.Equal(t, nil, applyCommand(table2, "modDest dests 0 notsub=d"))
+	assert.Equal(t, "prefix=a. notsub=d or prefix=b. notsub=c", table2.Snapshot().Routes[0].Dests[0].Matcher.String())
+}
+
+func TestMatcherUndo(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch main prefix=stats.  127.0.0.1:0 prometheus=true")
+	defer table.ShutdownOrFatal(t)
+	openTestHistory(t, historyConfig{})
+	err := history.track(table, "telnet", "", "modRoute main notsub=.test.", func() error {
+		return applyCommand(table, "modRoute main notsub=.test.")
+	})
+	assert.Equal(t, nil, err)
+	entries := history.list(0)
+	assert.Equal(t, nil, history.undo(table, "telnet", "", entries[0].ID))
+	assert.Equal(t, "", table.Snapshot().Routes[0].Matcher.NotSub)
+}
+
+func TestMatcherUndoDelete(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addBlack prefix stats. notsub .keep. or sub secret")
+	defer table.ShutdownOrFatal(t)
+	openTestHistory(t, historyConfig{})
+	err := history.track(table, "telnet", "", "delBlack 0", func() error {
+		return table.DelBlacklist(0)
+	})
+	assert.Equal(t, nil, err)
+	entries := history.list(0)
+	assert.Equal(t, nil, history.undo(table, "telnet", "", entries[0].ID))
+	black := table.Snapshot().Blacklist
+	assert.Equal(t, 1, len(black))
+	assert.Equal(t, "prefix=stats. notsub=.keep. or sub=secret", black[0].String())
+	assert.Equal(t, true, black[0].Match([]byte("collectd.secret 1 1")))
+}
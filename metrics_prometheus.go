@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+)
+
+// exposes our own instrumentation in the prometheus text exposition format.
+// our metric keys are metrics2.0 (see metrics_wrapper.go), so rather than mangling
+// them into one long name, we turn the tags into labels:
+// the name is derived from the what and unit tags, target_type decides the metric type,
+// and all other tags (except service) become labels.
+
+var promQuantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+// promUnits maps our units to the base units prometheus names are supposed to end in
+var promUnits = map[string]string{
+	"B":      "bytes",
+	"Err":    "errors",
+	"Metric": "metrics",
+	"ns":     "nanoseconds",
+}
+
+type promFamily struct {
+	name    string
+	typ     string
+	samples []promPoint
+}
+
+// promPoint is a sample of a family, that gets rendered once the name of the family is final
+type promPoint struct {
+	suffix string // added to the name of the family, like _sum and _count for summaries
+	labels []string
+	extra  string
+	val    float64
+}
+
+// parseMetricKey reverses expandKey: it splits a key like
+// service_is_carbon-relay-ng.instance_is_foo.target_type_is_counter.unit_is_Metric.direction_is_in
+// into its tags, in order.
+func parseMetricKey(key string) (tags [][2]string) {
+	for _, node := range strings.Split(key, ".") {
+		kv := strings.SplitN(node, "_is_", 2)
+		if len(kv) != 2 {
+			// a tag value with a dot in it. glue it back together.
+			if len(tags) > 0 {
+				tags[len(tags)-1][1] += "." + node
+			}
+			continue
+		}
+		tags = append(tags, [2]string{kv[0], kv[1]})
+	}
+	return tags
+}
+
+// toSnakeCase converts names like numBuffered into num_buffered
+func toSnakeCase(s string) string {
+	var out bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' {
+			if i > 0 && s[i-1] >= 'a' && s[i-1] <= 'z' {
+				out.WriteByte('_')
+			}
+			ch += 'a' - 'A'
+		}
+		out.WriteByte(ch)
+	}
+	return out.String()
+}
+
+// promNameAndLabels derives the prometheus name, type and rendered label pairs from a metrics2.0 key
+func promNameAndLabels(key string) (name, typ string, labels []string) {
+	var what, unit string
+	typ = "gauge"
+	for _, tag := range parseMetricKey(key) {
+		switch tag[0] {
+		case "service":
+		case "target_type":
+			if tag[1] == "counter" {
+				typ = "counter"
+			}
+		case "what":
+			what = tag[1]
+		case "unit":
+			unit = tag[1]
+		default:
+			labels = append(labels, sanitizePromLabelName(tag[0])+"="+promLabelValue(tag[1]))
+		}
+	}
+	if u, ok := promUnits[unit]; ok {
+		unit = u
+	}
+	parts := []string{sanitizePromName(service)}
+	if what != "" {
+		parts = append(parts, toSnakeCase(what))
+	}
+	if unit != "" {
+		parts = append(parts, toSnakeCase(unit))
+	}
+	if typ == "counter" {
+		parts = append(parts, "total")
+	}
+	return sanitizePromName(strings.Join(parts, "_")), typ, labels
+}
+
+func promLine(name string, labels []string, extra string, val float64) string {
+	if extra != "" {
+		labels = append(labels[:len(labels):len(labels)], extra)
+	}
+	set := ""
+	if len(labels) > 0 {
+		set = "{" + strings.Join(labels, ",") + "}"
+	}
+	return fmt.Sprintf("%s%s %s", name, set, strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+// WritePrometheus writes all metrics in the registry to w in the prometheus text exposition format.
+// timers and histograms are exposed as summaries.
+// if metrics of different types end up with the same name, all but one of them get the type as suffix,
+// as a family can only have one type.
+func WritePrometheus(w io.Writer, r metrics.Registry) {
+	families := make(map[string]*promFamily) // by "<name> <type>"
+	add := func(name, typ string, points ...promPoint) {
+		f, ok := families[name+" "+typ]
+		if !ok {
+			f = &promFamily{name: name, typ: typ}
+			families[name+" "+typ] = f
+		}
+		f.samples = append(f.samples, points...)
+	}
+	summary := func(name string, labels []string, count, sum int64, ps []float64) {
+		points := make([]promPoint, 0, len(ps)+2)
+		for i, q := range promQuantiles {
+			points = append(points, promPoint{"", labels, "quantile=" + promLabelValue(strconv.FormatFloat(q, 'g', -1, 64)), ps[i]})
+		}
+		points = append(points, promPoint{"_sum", labels, "", float64(sum)})
+		points = append(points, promPoint{"_count", labels, "", float64(count)})
+		add(name, "summary", points...)
+	}
+
+	r.Each(func(key string, i interface{}) {
+		name, typ, labels := promNameAndLabels(key)
+		switch m := i.(type) {
+		case metrics.Counter:
+			add(name, typ, promPoint{"", labels, "", float64(m.Count())})
+		case metrics.Gauge:
+			add(name, "gauge", promPoint{"", labels, "", float64(m.Value())})
+		case metrics.Timer:
+			t := m.Snapshot()
+			summary(name, labels, t.Count(), t.Sum(), t.Percentiles(promQuantiles))
+		case metrics.Histogram:
+			h := m.Snapshot()
+			summary(name, labels, h.Count(), h.Sum(), h.Percentiles(promQuantiles))
+		}
+	})
+
+	// on a clash, the type that sorts first keeps the name
+	keys := make([]string, 0, len(families))
+	for key := range families {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	byName := make(map[string]*promFamily)
+	prev := ""
+	for _, key := range keys {
+		f := families[key]
+		if f.name == prev {
+			f.name += "_" + f.typ
+		} else {
+			prev = f.name
+		}
+		byName[f.name] = f
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := byName[name]
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ)
+		samples := make([]string, len(f.samples))
+		for i, p := range f.samples {
+			samples[i] = promLine(name+p.suffix, p.labels, p.extra, p.val)
+		}
+		if f.typ != "summary" {
+			// summaries are kept in their natural quantile/sum/count order
+			sort.Strings(samples)
+		}
+		for _, s := range samples {
+			fmt.Fprintln(w, s)
+		}
+	}
+}
+
+func promMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	WritePrometheus(&buf, metrics.DefaultRegistry)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
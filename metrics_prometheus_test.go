@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+func TestPromNameAndLabels(t *testing.T) {
+	name, typ, labels := promNameAndLabels(expandKey("target_type=counter.dest=127_0_0_1_2005.unit=Metric.action=drop.reason=slow_conn"))
+	assert.Equal(t, "carbon_relay_ng_metrics_total", name)
+	assert.Equal(t, "counter", typ)
+	assert.Equal(t, []string{`instance="test"`, `dest="127_0_0_1_2005"`, `action="drop"`, `reason="slow_conn"`}, labels)
+
+	name, typ, _ = promNameAndLabels(expandKey("target_type=gauge.dest=foo.unit=Metric.what=numBuffered"))
+	assert.Equal(t, "carbon_relay_ng_num_buffered_metrics", name)
+	assert.Equal(t, "gauge", typ)
+}
+
+func TestWritePrometheus(t *testing.T) {
+	r := metrics.NewRegistry()
+	in := metrics.NewCounter()
+	in.Inc(3)
+	r.Register(expandKey("target_type=counter.unit=Metric.direction=in"), in)
+	drop := metrics.NewCounter()
+	drop.Inc(2)
+	r.Register(expandKey("target_type=counter.dest=a.unit=Metric.action=drop.reason=slow_conn"), drop)
+	g := metrics.NewGauge()
+	g.Update(5)
+	r.Register(expandKey("target_type=gauge.dest=a.unit=Metric.what=numBuffered"), g)
+
+	var buf bytes.Buffer
+	WritePrometheus(&buf, r)
+	expected := `# TYPE carbon_relay_ng_metrics_total counter
+carbon_relay_ng_metrics_total{instance="test",dest="a",action="drop",reason="slow_conn"} 2
+carbon_relay_ng_metrics_total{instance="test",direction="in"} 3
+# TYPE carbon_relay_ng_num_buffered_metrics gauge
+carbon_relay_ng_num_buffered_metrics{instance="test",dest="a"} 5
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestWritePrometheusClashes(t *testing.T) {
+	r := metrics.NewRegistry()
+	g := metrics.NewGauge()
+	g.Update(5)
+	r.Register(expandKey("target_type=gauge.unit=ns.what=duration.host:port=a"), g)
+	timer := metrics.NewTimer()
+	timer.Update(10)
+	r.Register(expandKey("target_type=gauge.unit=ns.what=duration.host:port=b"), timer)
+
+	var buf bytes.Buffer
+	WritePrometheus(&buf, r)
+	out := buf.String()
+	// a family can only have one type, and label names can't have colons
+	assert.Equal(t, true, strings.Contains(out, "# TYPE carbon_relay_ng_duration_nanoseconds gauge\n"))
+	assert.Equal(t, true, strings.Contains(out, `carbon_relay_ng_duration_nanoseconds{instance="test",host_port="a"} 5`))
+	assert.Equal(t, true, strings.Contains(out, "# TYPE carbon_relay_ng_duration_nanoseconds_summary summary\n"))
+	assert.Equal(t, true, strings.Contains(out, `carbon_relay_ng_duration_nanoseconds_summary_count{instance="test",host_port="b"} 1`))
+}
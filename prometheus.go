@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+)
+
+// a prometheus destination doesn't forward anything. it keeps the latest value of every
+// series it receives in memory and serves them on /metrics in the prometheus text
+// exposition format, so prometheus can scrape (a subset of) the relayed metrics
+// directly, without running graphite_exporter. as it's a destination, a route can
+// send to carbon and expose to prometheus at the same time.
+
+type promLabel struct {
+	name  string
+	value string // may refer to captured nodes via $1, $2, etc
+}
+
+// promMapping converts graphite names into prometheus names and labels.
+// the pattern is a graphite name in which a "*" node matches (and captures) exactly one node.
+// the template looks like cpu{host="$1",type="$2"} where $1, $2, etc refer to the captured nodes.
+type promMapping struct {
+	Pattern  string `json:"pattern"`
+	Template string `json:"template"`
+	nodes    [][]byte
+	name     string
+	labels   []promLabel
+}
+
+var promDot = []byte(".")
+var promStar = []byte("*")
+
+func NewPromMapping(pattern, template string) (*promMapping, error) {
+	if pattern == "" {
+		return nil, errors.New("empty mapping pattern")
+	}
+	m := &promMapping{
+		Pattern:  pattern,
+		Template: template,
+		nodes:    bytes.Split([]byte(pattern), promDot),
+	}
+	name := template
+	if pos := strings.IndexByte(template, '{'); pos >= 0 {
+		if !strings.HasSuffix(template, "}") {
+			return nil, fmt.Errorf("mapping template '%s' has no closing }", template)
+		}
+		name = template[:pos]
+		labels := template[pos+1 : len(template)-1]
+		if labels != "" {
+			for _, pair := range strings.Split(labels, ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 || len(kv[1]) < 2 || kv[1][0] != '"' || kv[1][len(kv[1])-1] != '"' {
+					return nil, fmt.Errorf("bad label '%s' in mapping template '%s'", pair, template)
+				}
+				if !validPromLabelName(kv[0]) {
+					return nil, fmt.Errorf("invalid label name '%s' in mapping template '%s'", kv[0], template)
+				}
+				m.labels = append(m.labels, promLabel{kv[0], kv[1][1 : len(kv[1])-1]})
+			}
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("mapping template '%s' has no metric name", template)
+	}
+	m.name = name
+	return m, nil
+}
+
+// Apply returns the prometheus name and the rendered label set for the given graphite name,
+// or ok == false if the name doesn't match the pattern.
+func (m *promMapping) Apply(name []byte) (promName, labels string, ok bool) {
+	nodes := bytes.Split(name, promDot)
+	if len(nodes) != len(m.nodes) {
+		return "", "", false
+	}
+	var captures []string
+	for i, node := range m.nodes {
+		if bytes.Equal(node, promStar) {
+			captures = append(captures, string(nodes[i]))
+		} else if !bytes.Equal(node, nodes[i]) {
+			return "", "", false
+		}
+	}
+	pairs := make([]string, len(m.labels))
+	for i, l := range m.labels {
+		pairs[i] = l.name + "=" + promLabelValue(expandCaptures(l.value, captures))
+	}
+	if len(pairs) > 0 {
+		labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+	return sanitizePromName(expandCaptures(m.name, captures)), labels, true
+}
+
+// expandCaptures replaces $1, $2, etc with the corresponding captured node.
+// references to nodes that weren't captured are left as is.
+func expandCaptures(tpl string, captures []string) string {
+	if strings.IndexByte(tpl, '$') < 0 {
+		return tpl
+	}
+	var out bytes.Buffer
+	for i := 0; i < len(tpl); i++ {
+		if tpl[i] != '$' {
+			out.WriteByte(tpl[i])
+			continue
+		}
+		j := i + 1
+		for j < len(tpl) && tpl[j] >= '0' && tpl[j] <= '9' {
+			j++
+		}
+		n, err := strconv.Atoi(tpl[i+1 : j])
+		if err != nil || n < 1 || n > len(captures) {
+			out.WriteByte(tpl[i])
+			continue
+		}
+		out.WriteString(captures[n-1])
+		i = j - 1
+	}
+	return out.String()
+}
+
+func validPromLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || (i > 0 && ch >= '0' && ch <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// promLabelValue quotes a label value for the text exposition format,
+// which only escapes backslashes, double quotes and newlines
+func promLabelValue(val string) string {
+	return `"` + promLabelEscaper.Replace(val) + `"`
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sanitizePromLabelName replaces every character that is not allowed in a prometheus label name with an underscore.
+// unlike metric names, label names can't have colons.
+func sanitizePromLabelName(name string) string {
+	return strings.Replace(sanitizePromName(name), ":", "_", -1)
+}
+
+// sanitizePromName replaces every character that is not allowed in a prometheus metric name with an underscore
+func sanitizePromName(name string) string {
+	buf := []byte(name)
+	for i, ch := range buf {
+		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' || ch == ':' || (i > 0 && ch >= '0' && ch <= '9')) {
+			buf[i] = '_'
+		}
+	}
+	return string(buf)
+}
+
+type promSample struct {
+	name   string
+	labels string
+	value  float64
+	seen   time.Time
+}
+
+// PrometheusExport holds the settings of a prometheus destination, for the snapshot
+type PrometheusExport struct {
+	Expire   int            `json:"expire"` // in seconds
+	Mappings []*promMapping `json:"mappings"`
+}
+
+// promExporter keeps the series of a prometheus destination
+type promExporter struct {
+	expire   time.Duration
+	mappings []*promMapping
+
+	seriesLock sync.Mutex
+	series     map[string]*promSample
+
+	numUnmapped metrics.Counter
+	numExpired  metrics.Counter
+	numSeries   metrics.Gauge
+}
+
+// NewPrometheusDestination creates a destination which serves the latest value of all series on http://addr/metrics
+// series that haven't been updated for the duration of expire are removed.
+// metrics that don't match any mapping are exported under their sanitized graphite name.
+// like any dest, it still needs to be told to run via Run(), which is when it starts listening.
+// if it can't listen, it keeps trying every periodReConn.
+func NewPrometheusDestination(matcher Matcher, addr string, expire time.Duration, mappings []*promMapping, periodReConn time.Duration) (*Destination, error) {
+	if expire < time.Second {
+		return nil, errors.New("expire must be at least a second")
+	}
+	cleanAddr := addrToPath(addr)
+	dest := &Destination{
+		Matcher:      matcher,
+		Addr:         addr,
+		OnFull:       "drop",
+		Connections:  1,
+		Spread:       "hash",
+		BufSize:      conn_in_buffer,
+		WriteBuf:     bufio_buffer_size,
+		Weight:       1,
+		Prometheus:   &PrometheusExport{int(expire / time.Second), mappings},
+		cleanAddr:    cleanAddr,
+		periodReConn: periodReConn,
+		prom: &promExporter{
+			expire:      expire,
+			mappings:    mappings,
+			series:      make(map[string]*promSample),
+			numUnmapped: Counter("dest=" + cleanAddr + ".unit=Metric.what=unmapped"),
+			numExpired:  Counter("dest=" + cleanAddr + ".unit=Metric.what=expired"),
+			numSeries:   Gauge("dest=" + cleanAddr + ".unit=Metric.what=series"),
+		},
+	}
+	dest.setMetrics()
+	return dest, nil
+}
+
+// checkPromDestOpts checks whether the options for Destination.Update apply to a prometheus dest
+func checkPromDestOpts(opts map[string]string) error {
+	for name := range opts {
+		switch name {
+		case "addr", "bufsize", "writebuf", "maxrate":
+			return fmt.Errorf("%s can't be changed for prometheus destinations", name)
+		}
+	}
+	return nil
+}
+
+// relayPrometheus is the relay loop of prometheus dests: it keeps the series up to date,
+// expires the stale ones, and (re)opens the listener they are served on.
+func (dest *Destination) relayPrometheus() {
+	p := dest.prom
+	// expire periodically, so we don't keep growing in between scrapes (or when nobody scrapes at all)
+	expireTicker := time.NewTicker(p.expire / 2)
+	defer expireTicker.Stop()
+	listenTicker := time.NewTicker(dest.periodReConn)
+	defer listenTicker.Stop()
+
+	var l net.Listener
+	stopped := make(chan net.Listener, 1) // there's at most one server at a time
+	listen := func() {
+		var err error
+		l, err = net.Listen("tcp", dest.Addr)
+		if err != nil {
+			log.Error("prometheus dest %v can't listen: %s\n", dest.Addr, err)
+			l = nil
+			return
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", p.serveMetrics)
+		dest.setOnline(true)
+		go func(l net.Listener) {
+			log.Notice("prometheus dest listening on %v", l.Addr())
+			err := http.Serve(l, mux)
+			log.Notice("prometheus dest stopped listening on %v: %s", l.Addr(), err)
+			stopped <- l
+		}(l)
+	}
+
+	listen()
+	for {
+		select {
+		case buf := <-dest.in:
+			p.process(buf)
+		case now := <-expireTicker.C:
+			p.expireBefore(now.Add(-p.expire))
+		case s := <-stopped:
+			if s == l {
+				l = nil
+				dest.setOnline(false)
+			}
+		case <-listenTicker.C:
+			if l == nil {
+				listen()
+			}
+		case <-dest.flush:
+			dest.flushErr <- nil
+		case <-dest.drainReq:
+			// nothing is ever buffered: every metric is processed as soon as it comes in
+			dest.drainResp <- DrainStats{}
+		case <-dest.shutdown:
+			log.Notice("dest %v shutting down. closing listener\n", dest.Addr)
+			if l != nil {
+				l.Close()
+			}
+			dest.setOnline(false)
+			return
+		}
+	}
+}
+
+func (p *promExporter) process(buf []byte) {
+	dp, err := parseDataPoint(buf)
+	if err != nil {
+		log.Error("prometheus dest could not parse %s: %s\n", buf, err)
+		return
+	}
+	var name, labels string
+	mapped := false
+	for _, m := range p.mappings {
+		if name, labels, mapped = m.Apply([]byte(dp.Name)); mapped {
+			break
+		}
+	}
+	if !mapped {
+		p.numUnmapped.Inc(1)
+		name = sanitizePromName(dp.Name)
+	}
+	id := name + labels
+	p.seriesLock.Lock()
+	s, ok := p.series[id]
+	if !ok {
+		s = &promSample{name: name, labels: labels}
+		p.series[id] = s
+	}
+	s.value = dp.Val
+	s.seen = time.Now()
+	p.numSeries.Update(int64(len(p.series)))
+	p.seriesLock.Unlock()
+}
+
+func (p *promExporter) expireBefore(thresh time.Time) {
+	p.seriesLock.Lock()
+	for id, s := range p.series {
+		if s.seen.Before(thresh) {
+			delete(p.series, id)
+			p.numExpired.Inc(1)
+		}
+	}
+	p.numSeries.Update(int64(len(p.series)))
+	p.seriesLock.Unlock()
+}
+
+// WriteMetrics writes all non-stale series in the prometheus text exposition format,
+// grouped by metric name.
+func (p *promExporter) WriteMetrics(w *bytes.Buffer) {
+	thresh := time.Now().Add(-p.expire)
+	p.seriesLock.Lock()
+	samples := make([]promSample, 0, len(p.series))
+	for _, s := range p.series {
+		if s.seen.After(thresh) {
+			samples = append(samples, *s)
+		}
+	}
+	p.seriesLock.Unlock()
+
+	sort.Sort(promSamplesByName(samples))
+	for i, s := range samples {
+		if i == 0 || samples[i-1].name != s.name {
+			fmt.Fprintf(w, "# TYPE %s untyped\n", s.name)
+		}
+		fmt.Fprintf(w, "%s%s %s\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+func (p *promExporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	p.WriteMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+type promSamplesByName []promSample
+
+func (a promSamplesByName) Len() int      { return len(a) }
+func (a promSamplesByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a promSamplesByName) Less(i, j int) bool {
+	return a[i].name < a[j].name || (a[i].name == a[j].name && a[i].labels < a[j].labels)
+}
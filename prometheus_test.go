@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+func TestPromMappingApply(t *testing.T) {
+	m, err := NewPromMapping("servers.*.cpu.*", `cpu{host="$1",type="$2"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, labels, ok := m.Apply([]byte("servers.web-1.cpu.idle"))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "cpu", name)
+	assert.Equal(t, `{host="web-1",type="idle"}`, labels)
+
+	_, _, ok = m.Apply([]byte("servers.web-1.mem.free"))
+	assert.Equal(t, false, ok)
+	_, _, ok = m.Apply([]byte("servers.web-1.cpu.idle.extra"))
+	assert.Equal(t, false, ok)
+
+	m, err = NewPromMapping("app.*.requests", "app_$1_requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, labels, ok = m.Apply([]byte("app.login.requests"))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "app_login_requests", name)
+	assert.Equal(t, "", labels)
+}
+
+func TestPromMappingInvalid(t *testing.T) {
+	for _, template := range []string{`cpu{host="$1"`, `cpu{host=$1}`, `cpu{1host="$1"}`, `{host="$1"}`} {
+		if _, err := NewPromMapping("servers.*.cpu", template); err == nil {
+			t.Fatalf("expected error for template %s", template)
+		}
+	}
+}
+
+func TestSanitizePromName(t *testing.T) {
+	assert.Equal(t, "servers_web_1_cpu_idle", sanitizePromName("servers.web-1.cpu.idle"))
+	assert.Equal(t, "_xx", sanitizePromName("1xx"))
+}
+
+// promMetrics returns what the prometheus dests of the route serve, once they processed everything that was dispatched to them
+func promMetrics(t *testing.T, table *Table, key string) string {
+	route := table.GetRoute(key)
+	if err := route.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, dest := range route.(interface {
+		dests() []*Destination
+	}).dests() {
+		if dest.prom != nil {
+			dest.prom.WriteMetrics(&buf)
+		}
+	}
+	return buf.String()
+}
+
+func TestPrometheusWriteMetrics(t *testing.T) {
+	m, err := NewPromMapping("servers.*.cpu.*", `cpu{host="$1",type="$2"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := NewPrometheusDestination(Matcher{}, "127.0.0.1:0", time.Minute, []*promMapping{m}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prom := dest.prom
+	prom.process([]byte("servers.b.cpu.idle 3 1000000000"))
+	prom.process([]byte("servers.a.cpu.idle 1 1000000000"))
+	prom.process([]byte("servers.a.cpu.idle 2.5 1000000010"))
+	prom.process([]byte("other.metric 7 1000000000"))
+	prom.process([]byte("servers.a\"b\\c.cpu.idle 4 1000000000"))
+
+	var buf bytes.Buffer
+	prom.WriteMetrics(&buf)
+	expected := `# TYPE cpu untyped
+cpu{host="a",type="idle"} 2.5
+cpu{host="a\"b\\c",type="idle"} 4
+cpu{host="b",type="idle"} 3
+# TYPE other_metric untyped
+other_metric 7
+`
+	assert.Equal(t, expected, buf.String())
+
+	prom.expireBefore(time.Now().Add(time.Second))
+	buf.Reset()
+	prom.WriteMetrics(&buf)
+	assert.Equal(t, "", buf.String())
+}
+
+func TestPromLabelValue(t *testing.T) {
+	assert.Equal(t, `"a\\b\"c\nd	e"`, promLabelValue("a\\b\"c\nd\te"))
+}
+
+func TestPrometheusDest(t *testing.T) {
+	// the same route sends to carbon and serves the metrics to prometheus
+	table := NewTableOrFatal(t, "", `addRoute sendAllMatch main prefix=servers.  127.0.0.1:2099  127.0.0.1:2098 prometheus=true expire=60 map=servers.*.cpu.*:cpu{host="$1",type="$2"}`)
+	defer table.ShutdownOrFatal(t)
+	snap := table.Snapshot().Routes[0]
+	assert.Equal(t, "sendAllMatch", snap.Type)
+	assert.Equal(t, (*PrometheusExport)(nil), snap.Dests[0].Prometheus)
+	assert.Equal(t, 60, snap.Dests[1].Prometheus.Expire)
+	assert.Equal(t, "servers.*.cpu.*", snap.Dests[1].Prometheus.Mappings[0].Pattern)
+	assert.Equal(t, true, strings.Contains(table.Print(), "map servers.*.cpu.* -> cpu{"))
+
+	table.Dispatch([]byte("servers.web.cpu.idle 3 1000000000"))
+	expected := "# TYPE cpu untyped\ncpu{host=\"web\",type=\"idle\"} 3\n"
+	assert.Equal(t, expected, promMetrics(t, table, "main"))
+	var body []byte
+	for i := 0; i < 100 && len(body) == 0; i++ {
+		resp, err := http.Get("http://127.0.0.1:2098/metrics")
+		if err != nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		body, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	assert.Equal(t, expected, string(body))
+	assert.Equal(t, true, table.Snapshot().Routes[0].Dests[1].Online)
+
+	assert.Equal(t, nil, applyCommand(table, "modDest main 1 prefix=servers.web."))
+	for _, cmd := range []string{
+		"modDest main 1 addr=127.0.0.1:2097",
+		"addRoute sendAllMatch bad  127.0.0.1:0 prometheus=true spool=true",
+		"addRoute sendAllMatch bad  127.0.0.1:0 prometheus=true expire=0",
+		"addRoute sendAllMatch bad  127.0.0.1:0 prometheus=true map=servers.*.cpu",
+		"addRoute sendAllMatch bad  127.0.0.1:0 expire=60",
+	} {
+		if applyCommand(table, cmd) == nil {
+			t.Fatalf("expected an error for %q", cmd)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "time"
+
+// tokenBucket enforces a rate in events per second, allowing bursts of up to one second worth of events.
+// it is not safe for concurrent use.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(rate int64, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(rate)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	}
+	b.last = now
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+}
+
+// take consumes a token, if there is one
+func (b *tokenBucket) take(rate int64, now time.Time) bool {
+	b.refill(rate, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait returns how long until there is a token
+func (b *tokenBucket) wait(rate int64, now time.Time) time.Duration {
+	b.refill(rate, now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / float64(rate) * float64(time.Second))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type RouteConfig interface {
@@ -35,11 +36,16 @@ type Route interface {
 	Match(s []byte) bool
 	Snapshot() RouteSnapshot
 	Key() string
+	Run()
 	Flush() error
+	Drain(deadline time.Time) DrainStats
 	Shutdown() error
 	DelDestination(index int) error
 	UpdateDestination(index int, opts map[string]string) error
 	Update(opts map[string]string) error
+	UpdateMatcher(matcher Matcher)
+	matcher() Matcher
+	dests() []*Destination
 }
 
 type RouteSnapshot struct {
@@ -47,13 +53,16 @@ type RouteSnapshot struct {
 	Dests   []*Destination `json:"destination"`
 	Type    string         `json:"type"`
 	Key     string         `json:"key"`
+	Hits    int64          `json:"hits"`    // metrics dispatched to the route
+	LastHit int64          `json:"lastHit"` // unix timestamp, 0 if never
 }
 
 type baseRoute struct {
 	sync.Mutex              // only needed for the multiple writers
 	config     atomic.Value // for reading and writing
 
-	key string
+	key  string
+	hits *ruleHits
 }
 
 type RouteSendAllMatch struct {
@@ -69,52 +78,48 @@ type RouteConsistentHashing struct {
 }
 
 // NewRouteSendAllMatch creates a sendAllMatch route.
-// We will automatically run the route and the given destinations
-func NewRouteSendAllMatch(key, prefix, sub, regex string, destinations []*Destination) (Route, error) {
-	m, err := NewMatcher(prefix, sub, regex)
-	if err != nil {
-		return nil, err
-	}
-	r := &RouteSendAllMatch{baseRoute{sync.Mutex{}, atomic.Value{}, key}}
-	r.config.Store(baseRouteConfig{*m, destinations})
-	r.run()
+// Note that it still needs to be told to run via Run(), which runs the given destinations
+func NewRouteSendAllMatch(key string, matcher Matcher, destinations []*Destination) (Route, error) {
+	r := &RouteSendAllMatch{baseRoute{sync.Mutex{}, atomic.Value{}, key, newRuleHits("route", key)}}
+	r.config.Store(baseRouteConfig{matcher, destinations})
 	return r, nil
 }
 
 // NewRouteSendFirstMatch creates a sendFirstMatch route.
-// We will automatically run the route and the given destinations
-func NewRouteSendFirstMatch(key, prefix, sub, regex string, destinations []*Destination) (Route, error) {
-	m, err := NewMatcher(prefix, sub, regex)
-	if err != nil {
-		return nil, err
-	}
-	r := &RouteSendFirstMatch{baseRoute{sync.Mutex{}, atomic.Value{}, key}}
-	r.config.Store(baseRouteConfig{*m, destinations})
-	r.run()
+// Note that it still needs to be told to run via Run(), which runs the given destinations
+func NewRouteSendFirstMatch(key string, matcher Matcher, destinations []*Destination) (Route, error) {
+	r := &RouteSendFirstMatch{baseRoute{sync.Mutex{}, atomic.Value{}, key, newRuleHits("route", key)}}
+	r.config.Store(baseRouteConfig{matcher, destinations})
 	return r, nil
 }
 
-func NewRouteConsistentHashing(key, prefix, sub, regex string, destinations []*Destination) (Route, error) {
-	m, err := NewMatcher(prefix, sub, regex)
-	if err != nil {
-		return nil, err
-	}
-	r := &RouteConsistentHashing{baseRoute{sync.Mutex{}, atomic.Value{}, key}}
+func NewRouteConsistentHashing(key string, matcher Matcher, destinations []*Destination) (Route, error) {
+	r := &RouteConsistentHashing{baseRoute{sync.Mutex{}, atomic.Value{}, key, newRuleHits("route", key)}}
 	hasher := NewConsistentHasher(destinations)
-	r.config.Store(consistentHashingRouteConfig{baseRouteConfig{*m, destinations},
+	r.config.Store(consistentHashingRouteConfig{baseRouteConfig{matcher, destinations},
 		&hasher})
-	r.run()
 	return r, nil
 }
 
-func (route *baseRoute) run() {
-	conf := route.config.Load().(RouteConfig)
-	for _, dest := range conf.Dests() {
+// Run runs the destinations of the route
+func (route *baseRoute) Run() {
+	for _, dest := range route.dests() {
 		dest.Run()
 	}
 }
 
+// matcher returns the current matcher of the route. unlike Snapshot(), it doesn't look at the dests
+func (route *baseRoute) matcher() Matcher {
+	return *route.config.Load().(RouteConfig).Matcher()
+}
+
+// dests returns the destinations the route currently sends to
+func (route *baseRoute) dests() []*Destination {
+	return route.config.Load().(RouteConfig).Dests()
+}
+
 func (route *RouteSendAllMatch) Dispatch(buf []byte) {
+	route.hits.hit()
 	conf := route.config.Load().(RouteConfig)
 
 	for _, dest := range conf.Dests() {
@@ -127,6 +132,7 @@ func (route *RouteSendAllMatch) Dispatch(buf []byte) {
 }
 
 func (route *RouteSendFirstMatch) Dispatch(buf []byte) {
+	route.hits.hit()
 	conf := route.config.Load().(RouteConfig)
 
 	for _, dest := range conf.Dests() {
@@ -140,6 +146,7 @@ func (route *RouteSendFirstMatch) Dispatch(buf []byte) {
 }
 
 func (route *RouteConsistentHashing) Dispatch(buf []byte) {
+	route.hits.hit()
 	conf := route.config.Load().(consistentHashingRouteConfig)
 	if pos := bytes.IndexByte(buf, ' '); pos > 0 {
 		name := buf[0:pos]
@@ -173,6 +180,27 @@ func (route *baseRoute) Flush() error {
 	return nil
 }
 
+// Drain drains all destinations concurrently, see Destination.Drain()
+func (route *baseRoute) Drain(deadline time.Time) DrainStats {
+	conf := route.config.Load().(RouteConfig)
+
+	var stats DrainStats
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, d := range conf.Dests() {
+		wg.Add(1)
+		go func(d *Destination) {
+			s := d.Drain(deadline)
+			lock.Lock()
+			stats.Add(s)
+			lock.Unlock()
+			wg.Done()
+		}(d)
+	}
+	wg.Wait()
+	return stats
+}
+
 func (route *baseRoute) Shutdown() error {
 	conf := route.config.Load().(RouteConfig)
 
@@ -192,7 +220,7 @@ func (route *baseRoute) Shutdown() error {
 	for _, e := range destErrs {
 		errStr += "   " + e.Error()
 	}
-	return fmt.Errorf("one or more destinations failed to shutdown:" + errStr)
+	return fmt.Errorf("one or more destinations failed to shutdown:%s", errStr)
 }
 
 // to view the state of the table/route at any point in time
@@ -202,7 +230,8 @@ func makeSnapshot(route *baseRoute, routeType string) RouteSnapshot {
 	for i, d := range conf.Dests() {
 		dests[i] = d.Snapshot()
 	}
-	return RouteSnapshot{*conf.Matcher(), dests, routeType, route.key}
+	hits, lastHit := route.hits.get()
+	return RouteSnapshot{*conf.Matcher(), dests, routeType, route.key, hits, lastHit}
 
 }
 
@@ -293,37 +322,35 @@ func (route *RouteConsistentHashing) DelDestination(index int) error {
 	return route.delDestination(index, consistentHashingRouteConfigExtender)
 }
 
+// updatedMatcher returns a copy of the matcher with the options of Route.Update applied
+func updatedMatcher(matcher Matcher, opts map[string]string) (Matcher, error) {
+	if err := validateRouteOpts(opts); err != nil {
+		return matcher, err
+	}
+	// matcher is a copy. the alternatives are shared, but never modified
+	for name, val := range opts {
+		if !matcher.setOpt(name, val) {
+			return matcher, fmt.Errorf("no such option '%s'", name)
+		}
+	}
+	if len(opts) != 0 {
+		if err := matcher.updateInternals(); err != nil {
+			return matcher, err
+		}
+	}
+	return matcher, nil
+}
+
 func (route *baseRoute) update(opts map[string]string, extendConfig baseConfigExtender) error {
 	route.Lock()
 	defer route.Unlock()
 	conf := route.config.Load().(RouteConfig)
-	matcher := conf.Matcher()
-	prefix := matcher.Prefix
-	sub := matcher.Sub
-	regex := matcher.Regex
-	updateMatcher := false
-
-	for name, val := range opts {
-		switch name {
-		case "prefix":
-			prefix = val
-			updateMatcher = true
-		case "sub":
-			sub = val
-			updateMatcher = true
-		case "regex":
-			regex = val
-			updateMatcher = true
-		default:
-			return fmt.Errorf("no such option '%s'", name)
-		}
+	matcher, err := updatedMatcher(*conf.Matcher(), opts)
+	if err != nil {
+		return err
 	}
-	if updateMatcher {
-		matcher, err := NewMatcher(prefix, sub, regex)
-		if err != nil {
-			return err
-		}
-		conf = extendConfig(baseRouteConfig{*matcher, conf.Dests()})
+	if len(opts) != 0 {
+		conf = extendConfig(baseRouteConfig{matcher, conf.Dests()})
 	}
 	route.config.Store(conf)
 	return nil
@@ -337,19 +364,26 @@ func (route *RouteConsistentHashing) Update(opts map[string]string) error {
 	return route.update(opts, consistentHashingRouteConfigExtender)
 }
 
+// updateDestination updates the dest, and only reconnects it (if needed) once the route is unlocked
 func (route *baseRoute) updateDestination(index int, opts map[string]string, extendConfig baseConfigExtender) error {
 	route.Lock()
-	defer route.Unlock()
 	conf := route.config.Load().(RouteConfig)
 	if index >= len(conf.Dests()) {
+		route.Unlock()
 		return fmt.Errorf("Invalid index %d", index)
 	}
-	err := conf.Dests()[index].Update(opts)
+	update, err := conf.Dests()[index].prepareUpdate(opts)
 	if err != nil {
+		route.Unlock()
 		return err
 	}
+	reconnect := update.apply()
 	conf = extendConfig(baseRouteConfig{*conf.Matcher(), conf.Dests()})
 	route.config.Store(conf)
+	route.Unlock()
+	if reconnect != nil {
+		reconnect()
+	}
 	return nil
 }
 
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+	m20 "github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/metrics20/go-metrics20"
+	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
+)
+
+// sanitizeConfig enables the repairs we apply to incoming lines before validating them.
+// all of them are off by default.
+type sanitizeConfig struct {
+	Collapse_dots   bool // foo..bar -> foo.bar
+	Replace_illegal bool // replace characters the legacy_metric_validation level doesn't allow, and spaces in the name, with _
+	Strip_cr        bool // remove stray carriage returns
+	Fix_timestamps  bool // convert timestamps in milli- or microseconds to seconds
+	Sample          int  // record every Sample'th repaired line in the bad metrics. 0 to disable
+}
+
+func (c sanitizeConfig) enabled() bool {
+	return c.Collapse_dots || c.Replace_illegal || c.Strip_cr || c.Fix_timestamps
+}
+
+var (
+	numRepaired  metrics.Counter
+	repairedSeen uint64
+	doubleDot    = []byte("..")
+	singleDot    = []byte(".")
+	carriageRet  = []byte{'\r'}
+	space        = []byte{' '}
+	underscore   = []byte{'_'}
+)
+
+// timestamps from this far in the future are assumed to be in ms resp. µs
+const (
+	tsMillis = 1e11
+	tsMicros = 1e14
+)
+
+// sanitize applies the repairs enabled in conf to the line.
+// if it repaired anything, it returns a new line, and the names of the repairs it made.
+// buf itself is never modified.
+func sanitize(buf []byte, conf sanitizeConfig, level m20.LegacyMetricValidation) ([]byte, []string) {
+	var repairs []string
+	line := buf
+	if conf.Strip_cr && bytes.Contains(line, carriageRet) {
+		line = bytes.Replace(line, carriageRet, nil, -1)
+		repairs = append(repairs, "strip_cr")
+	}
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		return buf, nil
+	}
+	name := fields[0]
+	if conf.Replace_illegal {
+		replaced := false
+		if len(fields) > 3 {
+			// the name has spaces in it
+			name = bytes.Join(fields[:len(fields)-2], underscore)
+			fields = fields[len(fields)-3:]
+			replaced = true
+		}
+		if m20.GetVersionB(name) == m20.Legacy {
+			if fixed, ok := replaceIllegal(name, level); ok {
+				name = fixed
+				replaced = true
+			}
+		}
+		if replaced {
+			repairs = append(repairs, "replace_illegal")
+		}
+	}
+	if conf.Collapse_dots && bytes.Contains(name, doubleDot) {
+		for bytes.Contains(name, doubleDot) {
+			name = bytes.Replace(name, doubleDot, singleDot, -1)
+		}
+		repairs = append(repairs, "collapse_dots")
+	}
+	ts := emptyByteStr
+	if len(fields) == 3 {
+		ts = fields[2]
+	}
+	if conf.Fix_timestamps && len(ts) != 0 {
+		if t, err := strconv.ParseUint(string(ts), 10, 64); err == nil && t >= tsMillis {
+			if t >= tsMicros {
+				t /= 1000000
+			} else {
+				t /= 1000
+			}
+			ts = []byte(strconv.FormatUint(t, 10))
+			repairs = append(repairs, "fix_timestamps")
+		}
+	}
+	if len(repairs) == 0 {
+		return buf, nil
+	}
+	// fields is our own slice, the fields themselves still point into buf
+	fields[0] = name
+	if len(fields) == 3 {
+		fields[2] = ts
+	}
+	return bytes.Join(fields, space), repairs
+}
+
+// replaceIllegal replaces the characters of a legacy metric name that the validation level doesn't allow with _.
+// the name is copied if anything needs replacing
+func replaceIllegal(name []byte, level m20.LegacyMetricValidation) ([]byte, bool) {
+	var fixed []byte
+	for i, ch := range name {
+		var ok bool
+		switch level {
+		case m20.Strict:
+			ok = (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-' || ch == '.'
+		case m20.Medium:
+			ok = ch != 0 && ch&0x80 == 0
+		default:
+			ok = true
+		}
+		if ok {
+			continue
+		}
+		if fixed == nil {
+			fixed = make([]byte, len(name))
+			copy(fixed, name)
+		}
+		fixed[i] = '_'
+	}
+	return fixed, fixed != nil
+}
+
+// reportRepaired counts a line we repaired, and records every sample'th one in the bad metrics, with its original text
+func reportRepaired(orig []byte, repairs []string, peer string, sample int) {
+	numRepaired.Inc(1)
+	if sample <= 0 || atomic.AddUint64(&repairedSeen, 1)%uint64(sample) != 0 {
+		return
+	}
+	metric := emptyByteStr
+	if fields := bytes.Fields(orig); len(fields) != 0 {
+		metric = fields[0]
+	}
+	err := errors.New("repaired: " + strings.Join(repairs, ", "))
+	badMetrics.Add(metric, orig, err, badmetrics.ErrRepaired, peer)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	m20 "github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/metrics20/go-metrics20"
+	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
+)
+
+func TestSanitize(t *testing.T) {
+	all := sanitizeConfig{Collapse_dots: true, Replace_illegal: true, Strip_cr: true, Fix_timestamps: true}
+	cases := []struct {
+		in      string
+		conf    sanitizeConfig
+		level   m20.LegacyMetricValidation
+		out     string
+		repairs string
+	}{
+		{"foo.bar 1 1400000000", all, m20.Strict, "foo.bar 1 1400000000", ""},
+		{"foo..bar...baz 1 1", all, m20.Strict, "foo.bar.baz 1 1", "collapse_dots"},
+		{"foo..bar 1 1", sanitizeConfig{Strip_cr: true}, m20.Strict, "foo..bar 1 1", ""},
+		{"foo/bar:baz 1 1", all, m20.Strict, "foo_bar_baz 1 1", "replace_illegal"},
+		{"foo/bar:baz 1 1", all, m20.Medium, "foo/bar:baz 1 1", ""},
+		{"foo.b\xbdr 1 1", all, m20.Medium, "foo.b_r 1 1", "replace_illegal"},
+		{"foo.b\xbdr 1 1", all, m20.None, "foo.b\xbdr 1 1", ""},
+		{"my server.cpu 1 1", all, m20.Strict, "my_server.cpu 1 1", "replace_illegal"},
+		{"unit=B.target_type=gauge.a/b 1 1", all, m20.Strict, "unit=B.target_type=gauge.a/b 1 1", ""},
+		{"foo.bar 1\r 1", all, m20.Strict, "foo.bar 1 1", "strip_cr"},
+		{"foo.bar 1 1400000000123", all, m20.Strict, "foo.bar 1 1400000000", "fix_timestamps"},
+		{"foo.bar 1 1400000000123456", all, m20.Strict, "foo.bar 1 1400000000", "fix_timestamps"},
+		{"a b..c 1 1400000000123\r", all, m20.Strict, "a_b.c 1 1400000000", "strip_cr,replace_illegal,collapse_dots,fix_timestamps"},
+	}
+	for _, c := range cases {
+		out, repairs := sanitize([]byte(c.in), c.conf, c.level)
+		assert.Equal(t, c.out, string(out), c.in)
+		assert.Equal(t, c.repairs, strings.Join(repairs, ","), c.in)
+	}
+}
+
+func TestSanitizeHandleLine(t *testing.T) {
+	config := initIngest()
+	config.Sanitize = sanitizeConfig{Collapse_dots: true, Replace_illegal: true, Sample: 2}
+	table = NewTableOrFatal(t, "", "")
+	repairedBefore := numRepaired.Count()
+	invalidBefore := numInvalid.Count()
+	handleLine([]byte("foo..bar 1 1"), "10.0.0.1", config)
+	handleLine([]byte("foo..bar 2 1"), "10.0.0.1", config)
+	handleLine([]byte("foo.bar 3 1"), "10.0.0.1", config)
+	// can't be repaired
+	handleLine([]byte("foo..bar x 1"), "10.0.0.1", config)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(2), numRepaired.Count()-repairedBefore)
+	assert.Equal(t, int64(1), numInvalid.Count()-invalidBefore)
+
+	records := badMetrics.GetFiltered(badmetrics.Filter{ErrType: badmetrics.ErrRepaired})
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "foo..bar", records[0].Metric)
+	assert.Equal(t, "repaired: collapse_dots", records[0].LastErr)
+	records = badMetrics.GetFiltered(badmetrics.Filter{ErrType: badmetrics.ErrValue})
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "foo..bar x 1", records[0].LastMsg)
+}
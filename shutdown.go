@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DrainStats tracks what happened to the metrics that were still in flight when draining
+type DrainStats struct {
+	Delivered int64
+	Spooled   int64
+	Dropped   int64
+}
+
+func (s *DrainStats) Add(o DrainStats) {
+	s.Delivered += o.Delivered
+	s.Spooled += o.Spooled
+	s.Dropped += o.Dropped
+}
+
+// clientConns tracks the open ingest connections, so we can close them at shutdown
+// and wait for their handlers to dispatch what they already read.
+var clientConns = struct {
+	sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}{conns: make(map[net.Conn]bool)}
+
+func trackConn(c net.Conn) {
+	clientConns.Lock()
+	clientConns.conns[c] = true
+	clientConns.wg.Add(1)
+	clientConns.Unlock()
+}
+
+func untrackConn(c net.Conn) {
+	clientConns.Lock()
+	delete(clientConns.conns, c)
+	clientConns.wg.Done()
+	clientConns.Unlock()
+}
+
+func closeClientConns() {
+	clientConns.Lock()
+	for c := range clientConns.conns {
+		c.Close()
+	}
+	clientConns.Unlock()
+}
+
+// waitTimeout waits for wg, but no longer than until the deadline. returns whether wg completed.
+func waitTimeout(wg *sync.WaitGroup, deadline time.Time) bool {
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(deadline.Sub(time.Now())):
+		return false
+	}
+}
+
+// shutdown stops the listeners and client connections, flushes the aggregators and drains
+// all routes, giving the destinations until timeout to deliver what they have buffered.
+// whatever can't be delivered in time is spooled, if the destination has spooling enabled, or dropped.
+func shutdown(timeout time.Duration, listeners ...io.Closer) {
+	deadline := time.Now().Add(timeout)
+	log.Notice("shutting down. draining with a timeout of %s", timeout)
+	for _, l := range listeners {
+		l.Close()
+	}
+	closeClientConns()
+	if !waitTimeout(&clientConns.wg, deadline) {
+		log.Warning("timed out waiting for client connection handlers to finish")
+	}
+
+	drained := make(chan DrainStats)
+	go func() {
+		drained <- table.Drain(deadline)
+	}()
+	select {
+	case stats := <-drained:
+		log.Notice("drained: delivered %d, spooled %d, dropped %d metrics", stats.Delivered, stats.Spooled, stats.Dropped)
+		err := table.Shutdown()
+		if err != nil {
+			log.Error(err.Error())
+		}
+	// a destination can get stuck on a hung network write. don't wait forever
+	case <-time.After(deadline.Sub(time.Now()) + 5*time.Second):
+		log.Error("draining did not complete in time. exiting without finishing it")
+	}
+	log.Notice("shutdown complete")
+}
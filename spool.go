@@ -83,6 +83,11 @@ func (s *Spool) Writer() {
 	for {
 		select {
 		case <-s.shutdownWriter:
+			// pass on what's still pending, the Buffer is still running
+			for len(s.InRT) > 0 {
+				s.queueBuffer <- <-s.InRT
+				s.numBuffered.Inc(1)
+			}
 			return
 		case buf := <-s.InRT: // wish we could somehow prioritize this higher
 			s.numIncomingRT.Inc(1)
@@ -106,6 +111,11 @@ func (s *Spool) Writer() {
 	}
 }
 
+// Depth returns the amount of metrics in the spool, buffered or on disk
+func (s *Spool) Depth() int64 {
+	return s.queue.Depth() + int64(len(s.queueBuffer))
+}
+
 func (s *Spool) Ingest(bulkData [][]byte) {
 	for _, buf := range bulkData {
 		s.InBulk <- buf
@@ -116,6 +126,11 @@ func (s *Spool) Buffer() {
 	for {
 		select {
 		case <-s.shutdownBuffer:
+			// write out what's still buffered so it doesn't get lost
+			for len(s.queueBuffer) > 0 {
+				s.numBuffered.Dec(1)
+				s.queue.Put(<-s.queueBuffer)
+			}
 			return
 		case buf := <-s.queueBuffer:
 			s.numBuffered.Dec(1)
@@ -7,28 +7,38 @@ import (
 	"github.com/graphite-ng/carbon-relay-ng/aggregator"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type TableConfig struct {
-	aggregators []*aggregator.Aggregator
-	blacklist   []*Matcher
-	routes      []Route
+	aggregators  []*aggregator.Aggregator
+	blacklist    []*Matcher
+	whitelist    []*Matcher // if not empty, only metrics matching any of these are accepted
+	routes       []Route
+	defaultRoute string      // key of the route that gets the metrics that no other route takes, if any
+	index        *tableIndex // of the above, built when the config is stored
 }
 
 type Table struct {
 	sync.Mutex                 // only needed for the multiple writers
 	config        atomic.Value // for reading and writing
 	spoolDir      string
+	version       uint64 // bumped on every change of the config, so batches can tell whether it changed under them
 	numBlacklist  metrics.Counter
+	numWhitelist  metrics.Counter
 	numUnroutable metrics.Counter
-	In            chan []byte `json:"-"` // channel api to trade in some performance for encapsulation, for aggregators
+	tsFilter      atomic.Value // *timestampFilter, nil if disabled
+	In            chan []byte  `json:"-"` // channel api to trade in some performance for encapsulation, for aggregators
+	inSync        chan bool    // taken by the consumer of In in between metrics, so we can tell it dispatched all it took
 }
 
 type TableSnapshot struct {
-	Aggregators []*aggregator.Aggregator `json:"aggregators"`
-	Blacklist   []*Matcher               `json:"blacklist"`
-	Routes      []RouteSnapshot          `json:"routes"`
-	spoolDir    string
+	Aggregators  []*aggregator.Aggregator `json:"aggregators"`
+	Blacklist    []*Matcher               `json:"blacklist"`
+	Whitelist    []*Matcher               `json:"whitelist"`
+	Routes       []RouteSnapshot          `json:"routes"`
+	DefaultRoute string                   `json:"defaultRoute,omitempty"`
+	spoolDir     string
 }
 
 func NewTable(spoolDir string) *Table {
@@ -36,40 +46,89 @@ func NewTable(spoolDir string) *Table {
 		sync.Mutex{},
 		atomic.Value{},
 		spoolDir,
+		0,
 		Counter("unit=Metric.direction=blacklist"),
+		Counter("unit=Metric.direction=not_whitelisted"),
 		Counter("unit=Metric.direction=unroutable"),
+		atomic.Value{},
 		make(chan []byte),
+		make(chan bool),
 	}
+	t.tsFilter.Store((*timestampFilter)(nil))
 
-	t.config.Store(TableConfig{
+	conf := TableConfig{
 		make([]*aggregator.Aggregator, 0),
 		make([]*Matcher, 0),
+		make([]*Matcher, 0),
 		make([]Route, 0),
-	})
+		"",
+		nil,
+	}
+	conf.index = newTableIndex(conf)
+	t.config.Store(conf)
 
 	go func() {
-		for buf := range t.In {
-			t.DispatchAggregate(buf)
+		for {
+			select {
+			case buf := <-t.In:
+				t.DispatchAggregate(buf)
+			case <-t.inSync:
+			}
 		}
 	}()
 	return t
 }
 
 // Dispatch dispatches incoming metrics into matching aggregators and routes,
-// after checking against the blacklist
+// after checking against the blacklist, the whitelist and the timestamp filter, in that order.
+// so metrics matching the blacklist are dropped, even if they match the whitelist.
 // buf is assumed to have no whitespace at the end
 func (table *Table) Dispatch(buf []byte) {
+	table.DispatchFrom(buf, "")
+}
+
+// DispatchFrom is like Dispatch, for metrics sent by peer (used in the bad metrics records)
+func (table *Table) DispatchFrom(buf []byte, peer string) {
 	conf := table.config.Load().(TableConfig)
 
-	for _, matcher := range conf.blacklist {
-		if matcher.Match(buf) {
-			table.numBlacklist.Inc(1)
-			return
+	if i := conf.index.blacklist.first(buf); i >= 0 {
+		conf.blacklist[i].hits.hit()
+		table.numBlacklist.Inc(1)
+		return
+	}
+
+	if len(conf.whitelist) > 0 && conf.index.whitelist.first(buf) < 0 {
+		table.numWhitelist.Inc(1)
+		return
+	}
+
+	var fields [][]byte
+	quarantine := ""
+	if f := table.tsFilter.Load().(*timestampFilter); f != nil {
+		if f.action == "route" {
+			quarantine = f.route
+		}
+		fields = bytes.Fields(buf)
+		now := time.Now()
+		if reason := f.check(fields, now); reason != "" {
+			f.report(buf, fields, reason, peer, now)
+			switch f.action {
+			case "drop":
+				return
+			case "clamp":
+				buf = clamp(fields, now)
+				fields = bytes.Fields(buf)
+			case "route":
+				table.dispatchQuarantine(conf, quarantine, buf)
+				return
+			}
 		}
 	}
 
 	if len(conf.aggregators) > 0 {
-		fields := bytes.Fields(buf)
+		if fields == nil {
+			fields = bytes.Fields(buf)
+		}
 		for _, aggregator := range conf.aggregators {
 			// we rely on incoming metrics already having been validated
 			if aggregator.PreMatch(fields[0]) {
@@ -78,42 +137,59 @@ func (table *Table) Dispatch(buf []byte) {
 		}
 	}
 
+	table.dispatchRoutes(conf, quarantine, buf)
+}
+
+// dispatchRoutes sends the metric to all matching routes, except the quarantine route, if any.
+// the default route only gets the metric if no other route took it, regardless of its matcher.
+func (table *Table) dispatchRoutes(conf TableConfig, quarantine string, buf []byte) {
 	routed := false
 
-	for _, route := range conf.routes {
-		if route.Match(buf) {
+	var arr [8]int
+	for _, i := range conf.index.routes.all(buf, arr[:0]) {
+		route := conf.routes[i]
+		if i != conf.index.defaultRoute && route.Key() != quarantine {
 			routed = true
 			log.Info("table sending to route: %s", buf)
 			route.Dispatch(buf)
 		}
 	}
 
+	if !routed && conf.index.defaultRoute >= 0 {
+		log.Info("table sending to default route: %s", buf)
+		conf.routes[conf.index.defaultRoute].Dispatch(buf)
+		return
+	}
+
 	if !routed {
 		table.numUnroutable.Inc(1)
 		log.Notice("unrouteable: %s\n", buf)
 	}
-
 }
 
-// DispatchAggregate dispatches aggregation output by routing metrics into the matching routes.
-// buf is assumed to have no whitespace at the end
-func (table *Table) DispatchAggregate(buf []byte) {
-	conf := table.config.Load().(TableConfig)
-	routed := false
-
+// dispatchQuarantine sends a metric caught by the timestamp filter to the quarantine route, regardless of its matcher
+func (table *Table) dispatchQuarantine(conf TableConfig, key string, buf []byte) {
 	for _, route := range conf.routes {
-		if route.Match(buf) {
-			routed = true
-			log.Info("table sending to route: %s", buf)
+		if route.Key() == key {
+			log.Info("table sending to quarantine route: %s", buf)
 			route.Dispatch(buf)
+			return
 		}
 	}
+	table.numUnroutable.Inc(1)
+	log.Notice("unrouteable: quarantine route %s does not exist: %s\n", key, buf)
+}
 
-	if !routed {
-		table.numUnroutable.Inc(1)
-		log.Notice("unrouteable: %s\n", buf)
-	}
+// SetTimestampFilter sets the filter for the timestamps of incoming metrics. nil disables it
+func (table *Table) SetTimestampFilter(f *timestampFilter) {
+	table.tsFilter.Store(f)
+}
 
+// DispatchAggregate dispatches aggregation output by routing metrics into the matching routes.
+// buf is assumed to have no whitespace at the end
+func (table *Table) DispatchAggregate(buf []byte) {
+	conf := table.config.Load().(TableConfig)
+	table.dispatchRoutes(conf, "", buf)
 }
 
 // to view the state of the table/route at any point in time
@@ -123,7 +199,12 @@ func (table *Table) Snapshot() TableSnapshot {
 
 	blacklist := make([]*Matcher, len(conf.blacklist))
 	for i, p := range conf.blacklist {
-		blacklist[i] = p
+		blacklist[i] = p.withHits()
+	}
+
+	whitelist := make([]*Matcher, len(conf.whitelist))
+	for i, p := range conf.whitelist {
+		whitelist[i] = p
 	}
 
 	routes := make([]RouteSnapshot, len(conf.routes))
@@ -135,7 +216,7 @@ func (table *Table) Snapshot() TableSnapshot {
 	for i, a := range conf.aggregators {
 		aggs[i] = a.Snapshot()
 	}
-	return TableSnapshot{aggs, blacklist, routes, table.spoolDir}
+	return TableSnapshot{aggs, blacklist, whitelist, routes, conf.defaultRoute, table.spoolDir}
 }
 
 func (table *Table) GetRoute(key string) Route {
@@ -148,22 +229,60 @@ func (table *Table) GetRoute(key string) Route {
 	return nil
 }
 
-// AddRoute adds a route to the table.
-// The Route must be running already
+// store replaces the config, and indexes it. the caller must hold the lock.
+func (table *Table) store(conf TableConfig) {
+	table.version++
+	conf.index = newTableIndex(conf)
+	table.config.Store(conf)
+}
+
+// reindex rebuilds the index of the config, after the matchers of routes changed. the caller must hold the lock.
+func (table *Table) reindex() {
+	conf := table.config.Load().(TableConfig)
+	conf.index = newTableIndex(conf)
+	table.config.Store(conf)
+}
+
+// AddRoute runs the route and adds it to the table.
+// The Route must not be running yet
 func (table *Table) AddRoute(route Route) {
 	table.Lock()
 	defer table.Unlock()
+	route.Run()
 	conf := table.config.Load().(TableConfig)
 	conf.routes = append(conf.routes, route)
-	table.config.Store(conf)
+	table.store(conf)
+}
+
+// SetDefaultRoute makes the route with the given key the default route, which gets all metrics that no other route takes.
+// an empty key unsets it.
+func (table *Table) SetDefaultRoute(key string) error {
+	table.Lock()
+	defer table.Unlock()
+	conf := table.config.Load().(TableConfig)
+	if key != "" && table.GetRoute(key) == nil {
+		return fmt.Errorf("no route '%s'", key)
+	}
+	conf.defaultRoute = key
+	table.store(conf)
+	return nil
 }
 
 func (table *Table) AddBlacklist(matcher *Matcher) {
 	table.Lock()
 	defer table.Unlock()
 	conf := table.config.Load().(TableConfig)
+	matcher.hits = newRuleHits("blacklist", matcher.String())
 	conf.blacklist = append(conf.blacklist, matcher)
-	table.config.Store(conf)
+	table.store(conf)
+}
+
+func (table *Table) AddWhitelist(matcher *Matcher) {
+	table.Lock()
+	defer table.Unlock()
+	conf := table.config.Load().(TableConfig)
+	conf.whitelist = append(conf.whitelist, matcher)
+	table.store(conf)
 }
 
 func (table *Table) AddAggregator(agg *aggregator.Aggregator) {
@@ -171,7 +290,7 @@ func (table *Table) AddAggregator(agg *aggregator.Aggregator) {
 	defer table.Unlock()
 	conf := table.config.Load().(TableConfig)
 	conf.aggregators = append(conf.aggregators, agg)
-	table.config.Store(conf)
+	table.store(conf)
 }
 
 func (table *Table) DelAggregator(id int) error {
@@ -189,7 +308,7 @@ func (table *Table) DelAggregator(id int) error {
 	conf.aggregators = append(conf.aggregators[:id], conf.aggregators[id+1:]...)
 	fmt.Println("len", len(conf.aggregators))
 	agg.Shutdown()
-	table.config.Store(conf)
+	table.store(conf)
 	return nil
 }
 
@@ -204,6 +323,22 @@ func (table *Table) Flush() error {
 	return nil
 }
 
+// Drain flushes all aggregators, and then drains all routes before the deadline.
+// see Destination.Drain()
+func (table *Table) Drain(deadline time.Time) DrainStats {
+	conf := table.config.Load().(TableConfig)
+	for _, agg := range conf.aggregators {
+		agg.FlushAll()
+	}
+	// the aggregators' output went into In, but may not have been dispatched to the routes yet
+	table.inSync <- true
+	var stats DrainStats
+	for _, route := range conf.routes {
+		stats.Add(route.Drain(deadline))
+	}
+	return stats
+}
+
 func (table *Table) Shutdown() error {
 	table.Lock()
 	defer table.Unlock()
@@ -215,7 +350,7 @@ func (table *Table) Shutdown() error {
 		}
 	}
 	conf.routes = make([]Route, 0)
-	table.config.Store(conf)
+	table.store(conf)
 	return nil
 }
 
@@ -238,7 +373,10 @@ func (table *Table) DelRoute(key string) error {
 	}
 
 	conf.routes = append(conf.routes[:toDelete], conf.routes[toDelete+1:]...)
-	table.config.Store(conf)
+	if conf.defaultRoute == key {
+		conf.defaultRoute = ""
+	}
+	table.store(conf)
 
 	err := route.Shutdown()
 	if err != nil {
@@ -257,11 +395,27 @@ func (table *Table) DelBlacklist(index int) error {
 		return fmt.Errorf("Invalid index %d", index)
 	}
 	conf.blacklist = append(conf.blacklist[:index], conf.blacklist[index+1:]...)
-	table.config.Store(conf)
+	table.store(conf)
+	return nil
+}
+
+func (table *Table) DelWhitelist(index int) error {
+	table.Lock()
+	defer table.Unlock()
+	conf := table.config.Load().(TableConfig)
+	if index < 0 || index >= len(conf.whitelist) {
+		return fmt.Errorf("Invalid index %d", index)
+	}
+	conf.whitelist = append(conf.whitelist[:index:index], conf.whitelist[index+1:]...)
+	table.store(conf)
 	return nil
 }
 
 func (table *Table) DelDestination(key string, index int) error {
+	table.Lock()
+	defer table.Unlock()
+	// the destinations of the route get renumbered
+	table.version++
 	route := table.GetRoute(key)
 	if route == nil {
 		return fmt.Errorf("Invalid route for %v", key)
@@ -269,20 +423,25 @@ func (table *Table) DelDestination(key string, index int) error {
 	return route.DelDestination(index)
 }
 
+// UpdateDestination applies the options to the destination, as a batch of one,
+// so like any batch, it changes the table version, and batches that began earlier won't undo it.
 func (table *Table) UpdateDestination(key string, index int, opts map[string]string) error {
-	route := table.GetRoute(key)
-	if route == nil {
-		return fmt.Errorf("Invalid route for %v", key)
+	batch := table.Begin()
+	if err := batch.UpdateDestination(key, index, opts); err != nil {
+		batch.Abort()
+		return err
 	}
-	return route.UpdateDestination(index, opts)
+	return batch.Commit()
 }
 
+// UpdateRoute applies the options to the route, as a batch of one. see UpdateDestination
 func (table *Table) UpdateRoute(key string, opts map[string]string) error {
-	route := table.GetRoute(key)
-	if route == nil {
-		return fmt.Errorf("Invalid route for %v", key)
+	batch := table.Begin()
+	if err := batch.UpdateRoute(key, opts); err != nil {
+		batch.Abort()
+		return err
 	}
-	return route.Update(opts)
+	return batch.Commit()
 }
 
 func (table *Table) Print() (str string) {
@@ -292,9 +451,7 @@ func (table *Table) Print() (str string) {
 	// the default values can be arbitrary (bot not smaller than the column titles),
 	// i figured multiples of 4 should look good
 	// 'R' stands for Route, 'D' for dest, 'B' blacklist, 'A" for aggregation
-	maxBPrefix := 4
-	maxBSub := 4
-	maxBRegex := 4
+	maxBMatch := 8
 	maxAFunc := 4
 	maxARegex := 8
 	maxAOutFmt := 8
@@ -302,20 +459,17 @@ func (table *Table) Print() (str string) {
 	maxAwait := 4
 	maxRType := 8
 	maxRKey := 8
-	maxRPrefix := 4
-	maxRSub := 4
-	maxRRegex := 4
-	maxDPrefix := 4
-	maxDSub := 4
-	maxDRegex := 4
+	maxRMatch := 8
+	maxDMatch := 8
 	maxDAddr := 16
 	maxDSpoolDir := 16
 
 	t := table.Snapshot()
 	for _, black := range t.Blacklist {
-		maxBPrefix = max(maxBRegex, len(black.Prefix))
-		maxBSub = max(maxBSub, len(black.Sub))
-		maxBRegex = max(maxBRegex, len(black.Regex))
+		maxBMatch = max(maxBMatch, len(black.String()))
+	}
+	for _, white := range t.Whitelist {
+		maxBMatch = max(maxBMatch, len(white.String()))
 	}
 	for _, agg := range t.Aggregators {
 		maxAFunc = max(maxAFunc, len(agg.Fun))
@@ -327,25 +481,23 @@ func (table *Table) Print() (str string) {
 	for _, route := range t.Routes {
 		maxRType = max(maxRType, len(route.Type))
 		maxRKey = max(maxRKey, len(route.Key))
-		maxRPrefix = max(maxRPrefix, len(route.Matcher.Prefix))
-		maxRSub = max(maxRSub, len(route.Matcher.Sub))
-		maxRRegex = max(maxRRegex, len(route.Matcher.Regex))
+		maxRMatch = max(maxRMatch, len(route.Matcher.String()))
 		for _, dest := range route.Dests {
-			maxDPrefix = max(maxDPrefix, len(dest.Matcher.Prefix))
-			maxDSub = max(maxDSub, len(dest.Matcher.Sub))
-			maxDRegex = max(maxDRegex, len(dest.Matcher.Regex))
+			maxDMatch = max(maxDMatch, len(dest.Matcher.String()))
 			maxDAddr = max(maxDAddr, len(dest.Addr))
 			maxDSpoolDir = max(maxDSpoolDir, len(dest.spoolDir))
 		}
 	}
-	heaFmtB := fmt.Sprintf("%%%ds %%%ds %%%ds\n", maxBPrefix+1, maxBSub+1, maxBRegex+1)
-	rowFmtB := fmt.Sprintf("%%%ds %%%ds %%%ds\n", maxBPrefix+1, maxBSub+1, maxBRegex+1)
-	heaFmtA := fmt.Sprintf("%%%ds %%%ds %%%ds %%%ds %%%ds\n", maxAFunc+1, maxARegex+1, maxAOutFmt+1, maxAInterval+1, maxAwait+1)
-	rowFmtA := fmt.Sprintf("%%%ds %%%ds %%%ds %%%dd %%%dd\n", maxAFunc+1, maxARegex+1, maxAOutFmt+1, maxAInterval+1, maxAwait+1)
-	heaFmtR := fmt.Sprintf("  %%%ds %%%ds %%%ds %%%ds %%%ds\n", maxRType+1, maxRKey+1, maxRPrefix+1, maxRSub+1, maxRRegex+1)
-	rowFmtR := fmt.Sprintf("> %%%ds %%%ds %%%ds %%%ds %%%ds\n", maxRType+1, maxRKey+1, maxRPrefix+1, maxRSub+1, maxRRegex+1)
-	heaFmtD := fmt.Sprintf("        %%%ds %%%ds %%%ds %%%ds %%%ds %%6s %%6s %%6s\n", maxDPrefix+1, maxDSub+1, maxDRegex+1, maxDAddr+1, maxDSpoolDir+1)
-	rowFmtD := fmt.Sprintf("                %%%ds %%%ds %%%ds %%%ds %%%ds %%6t %%6t %%6t\n", maxDPrefix+1, maxDSub+1, maxDRegex+1, maxDAddr+1, maxDSpoolDir+1)
+	heaFmtB := fmt.Sprintf("%%%ds %%10s %%19s\n", maxBMatch+1)
+	rowFmtB := fmt.Sprintf("%%%ds %%10d %%19s\n", maxBMatch+1)
+	heaFmtW := fmt.Sprintf("%%%ds\n", maxBMatch+1)
+	rowFmtW := fmt.Sprintf("%%%ds\n", maxBMatch+1)
+	heaFmtA := fmt.Sprintf("%%%ds %%%ds %%%ds %%%ds %%%ds %%10s %%19s\n", maxAFunc+1, maxARegex+1, maxAOutFmt+1, maxAInterval+1, maxAwait+1)
+	rowFmtA := fmt.Sprintf("%%%ds %%%ds %%%ds %%%dd %%%dd %%10d %%19s\n", maxAFunc+1, maxARegex+1, maxAOutFmt+1, maxAInterval+1, maxAwait+1)
+	heaFmtR := fmt.Sprintf("  %%%ds %%%ds %%%ds %%10s %%19s\n", maxRType+1, maxRKey+1, maxRMatch+1)
+	rowFmtR := fmt.Sprintf("> %%%ds %%%ds %%%ds %%10d %%19s\n", maxRType+1, maxRKey+1, maxRMatch+1)
+	heaFmtD := fmt.Sprintf("        %%%ds %%%ds %%%ds %%6s %%6s %%6s %%6s %%8s %%8s\n", maxDMatch+1, maxDAddr+1, maxDSpoolDir+1)
+	rowFmtD := fmt.Sprintf("                %%%ds %%%ds %%%ds %%6t %%6t %%6s %%6t %%8d %%8d\n", maxDMatch+1, maxDAddr+1, maxDSpoolDir+1)
 
 	underscore := func(amount int) string {
 		str := ""
@@ -356,36 +508,51 @@ func (table *Table) Print() (str string) {
 		return str
 	}
 
+	// matchers are printed in the syntax of the route options, e.g. "prefix=foo notsub=bar or prefix=baz"
 	str += "\n## Blacklist:\n"
-	cols := fmt.Sprintf(heaFmtB, "prefix", "substr", "regex")
+	cols := fmt.Sprintf(heaFmtB, "match", "hits", "last hit")
 	str += cols + underscore(len(cols))
 	for _, black := range t.Blacklist {
-		str += fmt.Sprintf(rowFmtB, black.Prefix, black.Sub, black.Regex)
+		str += fmt.Sprintf(rowFmtB, black.String(), black.Hits, lastHitString(black.LastHit))
+	}
+
+	str += "\n## Whitelist:\n"
+	cols = fmt.Sprintf(heaFmtW, "match")
+	str += cols + underscore(len(cols))
+	for _, white := range t.Whitelist {
+		str += fmt.Sprintf(rowFmtW, white.String())
 	}
 
 	str += "\n## Aggregations:\n"
-	cols = fmt.Sprintf(heaFmtA, "func", "regex", "outFmt", "interval", "wait")
+	cols = fmt.Sprintf(heaFmtA, "func", "regex", "outFmt", "interval", "wait", "hits", "last hit")
 	str += cols + underscore(len(cols))
 	for _, agg := range t.Aggregators {
-		str += fmt.Sprintf(rowFmtA, agg.Fun, agg.Regex, agg.OutFmt, agg.Interval, agg.Wait)
+		str += fmt.Sprintf(rowFmtA, agg.Fun, agg.Regex, agg.OutFmt, agg.Interval, agg.Wait, agg.Hits, lastHitString(agg.LastHit))
 	}
 
 	str += "\n## Routes:\n"
-	cols = fmt.Sprintf(heaFmtR, "type", "key", "prefix", "substr", "regex")
+	if t.DefaultRoute != "" {
+		str += "default route (gets all metrics that no other route takes): " + t.DefaultRoute + "\n"
+	}
+	cols = fmt.Sprintf(heaFmtR, "type", "key", "match", "hits", "last hit")
 	str += cols + underscore(len(cols))
 
 	for _, route := range t.Routes {
-		m := route.Matcher
-		str += fmt.Sprintf(rowFmtR, route.Type, route.Key, m.Prefix, m.Sub, m.Regex)
-		str += fmt.Sprintf(heaFmtD, "prefix", "substr", "regex", "addr", "spoolDir", "spool", "pickle", "online")
+		str += fmt.Sprintf(rowFmtR, route.Type, route.Key, route.Matcher.String(), route.Hits, lastHitString(route.LastHit))
+		str += fmt.Sprintf(heaFmtD, "match", "addr", "spoolDir", "spool", "pickle", "onfull", "online", "bufsize", "writebuf")
 		str += "              "
-		for i := 1; i < maxDPrefix+maxDSub+maxDRegex+maxDAddr+maxDSpoolDir+5+3*6+10; i++ {
+		for i := 1; i < maxDMatch+maxDAddr+maxDSpoolDir+3+4*6+11+2*9; i++ {
 			str += "-"
 		}
 		str += "\n"
 		for _, dest := range route.Dests {
-			m := dest.Matcher
-			str += fmt.Sprintf(rowFmtD, m.Prefix, m.Sub, m.Regex, dest.Addr, dest.spoolDir, dest.Spool, dest.Pickle, dest.Online)
+			str += fmt.Sprintf(rowFmtD, dest.Matcher.String(), dest.Addr, dest.spoolDir, dest.Spool, dest.Pickle, dest.OnFull, dest.Online, dest.BufSize, dest.WriteBuf)
+			if dest.Prometheus != nil {
+				str += fmt.Sprintf("                serves /metrics for prometheus, expire %ds\n", dest.Prometheus.Expire)
+				for _, mapping := range dest.Prometheus.Mappings {
+					str += fmt.Sprintf("                map %s -> %s\n", mapping.Pattern, mapping.Template)
+				}
+			}
 		}
 		str += "\n"
 	}
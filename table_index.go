@@ -0,0 +1,220 @@
+package main
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// the table indexes its blacklist, whitelist and routes, so that the cost of matching a metric
+// grows sublinearly with the amount of rules: matchers that require a prefix are stored in a trie
+// of those prefixes, and regexes that are anchored at the start are combined into one regex.
+// everything else is still matched one by one.
+
+// below this amount of rules, matching them one by one is faster than using the index
+const minIndexRules = 16
+
+type tableIndex struct {
+	blacklist    *ruleIndex
+	whitelist    *ruleIndex
+	routes       *ruleIndex
+	defaultRoute int // position of the default route in the routes, -1 if none
+}
+
+// newTableIndex indexes the config. route matchers are read from the routes at this point,
+// so the index needs to be rebuilt when they change.
+func newTableIndex(conf TableConfig) *tableIndex {
+	routes := make([]*Matcher, len(conf.routes))
+	defaultRoute := -1
+	for i, route := range conf.routes {
+		m := route.matcher()
+		routes[i] = &m
+		if route.Key() == conf.defaultRoute {
+			defaultRoute = i
+		}
+	}
+	return &tableIndex{
+		newRuleIndex(conf.blacklist),
+		newRuleIndex(conf.whitelist),
+		newRuleIndex(routes),
+		defaultRoute,
+	}
+}
+
+// ruleIndex finds which of a list of matchers (the rules) match a metric
+type ruleIndex struct {
+	matchers   []*Matcher
+	exact      []bool         // per rule: whether matching its prefix is all it takes
+	prefixes   trieNode       // rules that require a prefix, by that prefix
+	regex      *regexp.Regexp // the regexes of regexRules, combined. if it doesn't match, none of them do
+	regexRules []int
+	rest       []int // rules matched one by one
+}
+
+func newRuleIndex(matchers []*Matcher) *ruleIndex {
+	idx := &ruleIndex{
+		matchers: matchers,
+		exact:    make([]bool, len(matchers)),
+	}
+	var regexes []string
+	for i, m := range matchers {
+		if len(matchers) < minIndexRules || len(m.Or) != 0 {
+			idx.rest = append(idx.rest, i)
+			continue
+		}
+		if prefix := m.requiredPrefix(); prefix != "" {
+			idx.prefixes.insert(prefix, i)
+			idx.exact[i] = m.Prefix == prefix && m.numConditions() == 1
+			continue
+		}
+		if m.Regex != "" && m.numConditions() == 1 {
+			if rest, ok := unanchor(m.Regex); ok {
+				regexes = append(regexes, "(?:"+rest+")")
+				idx.regexRules = append(idx.regexRules, i)
+				continue
+			}
+		}
+		idx.rest = append(idx.rest, i)
+	}
+	if len(regexes) != 0 {
+		idx.regex = regexp.MustCompile("^(?:" + strings.Join(regexes, "|") + ")")
+	}
+	return idx
+}
+
+// requiredPrefix returns the longest prefix that the matcher requires, from its prefix or its glob
+func (m *Matcher) requiredPrefix() string {
+	prefix := m.Prefix
+	if m.glob != nil && len(m.glob.Prefix()) > len(prefix) {
+		prefix = string(m.glob.Prefix())
+	}
+	return prefix
+}
+
+// numConditions returns the amount of conditions of the matcher itself
+func (m *Matcher) numConditions() int {
+	num := 0
+	for _, cond := range []string{m.Prefix, m.Sub, m.Regex, m.Glob, m.NotPrefix, m.NotSub, m.NotRegex} {
+		if cond != "" {
+			num++
+		}
+	}
+	return num
+}
+
+// unanchor returns the regex without the ^ at its start, if it has one
+func unanchor(regex string) (string, bool) {
+	re, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil || re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return "", false
+	}
+	rest := &syntax.Regexp{Op: syntax.OpConcat, Flags: re.Flags, Sub: re.Sub[1:]}
+	return rest.String(), true
+}
+
+// first returns the index of a rule that matches buf, or -1 if none does.
+// when several rules match, it's not necessarily the first of them.
+func (idx *ruleIndex) first(buf []byte) int {
+	var arr [8]int
+	for _, i := range idx.prefixes.lookup(buf, arr[:0]) {
+		if idx.exact[i] || idx.matchers[i].Match(buf) {
+			return i
+		}
+	}
+	if idx.regex != nil && idx.regex.Match(buf) {
+		for _, i := range idx.regexRules {
+			if idx.matchers[i].Match(buf) {
+				return i
+			}
+		}
+	}
+	for _, i := range idx.rest {
+		if idx.matchers[i].Match(buf) {
+			return i
+		}
+	}
+	return -1
+}
+
+// all appends the indices of the rules that match buf to dst, in order
+func (idx *ruleIndex) all(buf []byte, dst []int) []int {
+	start := len(dst)
+	var arr [8]int
+	for _, i := range idx.prefixes.lookup(buf, arr[:0]) {
+		if idx.exact[i] || idx.matchers[i].Match(buf) {
+			dst = append(dst, i)
+		}
+	}
+	if idx.regex != nil && idx.regex.Match(buf) {
+		for _, i := range idx.regexRules {
+			if idx.matchers[i].Match(buf) {
+				dst = append(dst, i)
+			}
+		}
+	}
+	for _, i := range idx.rest {
+		if idx.matchers[i].Match(buf) {
+			dst = append(dst, i)
+		}
+	}
+	// insertion sort: there's usually just a few
+	matched := dst[start:]
+	for i := 1; i < len(matched); i++ {
+		for j := i; j > 0 && matched[j] < matched[j-1]; j-- {
+			matched[j], matched[j-1] = matched[j-1], matched[j]
+		}
+	}
+	return dst
+}
+
+// trieNode is a node of a trie of prefixes, holding the rules whose prefix ends here
+type trieNode struct {
+	edges    []byte // sorted
+	children []*trieNode
+	rules    []int
+}
+
+func (n *trieNode) insert(prefix string, rule int) {
+	for i := 0; i < len(prefix); i++ {
+		pos := n.search(prefix[i])
+		if pos == len(n.edges) || n.edges[pos] != prefix[i] {
+			n.edges = append(n.edges, 0)
+			copy(n.edges[pos+1:], n.edges[pos:])
+			n.edges[pos] = prefix[i]
+			n.children = append(n.children, nil)
+			copy(n.children[pos+1:], n.children[pos:])
+			n.children[pos] = &trieNode{}
+		}
+		n = n.children[pos]
+	}
+	n.rules = append(n.rules, rule)
+}
+
+// search returns the position of c in the edges, or where it would go
+func (n *trieNode) search(c byte) int {
+	lo, hi := 0, len(n.edges)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.edges[mid] < c {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// lookup appends the rules of all prefixes of s to dst
+func (n *trieNode) lookup(s []byte, dst []int) []int {
+	for i := 0; ; i++ {
+		dst = append(dst, n.rules...)
+		if i == len(s) || len(n.edges) == 0 {
+			return dst
+		}
+		pos := n.search(s[i])
+		if pos == len(n.edges) || n.edges[pos] != s[i] {
+			return dst
+		}
+		n = n.children[pos]
+	}
+}
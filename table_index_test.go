@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+// the index must give the same results as matching the rules one by one
+func TestRuleIndex(t *testing.T) {
+	var rules []*Matcher
+	add := func(m *Matcher) {
+		if err := m.compile(); err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, m)
+	}
+	for i := 0; i < 20; i++ {
+		add(&Matcher{Prefix: fmt.Sprintf("p%d.", i)})
+	}
+	add(&Matcher{Prefix: "p1"})
+	add(&Matcher{Prefix: "p1.", NotSub: ".test."})
+	add(&Matcher{Glob: "p2.*.cpu"})
+	add(&Matcher{Regex: "^re[0-9]+\\.foo"})
+	add(&Matcher{Regex: "^(?i)RE[0-9]+\\.bar"})
+	add(&Matcher{Regex: "^a|^b"})
+	add(&Matcher{Regex: "(?m)^multi"})
+	add(&Matcher{Regex: "\\.unanchored\\."})
+	add(&Matcher{Sub: "secret"})
+	add(&Matcher{Or: []*Matcher{{Prefix: "or1."}, {Prefix: "or2."}}})
+	idx := newRuleIndex(rules)
+	assert.Equal(t, true, idx.regex != nil)
+	assert.Equal(t, true, len(idx.rest) < len(rules)/2)
+
+	for _, line := range []string{
+		"p1.foo 1 1", "p1.test.foo 1 1", "p10.foo 1 1", "p1 1 1", "p2.web.cpu 1 1", "p2.web.mem 1 1",
+		"re12.foo 1 1", "re12.bar 1 1", "xre12.foo 1 1", "a.b 1 1", "bb 1 1", "multi 1 1", "x.unanchored.y 1 1",
+		"x.secret 1 1", "or2.x 1 1", "none 1 1",
+	} {
+		buf := []byte(line)
+		var exp []int
+		for i, m := range rules {
+			if m.Match(buf) {
+				exp = append(exp, i)
+			}
+		}
+		got := idx.all(buf, nil)
+		assert.Equal(t, fmt.Sprint(exp), fmt.Sprint(got), line)
+		first := idx.first(buf)
+		assert.Equal(t, len(exp) > 0, first >= 0, line)
+		if first >= 0 {
+			assert.Equal(t, true, rules[first].Match(buf), line)
+		}
+	}
+}
+
+func TestTableIndexUpdates(t *testing.T) {
+	var cmds []string
+	for i := 0; i < minIndexRules; i++ {
+		cmds = append(cmds, fmt.Sprintf("addBlack prefix black%d.", i))
+	}
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch main prefix=a.  127.0.0.1:0 prometheus=true")
+	defer table.ShutdownOrFatal(t)
+	for _, cmd := range cmds {
+		assert.Equal(t, nil, applyCommand(table, cmd))
+	}
+	for i := 0; i < minIndexRules; i++ {
+		route, err := NewRouteSendAllMatch(fmt.Sprintf("r%d", i), Matcher{Prefix: fmt.Sprintf("r%d.", i), prefix: []byte(fmt.Sprintf("r%d.", i))}, make([]*Destination, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		table.AddRoute(route)
+	}
+	written := func() string {
+		return promMetrics(t, table, "main")
+	}
+	blacklisted := table.numBlacklist.Count()
+	table.Dispatch([]byte("black3.foo 1 1"))
+	assert.Equal(t, blacklisted+1, table.numBlacklist.Count())
+
+	table.Dispatch([]byte("a.foo 1 1"))
+	table.Dispatch([]byte("b.foo 1 1"))
+	assert.Equal(t, true, strings.Contains(written(), "a_foo"))
+	assert.Equal(t, false, strings.Contains(written(), "b_foo"))
+
+	// the index follows changes to the matchers of routes
+	assert.Equal(t, nil, applyCommand(table, "modRoute main prefix=b."))
+	table.Dispatch([]byte("b.foo 1 1"))
+	assert.Equal(t, true, strings.Contains(written(), "b_foo"))
+	assert.Equal(t, nil, table.UpdateRoute("main", map[string]string{"prefix": "c."}))
+	table.Dispatch([]byte("c.foo 1 1"))
+	assert.Equal(t, true, strings.Contains(written(), "c_foo"))
+}
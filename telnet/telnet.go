@@ -10,6 +10,9 @@ import (
 
 var muxList []route
 
+// closeFunc, if set, is called when a conn is closed, with its session
+var closeFunc func(session map[string]interface{})
+
 type adminFunc func(Req) error
 
 type route struct {
@@ -19,7 +22,8 @@ type route struct {
 
 type Req struct {
 	Command []string
-	Conn    *net.Conn // user api connection
+	Conn    *net.Conn              // user api connection
+	Session map[string]interface{} // state that lives as long as the connection, i.e. who logged in
 }
 
 func init() {
@@ -30,6 +34,11 @@ func HandleFunc(prefix string, fn adminFunc) {
 	muxList = append(muxList, route{prefix, fn})
 }
 
+// HandleClose registers fn to be called when a conn is closed, i.e. to clean up its session
+func HandleClose(fn func(session map[string]interface{})) {
+	closeFunc = fn
+}
+
 func getHandler(cmd string) (fn adminFunc) {
 	for _, route := range muxList {
 		if strings.HasPrefix(cmd, route.prefix) {
@@ -43,6 +52,11 @@ func ListenAndServe(addr string) error {
 	if err != nil {
 		return err
 	}
+	return Serve(l)
+}
+
+// Serve handles the conns accepted on l
+func Serve(l net.Listener) error {
 	defer l.Close()
 	for {
 		// Listen for an incoming connection.
@@ -52,12 +66,12 @@ func ListenAndServe(addr string) error {
 		}
 		go handleApiRequest(conn)
 	}
-	return nil
 }
 
 func handleApiRequest(conn net.Conn) {
 	// Make a buffer to hold incoming data.
 	buf := make([]byte, 1024)
+	session := make(map[string]interface{})
 	// Read the incoming connection into the buffer.
 	for {
 		n, err := conn.Read(buf)
@@ -68,12 +82,19 @@ func handleApiRequest(conn net.Conn) {
 				fmt.Println("Error reading:", err.Error())
 			}
 			conn.Close()
+			if closeFunc != nil {
+				closeFunc(session)
+			}
 			break
 		}
 		clean_cmd := strings.TrimSpace(string(buf[:n]))
 		command := strings.Split(clean_cmd, " ")
-		log.Println("received command: '" + clean_cmd + "'")
-		req := Req{command, &conn}
+		if strings.HasPrefix(clean_cmd, "login ") {
+			log.Println("received command: 'login'") // don't log credentials
+		} else {
+			log.Println("received command: '" + clean_cmd + "'")
+		}
+		req := Req{command, &conn, session}
 		fn := getHandler(clean_cmd)
 		if fn != nil {
 			err := fn(req)
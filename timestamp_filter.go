@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/Dieterbe/go-metrics"
+	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
+)
+
+// timestampFilterConfig configures what the table does with datapoints with a timestamp too far in the future or past
+type timestampFilterConfig struct {
+	Max_future string // "" or "0s" to disable
+	Max_age    string // "" or "0s" to disable
+	Action     string // drop, clamp (set the timestamp to now) or route
+	Route      string // key of the quarantine route, for action route
+}
+
+// timestampFilter catches datapoints with a timestamp outside of [now - maxAge, now + maxFuture]
+type timestampFilter struct {
+	maxFuture time.Duration
+	maxAge    time.Duration
+	action    string
+	route     string
+	numFuture metrics.Counter
+	numStale  metrics.Counter
+}
+
+// reasons a timestamp is filtered
+const (
+	tsFuture = "future"
+	tsStale  = "stale"
+)
+
+// newTimestampFilter returns the filter for the config, or nil if it doesn't filter anything
+func newTimestampFilter(conf timestampFilterConfig) (*timestampFilter, error) {
+	f := &timestampFilter{
+		action: conf.Action,
+		route:  conf.Route,
+	}
+	var err error
+	if conf.Max_future != "" {
+		f.maxFuture, err = time.ParseDuration(conf.Max_future)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse max_future: %s", err)
+		}
+	}
+	if conf.Max_age != "" {
+		f.maxAge, err = time.ParseDuration(conf.Max_age)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse max_age: %s", err)
+		}
+	}
+	if f.maxFuture < 0 || f.maxAge < 0 {
+		return nil, fmt.Errorf("max_future and max_age can't be negative")
+	}
+	switch f.action {
+	case "drop", "clamp":
+	case "route":
+		if f.route == "" {
+			return nil, fmt.Errorf("action route needs a route")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action '%s'. valid actions are drop, clamp and route", f.action)
+	}
+	if f.maxFuture == 0 && f.maxAge == 0 {
+		return nil, nil
+	}
+	// the counters show what was done with the datapoints, like the other action=... counters
+	f.numFuture = Counter("unit=Metric.action=" + f.action + ".reason=ts_future")
+	f.numStale = Counter("unit=Metric.action=" + f.action + ".reason=ts_stale")
+	return f, nil
+}
+
+// check returns the reason the timestamp of the (validated) line is out of bounds, or "" if it's fine.
+func (f *timestampFilter) check(fields [][]byte, now time.Time) string {
+	ts, err := strconv.ParseInt(string(fields[2]), 10, 64)
+	if err != nil {
+		return ""
+	}
+	if f.maxFuture != 0 && ts > now.Add(f.maxFuture).Unix() {
+		return tsFuture
+	}
+	if f.maxAge != 0 && ts < now.Add(-f.maxAge).Unix() {
+		return tsStale
+	}
+	return ""
+}
+
+// report counts a datapoint we caught, and records it in the bad metrics
+func (f *timestampFilter) report(buf []byte, fields [][]byte, reason string, peer string, now time.Time) {
+	ts, _ := strconv.ParseInt(string(fields[2]), 10, 64)
+	var err error
+	var errType string
+	if reason == tsFuture {
+		f.numFuture.Inc(1)
+		errType = badmetrics.ErrTsFuture
+		err = fmt.Errorf("timestamp %d is %s in the future (%s)", ts, time.Unix(ts, 0).Sub(now), f.action)
+	} else {
+		f.numStale.Inc(1)
+		errType = badmetrics.ErrTsStale
+		err = fmt.Errorf("timestamp %d is %s old (%s)", ts, now.Sub(time.Unix(ts, 0)), f.action)
+	}
+	badMetrics.Add(fields[0], buf, err, errType, peer)
+}
+
+// clamp returns a copy of the line with the timestamp set to now
+func clamp(fields [][]byte, now time.Time) []byte {
+	return bytes.Join([][]byte{fields[0], fields[1], []byte(strconv.FormatInt(now.Unix(), 10))}, space)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
+)
+
+func TestTimestampFilterConfig(t *testing.T) {
+	f, err := newTimestampFilter(timestampFilterConfig{Action: "drop"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, (*timestampFilter)(nil), f)
+	for _, conf := range []timestampFilterConfig{
+		{Max_future: "1h", Action: "bogus"},
+		{Max_future: "1x", Action: "drop"},
+		{Max_age: "-1h", Action: "drop"},
+		{Max_age: "1h", Action: "route"},
+	} {
+		if _, err := newTimestampFilter(conf); err == nil {
+			t.Fatalf("expected an error for %+v", conf)
+		}
+	}
+}
+
+func TestTimestampFilterCheck(t *testing.T) {
+	f, err := newTimestampFilter(timestampFilterConfig{Max_future: "10m", Max_age: "24h", Action: "clamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+	cases := map[int64]string{
+		1500000000:         "",
+		1500000000 + 600:   "",
+		1500000000 + 601:   tsFuture,
+		1500000000 - 86400: "",
+		1500000000 - 86401: tsStale,
+	}
+	for ts, exp := range cases {
+		fields := bytes.Fields([]byte(fmt.Sprintf("foo 1 %d", ts)))
+		assert.Equal(t, exp, f.check(fields, now), ts)
+	}
+	assert.Equal(t, "foo 1.5 1500000000", string(clamp(bytes.Fields([]byte("foo 1.5 1")), now)))
+}
+
+func TestTimestampFilterDispatch(t *testing.T) {
+	initIngest()
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch main  127.0.0.1:0 prometheus=true")
+	defer table.ShutdownOrFatal(t)
+	if err := applyCommand(table, "addRoute sendAllMatch quarantine  127.0.0.1:0 prometheus=true"); err != nil {
+		t.Fatal(err)
+	}
+	written := func(key string) string {
+		return promMetrics(t, table, key)
+	}
+	now := time.Now().Unix()
+	good := []byte(fmt.Sprintf("good 1 %d", now))
+	future := []byte(fmt.Sprintf("future 1 %d", now+7200))
+	stale := []byte(fmt.Sprintf("stale 1 %d", now-7200))
+
+	f, err := newTimestampFilter(timestampFilterConfig{Max_future: "1h", Max_age: "1h", Action: "route", Route: "quarantine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.SetTimestampFilter(f)
+	futureBefore := f.numFuture.Count()
+	staleBefore := f.numStale.Count()
+	table.DispatchFrom(good, "10.0.0.1")
+	table.DispatchFrom(future, "10.0.0.1")
+	table.DispatchFrom(stale, "10.0.0.2")
+	assert.Equal(t, "# TYPE good untyped\ngood 1\n", written("main"))
+	assert.Equal(t, "# TYPE future untyped\nfuture 1\n# TYPE stale untyped\nstale 1\n", written("quarantine"))
+	assert.Equal(t, int64(1), f.numFuture.Count()-futureBefore)
+	assert.Equal(t, int64(1), f.numStale.Count()-staleBefore)
+	// the counters are named after what's done with the datapoints
+	assert.Equal(t, true, f.numFuture == Counter("unit=Metric.action=route.reason=ts_future"))
+	assert.Equal(t, true, f.numStale == Counter("unit=Metric.action=route.reason=ts_stale"))
+	time.Sleep(10 * time.Millisecond)
+	records := badMetrics.GetFiltered(badmetrics.Filter{ErrType: badmetrics.ErrTsStale})
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "stale", records[0].Metric)
+	assert.Equal(t, "10.0.0.2", records[0].Peer)
+
+	f, err = newTimestampFilter(timestampFilterConfig{Max_future: "1h", Action: "drop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.SetTimestampFilter(f)
+	table.DispatchFrom([]byte(fmt.Sprintf("dropped 1 %d", now+7200)), "10.0.0.1")
+	// without max_age, old points pass
+	table.DispatchFrom([]byte(fmt.Sprintf("old 1 %d", now-7200)), "10.0.0.1")
+	assert.Equal(t, "# TYPE good untyped\ngood 1\n# TYPE old untyped\nold 1\n", written("main"))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// roundRobin and weighted routes spread the metrics over their destinations, without
+// looking at the metrics: roundRobin gives every dest the same share, weighted gives
+// every dest a share in proportion to its weight. dests without a connection are
+// skipped, so that their share goes to the others until they come back.
+
+type weightedRouteConfig struct {
+	baseRouteConfig
+	// schedule holds indexes into the dests, every dest occurring as often as its weight,
+	// spread out as evenly as possible. going through it once sends every dest its share.
+	schedule []int
+	weights  []int64
+}
+
+type RouteWeighted struct {
+	next uint64 // accessed atomically, first for 64-bit alignment. position in the schedule
+	baseRoute
+	roundRobin bool // all dests get the same weight, regardless of their weight option
+}
+
+// NewRouteRoundRobin creates a roundRobin route.
+// Note that it still needs to be told to run via Run(), which runs the given destinations
+func NewRouteRoundRobin(key string, matcher Matcher, destinations []*Destination) (Route, error) {
+	return newRouteWeighted(key, matcher, destinations, true), nil
+}
+
+// NewRouteWeighted creates a weighted route.
+// Note that it still needs to be told to run via Run(), which runs the given destinations
+func NewRouteWeighted(key string, matcher Matcher, destinations []*Destination) (Route, error) {
+	return newRouteWeighted(key, matcher, destinations, false), nil
+}
+
+func newRouteWeighted(key string, matcher Matcher, destinations []*Destination, roundRobin bool) *RouteWeighted {
+	r := &RouteWeighted{
+		baseRoute:  baseRoute{sync.Mutex{}, atomic.Value{}, key, newRuleHits("route", key)},
+		roundRobin: roundRobin,
+	}
+	r.config.Store(r.extendConfig(baseRouteConfig{matcher, destinations}))
+	return r
+}
+
+// extendConfig computes the schedule for the dests, using smooth weighted round robin:
+// every step each dest gains its weight, and the dest that gained the most so far is picked
+// and loses the total weight. for weights 5,1,1 that gives 0,0,1,0,2,0,0 rather than 0,0,0,0,0,1,2
+func (route *RouteWeighted) extendConfig(baseConfig baseRouteConfig) RouteConfig {
+	weights := make([]int64, len(baseConfig.dests))
+	var total int64
+	for i, dest := range baseConfig.dests {
+		weights[i] = 1
+		if !route.roundRobin {
+			weights[i] = dest.GetWeight()
+		}
+		total += weights[i]
+	}
+	schedule := make([]int, 0, total)
+	current := make([]int64, len(weights))
+	for len(schedule) < int(total) {
+		best := 0
+		for i, w := range weights {
+			current[i] += w
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, best)
+	}
+	return weightedRouteConfig{baseConfig, schedule, weights}
+}
+
+func (route *RouteWeighted) Dispatch(buf []byte) {
+	route.hits.hit()
+	conf := route.config.Load().(weightedRouteConfig)
+	if len(conf.schedule) == 0 {
+		return
+	}
+	n := atomic.AddUint64(&route.next, 1)
+	dest := conf.dests[conf.schedule[n%uint64(len(conf.schedule))]]
+	if !dest.IsOnline() {
+		if online := conf.pickOnline(n); online != nil {
+			dest = online
+		}
+		// if all of them are offline, we leave it to the dest to spool or drop
+	}
+	// dest should handle this as quickly as it can
+	log.Info("route %s sending to dest %s: %s", route.key, dest.Addr, buf)
+	dest.in <- buf
+}
+
+// pickOnline picks one of the online dests, in proportion to their weights.
+// it returns nil if none of them are online
+func (conf weightedRouteConfig) pickOnline(n uint64) *Destination {
+	var total int64
+	for i, dest := range conf.dests {
+		if dest.IsOnline() {
+			total += conf.weights[i]
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	pos := int64(n % uint64(total))
+	for i, dest := range conf.dests {
+		if !dest.IsOnline() {
+			continue
+		}
+		if pos < conf.weights[i] {
+			return dest
+		}
+		pos -= conf.weights[i]
+	}
+	return nil
+}
+
+func (route *RouteWeighted) Add(dest *Destination) {
+	route.addDestination(dest, route.extendConfig)
+}
+
+func (route *RouteWeighted) DelDestination(index int) error {
+	return route.delDestination(index, route.extendConfig)
+}
+
+func (route *RouteWeighted) Update(opts map[string]string) error {
+	return route.update(opts, route.extendConfig)
+}
+
+// UpdateDestination also picks up changes of the weight of the dest
+func (route *RouteWeighted) UpdateDestination(index int, opts map[string]string) error {
+	return route.updateDestination(index, opts, route.extendConfig)
+}
+
+func (route *RouteWeighted) UpdateMatcher(matcher Matcher) {
+	route.updateMatcher(matcher, route.extendConfig)
+}
+
+func (route *RouteWeighted) Snapshot() RouteSnapshot {
+	if route.roundRobin {
+		return makeSnapshot(&route.baseRoute, "roundRobin")
+	}
+	return makeSnapshot(&route.baseRoute, "weighted")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+)
+
+// newTestWeightedRoute creates a weighted (or roundRobin) route with dests of the given weights,
+// without running them. the metrics for each dest can be read from its in channel.
+func newTestWeightedRoute(t *testing.T, roundRobin bool, weights ...int64) (*RouteWeighted, []*Destination) {
+	var dests []*Destination
+	for _, w := range weights {
+		dest, err := NewDestination(Matcher{}, "127.0.0.1:2005", "", false, false, false, "drop", time.Second, time.Second)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, dest.SetWeight(w))
+		dest.in = make(chan []byte, 1000)
+		dest.setOnline(true)
+		dests = append(dests, dest)
+	}
+	route := &RouteWeighted{baseRoute: baseRoute{key: "lb"}, roundRobin: roundRobin}
+	route.config.Store(route.extendConfig(baseRouteConfig{Matcher{}, dests}))
+	return route, dests
+}
+
+func dispatchAndCount(route *RouteWeighted, dests []*Destination, n int) []int {
+	for i := 0; i < n; i++ {
+		route.Dispatch([]byte("foo 1 1"))
+	}
+	counts := make([]int, len(dests))
+	for i, dest := range dests {
+		counts[i] = len(dest.in)
+		for len(dest.in) > 0 {
+			<-dest.in
+		}
+	}
+	return counts
+}
+
+func TestWeightedSchedule(t *testing.T) {
+	route, _ := newTestWeightedRoute(t, false, 5, 1, 1)
+	assert.Equal(t, []int{0, 0, 1, 0, 2, 0, 0}, route.config.Load().(weightedRouteConfig).schedule)
+
+	route, _ = newTestWeightedRoute(t, true, 5, 1, 1)
+	assert.Equal(t, []int{0, 1, 2}, route.config.Load().(weightedRouteConfig).schedule)
+}
+
+func TestWeightedDispatch(t *testing.T) {
+	route, dests := newTestWeightedRoute(t, false, 3, 1)
+	assert.Equal(t, []int{300, 100}, dispatchAndCount(route, dests, 400))
+
+	route, dests = newTestWeightedRoute(t, true, 3, 1)
+	assert.Equal(t, []int{200, 200}, dispatchAndCount(route, dests, 400))
+}
+
+func TestWeightedSkipsOffline(t *testing.T) {
+	route, dests := newTestWeightedRoute(t, false, 2, 1, 1)
+	// the share of the offline dest is spread over the others, according to their weights
+	dests[0].setOnline(false)
+	assert.Equal(t, []int{0, 200, 200}, dispatchAndCount(route, dests, 400))
+
+	dests[1].setOnline(false)
+	assert.Equal(t, []int{0, 0, 400}, dispatchAndCount(route, dests, 400))
+
+	// if all of them are offline, it's up to the dests to spool or drop
+	dests[2].setOnline(false)
+	assert.Equal(t, []int{200, 100, 100}, dispatchAndCount(route, dests, 400))
+}
+
+func TestWeightedCommands(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute weighted lb prefix=a.  127.0.0.1:0 weight=3  127.0.0.1:0")
+	defer table.ShutdownOrFatal(t)
+	assert.Equal(t, nil, applyCommand(table, "addRoute roundRobin rr  127.0.0.1:0"))
+
+	snap := table.Snapshot()
+	assert.Equal(t, "weighted", snap.Routes[0].Type)
+	assert.Equal(t, int64(3), snap.Routes[0].Dests[0].Weight)
+	assert.Equal(t, int64(1), snap.Routes[0].Dests[1].Weight)
+	assert.Equal(t, "roundRobin", snap.Routes[1].Type)
+
+	// changing the weight changes the schedule
+	assert.Equal(t, nil, applyCommand(table, "modDest lb 1 weight=2"))
+	assert.Equal(t, int64(2), table.Snapshot().Routes[0].Dests[1].Weight)
+	route := table.GetRoute("lb").(*RouteWeighted)
+	assert.Equal(t, 5, len(route.config.Load().(weightedRouteConfig).schedule))
+
+	for _, cmd := range []string{
+		"modDest lb 1 weight=0",
+		"addRoute weighted bad  127.0.0.1:0 weight=1001",
+		"addRoute weighted bad  127.0.0.1:0 prefix=a.",
+		"addRoute roundRobin bad",
+	} {
+		if applyCommand(table, cmd) == nil {
+			t.Fatalf("expected an error for %q", cmd)
+		}
+	}
+	assert.Equal(t, 2, len(table.Snapshot().Routes))
+}
+
+func TestWeightedUndo(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute weighted lb  127.0.0.1:0 weight=3  127.0.0.1:0")
+	defer table.ShutdownOrFatal(t)
+	openTestHistory(t, historyConfig{})
+	err := history.track(table, "telnet", "", "modDest lb 0 weight=7", func() error {
+		return applyCommand(table, "modDest lb 0 weight=7")
+	})
+	assert.Equal(t, nil, err)
+	entries := history.list(0)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, nil, history.undo(table, "telnet", "", entries[0].ID))
+	assert.Equal(t, int64(3), table.Snapshot().Routes[0].Dests[0].Weight)
+}
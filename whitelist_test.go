@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/bmizerany/assert"
+	"github.com/graphite-ng/carbon-relay-ng/_third_party/github.com/gorilla/mux"
+)
+
+func TestWhitelistDispatch(t *testing.T) {
+	table := NewTableOrFatal(t, "", "addRoute sendAllMatch main  127.0.0.1:0 prometheus=true")
+	defer table.ShutdownOrFatal(t)
+	written := func() string {
+		return promMetrics(t, table, "main")
+	}
+	for _, cmd := range []string{
+		"addWhite prefix tenant1.",
+		"addWhite regex ^tenant2\\.",
+		"addBlack sub secret",
+	} {
+		if err := applyCommand(table, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := table.numWhitelist.Count()
+	table.Dispatch([]byte("tenant1.a 1 1"))
+	table.Dispatch([]byte("tenant2.b 2 1"))
+	table.Dispatch([]byte("tenant3.c 3 1"))
+	// the blacklist goes first
+	table.Dispatch([]byte("tenant1.secret 4 1"))
+	assert.Equal(t, "# TYPE tenant1_a untyped\ntenant1_a 1\n# TYPE tenant2_b untyped\ntenant2_b 2\n", written())
+	assert.Equal(t, int64(1), table.numWhitelist.Count()-before)
+
+	// without whitelist entries, everything is accepted
+	assert.Equal(t, nil, applyCommand(table, "delWhite 1"))
+	assert.Equal(t, "tenant1.", table.Snapshot().Whitelist[0].Prefix)
+	assert.Equal(t, nil, applyCommand(table, "delWhite 0"))
+	if applyCommand(table, "delWhite 0") == nil {
+		t.Fatal("expected an error deleting a whitelist entry that doesn't exist")
+	}
+	table.Dispatch([]byte("tenant3.c 3 1"))
+	assert.Equal(t, "# TYPE tenant1_a untyped\ntenant1_a 1\n# TYPE tenant2_b untyped\ntenant2_b 2\n# TYPE tenant3_c untyped\ntenant3_c 3\n", written())
+
+	for _, cmd := range []string{"addWhite bogus foo", "addWhite", "delWhite", "delWhite x"} {
+		if applyCommand(table, cmd) == nil {
+			t.Fatalf("expected an error for '%s'", cmd)
+		}
+	}
+}
+
+func TestWhitelistHTTP(t *testing.T) {
+	table = NewTableOrFatal(t, "", "")
+	router := mux.NewRouter()
+	router.Handle("/whitelists", handler(addWhitelist)).Methods("POST")
+	router.Handle("/whitelists/{index}", handler(removeWhitelist)).Methods("DELETE")
+	req := func(method, url, body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, url, bytes.NewBufferString(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, req("POST", "/whitelists", `{"prefix": "foo."}`))
+	assert.Equal(t, http.StatusBadRequest, req("POST", "/whitelists", `{}`))
+	assert.Equal(t, http.StatusBadRequest, req("POST", "/whitelists", `{"regex": "("}`))
+	assert.Equal(t, 1, len(table.Snapshot().Whitelist))
+	assert.Equal(t, http.StatusNotFound, req("DELETE", "/whitelists/1", ""))
+	assert.Equal(t, http.StatusOK, req("DELETE", "/whitelists/0", ""))
+	assert.Equal(t, 0, len(table.Snapshot().Whitelist))
+}
+
+func TestWhitelistUndo(t *testing.T) {
+	table := NewTableOrFatal(t, "", "")
+	openTestHistory(t, historyConfig{})
+	err := history.track(table, "telnet", "", "addWhite prefix foo", func() error {
+		return applyCommand(table, "addWhite prefix foo")
+	})
+	assert.Equal(t, nil, err)
+	entries := history.list(0)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "whitelist", entries[0].Changes[0].Part)
+	assert.Equal(t, nil, history.undo(table, "telnet", "", entries[0].ID))
+	assert.Equal(t, 0, len(table.Snapshot().Whitelist))
+}